@@ -13,6 +13,9 @@ import { SESSION_KEY_HTTP_HEADER_VALUE, getCgi } from './AuthorizedAccess';
 import ProgressModel from './ProgressModel';
 
 const DRONE_HEALTH_DESCS = ['-', 'OK', 'NG'];
+const FLIGHT_STATE_DESCS = ['-', 'connected', 'landed', 'taking off', 'flying', 'landing', 'emergency', 'failsafe'];
+const BATTERY_WARNING_DESCS = ['', 'low', 'critical'];
+const LINK_STATE_DESCS = ['-', 'connected', 'lost', 'landed'];
 
 const STATE_CONNECTION_RETRY_INTERVAL_MILLIS = 500;
 const STATE_CONNECTION_MAX_RETRY = 10;
@@ -42,22 +45,96 @@ enum DroneHealthState {
 	Ng
 }
 
-enum DroneState {
-    Init,
-    Ready,
-	Land,
-	TakeOff
+enum FlightState {
+    Disconnected,
+    Connected,
+    Landed,
+    TakingOff,
+    Flying,
+    Landing,
+    Emergency,
+    Failsafe
+}
+
+enum BatteryWarning {
+    None,
+    Low,
+    Critical
+}
+
+enum LinkState {
+    Unknown,
+    Connected,
+    Lost,
+    Landed
 }
 
+type Telemetry = {
+    height: number,
+    groundSpeed: number,
+    wifiStrength: number,
+    flightTime: number,
+    timestamp: number
+};
+
 
 class DroneHealth {
 
     private _health: DroneHealthState;
     private _batteryLevel: BatteryLevelWarningState;
+    private _flightState: FlightState;
+    private _batteryWarning: BatteryWarning;
+    private _linkState: LinkState;
+    private _telemetry: Telemetry | undefined;
 
     constructor() {
         this._health = DroneHealthState.Unknown;
         this._batteryLevel = BatteryLevelWarningState.Unknown;
+        this._flightState = FlightState.Disconnected;
+        this._batteryWarning = BatteryWarning.None;
+        this._linkState = LinkState.Unknown;
+        this._telemetry = undefined;
+    }
+
+    setBatteryWarning(_batteryWarning: number): void {
+        this._batteryWarning = _batteryWarning;
+    }
+
+    getBatteryWarningInfo(): { state: BatteryWarning, desc: string } {
+        return { state: this._batteryWarning, desc: BATTERY_WARNING_DESCS[this._batteryWarning] || '' };
+    }
+
+    setLinkState(_linkState: number): void {
+        this._linkState = _linkState;
+    }
+
+    getLinkStateInfo(): { state: LinkState, desc: string } {
+        return { state: this._linkState, desc: LINK_STATE_DESCS[this._linkState] || '-' };
+    }
+
+    setTelemetry(_telemetry: Telemetry | undefined): void {
+        this._telemetry = _telemetry;
+    }
+
+    getTelemetryInfo(): { height: string, speed: string, wifi: string, flightTime: string } {
+        const telemetry = this._telemetry;
+        if (this._health !== DroneHealthState.Ok || !telemetry) {
+            return { height: '-', speed: '-', wifi: '-', flightTime: '-' };
+        }
+        return {
+            height: `${telemetry.height.toFixed(1)}m`,
+            speed: `${telemetry.groundSpeed.toFixed(1)}m/s`,
+            wifi: `${telemetry.wifiStrength}%`,
+            flightTime: `${Math.floor(telemetry.flightTime)}s`
+        };
+    }
+
+    setFlightState(_flightState: number): void {
+        this._flightState = _flightState;
+    }
+
+    getFlightStateInfo(): { state: FlightState, desc: string } {
+        return { state: this._flightState, desc: FLIGHT_STATE_DESCS[this._flightState] || '-' };
     }
 
     setData(_health: number, _batteryLevel: number): void {
@@ -88,7 +165,7 @@ class DroneHealth {
     }
 }
 
-export { DroneHealthState, BatteryLevelWarningState };
+export { DroneHealthState, BatteryLevelWarningState, FlightState, BatteryWarning, LinkState };
 
 export default class ApplicationStatesModel {
     
@@ -101,6 +178,7 @@ export default class ApplicationStatesModel {
 
     private applicationState: ApplicationState;
     private readonly droneHealth: DroneHealth;
+    private droneId: string | undefined;
 
     private websocket: WebSocket | undefined;
 
@@ -124,6 +202,7 @@ export default class ApplicationStatesModel {
 
         this.applicationState = ApplicationState.Init;
         this.droneHealth = new DroneHealth();
+        this.droneId = undefined;
 
         this.websocket = undefined;
         this.retryTimer = undefined;
@@ -191,6 +270,10 @@ export default class ApplicationStatesModel {
                     this.droneHealth.setData(
                         DroneHealthState.Unknown, BatteryLevelWarningState.Unknown
                     );
+                    this.droneHealth.setFlightState(FlightState.Disconnected);
+                    this.droneHealth.setBatteryWarning(BatteryWarning.None);
+                    this.droneHealth.setLinkState(LinkState.Unknown);
+                    this.droneHealth.setTelemetry(undefined);
 
                     this.progressModel.endProcessing();
                     this.viewStateModel.toInit();
@@ -201,28 +284,32 @@ export default class ApplicationStatesModel {
                 this.droneHealth.setData(
                     dataJson.droneHealth.health, dataJson.droneHealth.batteryLevel
                 );
+                this.droneHealth.setFlightState(dataJson.flightState);
+                this.droneHealth.setBatteryWarning(dataJson.batteryWarning);
+                this.droneHealth.setLinkState(dataJson.linkState);
+                // The top level has the states of the default drone, whose telemetry is rendered.
+                this.droneId = dataJson.droneId;
 
-
-                switch(dataJson.droneState) {
-                case DroneState.Ready:
+                if (dataJson.peerConnected && this.droneHealth.getHealthInfo().state === DroneHealthState.Ok) {
+                    this.progressModel.endProcessing();
+                    this.viewStateModel.toLand();
+                } else {
                     this.progressModel.startProcessing();
                     this.viewStateModel.toReady();
-                    break;
-
-                case DroneState.Land:
-                    if (this.droneHealth.getHealthInfo().state === DroneHealthState.Ok) {
-                        this.progressModel.endProcessing();
-                        this.viewStateModel.toLand();
-                    } else {
-                        this.progressModel.startProcessing();
-                        this.viewStateModel.toReady();
-                    }
-                    break;
-                default:
                 }
 
                 CommonEventDispatcher.dispatch(CustomEventNames.OJM_DRONE_LOCAL__DRONE_HEALTH_CHECKED);
 
+                break;
+
+            case 'telemetry':
+
+                if (this.applicationState !== ApplicationState.Started || dataJson.droneId !== this.droneId) {
+                    return;
+                }
+                this.droneHealth.setTelemetry(dataJson.telemetry);
+                CommonEventDispatcher.dispatch(CustomEventNames.OJM_DRONE_LOCAL__DRONE_HEALTH_CHECKED);
+
                 break;
             default:
                 return;
@@ -3,7 +3,7 @@ import { CustomEventNames } from './CustomEventNames';
 
 import MainControlModel from './MainControlModel';
 import ApplicationStatesModel from './ApplicationStatesModel';
-import { DroneHealthState, BatteryLevelWarningState} from './ApplicationStatesModel';
+import { DroneHealthState, BatteryLevelWarningState, FlightState, BatteryWarning, LinkState } from './ApplicationStatesModel';
 import ViewStateModel from './ViewStateModel';
 import TabModel from './TabModel';
 
@@ -22,6 +22,12 @@ export default class MainControlView {
 
     private readonly $droneConnection: HTMLSpanElement;
     private readonly $droneBatteryLevel: HTMLSpanElement;
+    private readonly $droneFlightState: HTMLSpanElement;
+    private readonly $droneLinkState: HTMLSpanElement;
+    private readonly $droneHeight: HTMLSpanElement;
+    private readonly $droneSpeed: HTMLSpanElement;
+    private readonly $droneWifi: HTMLSpanElement;
+    private readonly $droneFlightTime: HTMLSpanElement;
 
     private readonly $startKey: HTMLInputElement;
     private readonly $start: HTMLButtonElement;
@@ -42,6 +48,12 @@ export default class MainControlView {
 
         this.$droneConnection = DOM.query('#droneConnection')!; // eslint-disable-line @typescript-eslint/no-non-null-assertion
         this.$droneBatteryLevel = DOM.query('#droneBatteryLevel')!; // eslint-disable-line @typescript-eslint/no-non-null-assertion
+        this.$droneFlightState = DOM.query('#droneFlightState')!; // eslint-disable-line @typescript-eslint/no-non-null-assertion
+        this.$droneLinkState = DOM.query('#droneLinkState')!; // eslint-disable-line @typescript-eslint/no-non-null-assertion
+        this.$droneHeight = DOM.query('#droneHeight')!; // eslint-disable-line @typescript-eslint/no-non-null-assertion
+        this.$droneSpeed = DOM.query('#droneSpeed')!; // eslint-disable-line @typescript-eslint/no-non-null-assertion
+        this.$droneWifi = DOM.query('#droneWifi')!; // eslint-disable-line @typescript-eslint/no-non-null-assertion
+        this.$droneFlightTime = DOM.query('#droneFlightTime')!; // eslint-disable-line @typescript-eslint/no-non-null-assertion
 
         this.$startKey = DOM.query('#startKey')!; // eslint-disable-line @typescript-eslint/no-non-null-assertion
         this.$start = DOM.query('#start')!; // eslint-disable-line @typescript-eslint/no-non-null-assertion
@@ -163,7 +175,52 @@ export default class MainControlView {
         if (batteryLevelInfo.state === BatteryLevelWarningState.High) {
             this.$droneBatteryLevel.classList.add('is-ok');
         }
-        this.$droneBatteryLevel.textContent = batteryLevelInfo.desc;       
+        // The warning of the battery policy takes precedence because it makes the drone land.
+        const batteryWarningInfo = droneHealth.getBatteryWarningInfo();
+        if (batteryWarningInfo.state !== BatteryWarning.None) {
+            this.resetClass(this.$droneBatteryLevel, ...HEALTH_STATES_CLASSES);
+            this.$droneBatteryLevel.classList.add(batteryWarningInfo.state === BatteryWarning.Critical ? 'is-ng' : 'is-warn');
+        }
+        this.$droneBatteryLevel.textContent = batteryWarningInfo.desc ? `${batteryLevelInfo.desc} (${batteryWarningInfo.desc})` : batteryLevelInfo.desc;
+
+        this.resetClass(this.$droneFlightState, ...HEALTH_STATES_CLASSES);
+        const flightStateInfo = droneHealth.getFlightStateInfo();
+        switch (flightStateInfo.state) {
+        case FlightState.Emergency:
+        case FlightState.Failsafe:
+            this.$droneFlightState.classList.add('is-ng');
+            break;
+        case FlightState.TakingOff:
+        case FlightState.Landing:
+            this.$droneFlightState.classList.add('is-warn');
+            break;
+        case FlightState.Landed:
+        case FlightState.Flying:
+            this.$droneFlightState.classList.add('is-ok');
+            break;
+        default:
+        }
+        this.$droneFlightState.textContent = flightStateInfo.desc;
+
+        this.resetClass(this.$droneLinkState, ...HEALTH_STATES_CLASSES);
+        const linkStateInfo = droneHealth.getLinkStateInfo();
+        switch (linkStateInfo.state) {
+        case LinkState.Lost:
+        case LinkState.Landed:
+            this.$droneLinkState.classList.add('is-ng');
+            break;
+        case LinkState.Connected:
+            this.$droneLinkState.classList.add('is-ok');
+            break;
+        default:
+        }
+        this.$droneLinkState.textContent = linkStateInfo.desc;
+
+        const telemetryInfo = droneHealth.getTelemetryInfo();
+        this.$droneHeight.textContent = telemetryInfo.height;
+        this.$droneSpeed.textContent = telemetryInfo.speed;
+        this.$droneWifi.textContent = telemetryInfo.wifi;
+        this.$droneFlightTime.textContent = telemetryInfo.flightTime;
     }
 
     private resetClass($elem: HTMLElement, ...classes: string[]) {
@@ -1,14 +1,16 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"os/signal"
 	"strings"
 	"time"
 
@@ -21,10 +23,16 @@ import (
 	"github.com/unrolled/secure"
 )
 
-var routineCoordinator = RoutineCoordinator{}
+// Controls the signaling connection. Each drone has its own RoutineCoordinator in droneFleet.
+var routineCoordinator *RoutineCoordinator
+
+// Holds the application-wide states. The drone-related states are held by each drone in droneFleet.
 var applicationStates = NewApplicationStates()
 var keyChainManager appos.KeyChainManager
 
+// A snapshot older than this is not provided because the stream has stopped.
+const snapshotMaxAge = 10 * time.Second
+
 func toEndpointUrlWithTrailingSlash() string {
 	endpoint := env.Get("SIGNALING_ENDPOINT")
 	if string(endpoint[len(endpoint)-1]) != "/" {
@@ -67,31 +75,42 @@ func updateAccessToken(w http.ResponseWriter, r *http.Request) (*map[string]inte
 	if err != nil {
 		return nil, err
 	}
-	token := bodyJson["accessToken"]
 
-	req, err := createAuthorizationRequest("validateAccessToken", token)
+	desc, err := validateAndUpdateAccessToken(bodyJson["accessToken"])
 
 	if err != nil {
 		return nil, err
 	}
 
+	return &map[string]interface{}{
+		"accessTokenDesc": desc,
+	}, nil
+}
+
+// Saves the access token if the signaling server accepts it and returns its description.
+func validateAndUpdateAccessToken(token string) (string, error) {
+
+	req, err := createAuthorizationRequest("validateAccessToken", token)
+
+	if err != nil {
+		return "", err
+	}
+
 	client := &http.Client{}
 	res, err := client.Do(req)
 
 	if err != nil || res.StatusCode != 200 {
-		return nil, fmt.Errorf("encounters an error during handling response. %v", err)
+		return "", fmt.Errorf("encounters an error during handling response. %v", err)
 	}
 	defer res.Body.Close()
 
 	_, desc, err := keyChainManager.UpdateToken(token)
 
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	return &map[string]interface{}{
-		"accessTokenDesc": desc,
-	}, nil
+	return desc, nil
 }
 
 func deleteAccessToken(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
@@ -107,23 +126,38 @@ func deleteAccessToken(w http.ResponseWriter, r *http.Request) (*map[string]inte
 
 func generateKey(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
 
-	token, err := keyChainManager.GetToken()
+	startKey, err := requestStartKey()
 
 	if err != nil {
 		return nil, err
 	}
 
+	responseBody := map[string]interface{}{
+		"startKey": startKey,
+	}
+	return &responseBody, nil
+}
+
+// Asks the signaling server to generate a start key with the saved access token.
+func requestStartKey() (string, error) {
+
+	token, err := keyChainManager.GetToken()
+
+	if err != nil {
+		return "", err
+	}
+
 	req, err := createAuthorizationRequest("generateKey", token)
 
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
 	client := &http.Client{}
 	res, err := client.Do(req)
 
 	if err != nil || res.StatusCode != 200 {
-		return nil, fmt.Errorf("encounters an error during handling response. %v", err)
+		return "", fmt.Errorf("encounters an error during handling response. %v", err)
 	}
 
 	defer res.Body.Close()
@@ -132,41 +166,33 @@ func generateKey(w http.ResponseWriter, r *http.Request) (*map[string]interface{
 	body, err := io.ReadAll(res.Body)
 
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
 	json.Unmarshal(body, &result)
 
-	responseBody := map[string]interface{}{
-		"startKey": result["startKey"],
-	}
-	return &responseBody, nil
+	return result["startKey"], nil
 }
 
 func stopApp(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
-	applicationStates.StartStopMux.Lock()
-	defer applicationStates.StartStopMux.Unlock()
-
-	applicationStates.SetState(APPLICATION_STATE_INIT)
-	applicationStates.SetStartKey("")
-	if !routineCoordinator.IsStopped {
-		routineCoordinator.StopApp()
-	}
+	stopAppAndDrones()
 
 	responseBody := map[string]interface{}{}
 	return &responseBody, nil
 }
 
-func startApp(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
+// Disconnects from the signaling server and stops all the drones.
+func stopAppAndDrones() {
 	applicationStates.StartStopMux.Lock()
 	defer applicationStates.StartStopMux.Unlock()
 
-	if applicationStates.IsStarted() {
-		applog.Info("Application has already been started.")
-		responseBody := map[string]interface{}{}
-		return &responseBody, nil
-	}
-	applicationStates.Start()
+	applicationStates.SetState(APPLICATION_STATE_INIT)
+	applicationStates.SetStartKey("")
+	routineCoordinator.StopApp()
+	droneFleet.Stop()
+}
+
+func startApp(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
 
 	decoder := json.NewDecoder(r.Body)
 	bodyJson := make(map[string]string)
@@ -176,8 +202,7 @@ func startApp(w http.ResponseWriter, r *http.Request) (*map[string]interface{},
 		return nil, err
 	}
 
-	startKey := bodyJson["startKey"]
-	err = startAppFrom(startKey)
+	err = startAppOnce(bodyJson["startKey"])
 
 	if err != nil {
 		return nil, err
@@ -187,12 +212,25 @@ func startApp(w http.ResponseWriter, r *http.Request) (*map[string]interface{},
 	return &responseBody, nil
 }
 
-func startAppFrom(startKey string) error {
-	applog.Info("waiting for the waitgroup to be done...")
-	routineCoordinator.WaitUntilReleasingSocket()
-	applog.Info("End waiting for the waitgroup to be done.")
+// Starts the application with the start key unless it has already been started.
+func startAppOnce(startKey string) error {
+	applicationStates.StartStopMux.Lock()
+	defer applicationStates.StartStopMux.Unlock()
+
+	if applicationStates.IsStarted() {
+		applog.Info("Application has already been started.")
+		return nil
+	}
+	applicationStates.Start()
+
+	return startAppFrom(toEndpointUrlWithTrailingSlash(), startKey)
+}
 
-	routineCoordinator.InitRoutineCoordinator(false)
+// 'baseUrl' is the signaling endpoint with the trailing slash.
+func startAppFrom(baseUrl string, startKey string) error {
+	applog.Info("waiting for the routines of the previous run to return...")
+	routineCoordinator.Start()
+	applog.Info("End waiting for the routines of the previous run to return.")
 
 	startKeyJson := map[string]string{
 		"startKey": startKey,
@@ -202,11 +240,9 @@ func startAppFrom(startKey string) error {
 		return err
 	}
 
-	rtcHandler := NewRTCHandler()
-	drone := NewDrone()
-	drone.Start(&routineCoordinator, applicationStates)
+	droneFleet.Start()
 
-	err = negotiateSignalingConnection(startKeyJsonBytes, rtcHandler, drone)
+	err = negotiateSignalingConnection(baseUrl, startKeyJsonBytes)
 	if err != nil {
 		return err
 	}
@@ -216,7 +252,8 @@ func startAppFrom(startKey string) error {
 	return nil
 }
 
-func restartApp() {
+// Restarts the application connecting to the signaling endpoint 'baseUrl' again.
+func restartApp(baseUrl string) {
 	applicationStates.StartStopMux.Lock()
 	defer applicationStates.StartStopMux.Unlock()
 
@@ -225,21 +262,23 @@ func restartApp() {
 		return
 	}
 
+	landAirborneDrones("the signaling connection is lost")
 	routineCoordinator.StopApp()
+	droneFleet.Stop()
 
-	err := startAppFrom(exsitingStartKey)
+	err := startAppFrom(baseUrl, exsitingStartKey)
 
 	if err != nil {
 		applog.Warn("Failed to restart signaling connection. %v", err.Error())
 	}
 }
 
-func negotiateSignalingConnection(startKeyJsonBytes []byte, rtcHandler *RTCHandler, drone *Drone) error {
+// 'baseUrl' is the signaling endpoint with the trailing slash.
+func negotiateSignalingConnection(baseUrl string, startKeyJsonBytes []byte) error {
 
 	copyStartKeyJsonBytes := make([]byte, len(startKeyJsonBytes))
 	copy(copyStartKeyJsonBytes, startKeyJsonBytes)
 
-	baseUrl := toEndpointUrlWithTrailingSlash()
 	ticketUrl := baseUrl + "ticket"
 
 	res, err := http.Post(ticketUrl, "application/json", bytes.NewBuffer(startKeyJsonBytes))
@@ -261,55 +300,65 @@ func negotiateSignalingConnection(startKeyJsonBytes []byte, rtcHandler *RTCHandl
 	}
 	var retryCount int
 
-	go startSignalingConnection(conn, rtcHandler, drone, func() {
-		restartSignalingConnection(copyStartKeyJsonBytes, retryCount, rtcHandler, drone)
+	started := routineCoordinator.Go("signaling read loop", func(ctx context.Context) error {
+		startSignalingConnection(ctx, conn, func() {
+			restartSignalingConnection(baseUrl, copyStartKeyJsonBytes, retryCount)
+		})
+		return nil
 	})
+	if !started {
+		conn.Close()
+		return fmt.Errorf("the application has been stopped")
+	}
 
 	return nil
 }
 
-func restartSignalingConnection(startKeyJsonBytes []byte, retryCount int, rtcHandler *RTCHandler, drone *Drone) {
+func restartSignalingConnection(baseUrl string, startKeyJsonBytes []byte, retryCount int) {
 	b := make([]byte, len(startKeyJsonBytes))
 	copy(b, startKeyJsonBytes)
-	err := negotiateSignalingConnection(b, rtcHandler, drone)
+	err := negotiateSignalingConnection(baseUrl, b)
 	if err != nil {
 		maxRetry := env.GetInt("SIGNALING_ENDPOINT_MAX_RETRY")
 		if maxRetry < retryCount {
 			applog.Info("Fails to connect to the signaling channel. Retry count exceeds max.")
-			restartApp()
+			restartApp(baseUrl)
 			return
 		}
 
 		interval := env.GetDuration("SIGNALING_ENDPOINT_RETRY_INTERVAL")
 		time.Sleep(interval)
 		retryCount = retryCount + 1
-		restartSignalingConnection(startKeyJsonBytes, retryCount, rtcHandler, drone)
+		restartSignalingConnection(baseUrl, startKeyJsonBytes, retryCount)
 	}
 }
 
-func startSignalingConnection(connection *websocket.Conn, rtcHandler *RTCHandler, drone *Drone, recoverFunc func()) {
+// Reads the signaling messages until the run is cancelled.
+// 'recoverFunc' reconnects in another goroutine because restarting the application waits for this routine to return.
+func startSignalingConnection(ctx context.Context, connection *websocket.Conn, recoverFunc func()) {
 	connectionStoppedChannel := make(chan struct{})
+	defer close(connectionStoppedChannel)
 
-	routineCoordinator.AddWaitGroupUntilReleasingSocket()
-	go func() {
-		defer routineCoordinator.DoneWaitGroupUntilReleasingSocket()
+	routineCoordinator.Go("signaling connection closer", func(ctx context.Context) error {
 
 		select {
 		case <-connectionStoppedChannel:
 			connection.Close()
-		case <-routineCoordinator.StopSignalChannel:
+		case <-ctx.Done():
 			connection.Close()
 		}
+		return nil
+	})
 
-	}()
-
-	applicationStates.SetDroneStateFromConnectionState(rtcHandler.IsPeerConnected())
+	for _, unit := range droneFleet.Units() {
+		unit.applicationStates.SetPeerConnected(unit.RTCHandler().IsPeerConnected())
+	}
 
 	var consecutiveErrorOnReadCount int
 	for {
 
 		select {
-		case <-routineCoordinator.StopSignalChannel:
+		case <-ctx.Done():
 			applog.Info("Stop Signaling channel.")
 			return
 		default:
@@ -319,60 +368,51 @@ func startSignalingConnection(connection *websocket.Conn, rtcHandler *RTCHandler
 				consecutiveErrorOnReadCount++
 				applog.Info("%v", err)
 				if 10 < consecutiveErrorOnReadCount {
-					recoverFunc()
-					close(connectionStoppedChannel)
+					go recoverFunc()
 					return
 				}
 				continue
 			}
 			consecutiveErrorOnReadCount = 0
 
-			rtcMessageData, err := NewRTCMessageData(&message)
+			signalingMessage, err := DecodeSignalingMessage(message)
 			if err != nil {
-				applog.Info("%v", err)
+				applog.Warn("Rejects a signaling message. %v", err)
 				continue
 			}
-			messageType := rtcMessageData.MessageType
 
-			switch messageType {
-			case "ping":
-				connection.WriteJSON(map[string]string{
-					"messageType": "pong",
-				})
-			case "iceServerInfo":
+			switch m := signalingMessage.(type) {
+			case *PingMessage:
+				connection.WriteJSON(NewPongMessage())
+			case *ICEServerInfoMessage:
 
-				config, err := rtcMessageData.ToConfiguration()
+				err = droneFleet.SetICEConfig(m.ToConfiguration())
 				if err != nil {
 					applog.Info("%v", err)
 					continue
 				}
 
-				err = rtcHandler.SetConfig(config)
+			case *CanOfferMessage:
+				applog.Info("canOffer")
+
+				peerType := m.ToPeerType()
+				unit, err := droneFleet.Get(peerType.DroneId)
 				if err != nil {
-					applog.Info("%v", err)
+					applog.Warn("Rejects a peer(%v). %v", peerType.PeerConnectionId, err)
+					connection.WriteJSON(NewCanOfferReplyMessage(peerType.DroneId, peerType.PeerConnectionId, PEER_STATE_EXIST))
 					continue
 				}
-
-			case "canOffer":
-				applog.Info("canOffer")
-
-				peerType := rtcMessageData.ToPeerType()
+				rtcHandler := unit.RTCHandler()
 				state := rtcHandler.DecidePeerState(peerType)
 
 				write := func() {
-					connection.WriteJSON(map[string]interface{}{
-						"messageType":      "canOffer",
-						"peerConnectionId": peerType.PeerConnectionId,
-						"state":            state,
-					})
+					connection.WriteJSON(NewCanOfferReplyMessage(peerType.DroneId, peerType.PeerConnectionId, state))
 				}
 
 				if state == PEER_STATE_SAME {
 					if peerType.IsPrimary {
-						applog.Info("Primary peer is requesting new connection. Restart the application.")
-						restartApp()
-						write()
-						return
+						applog.Info("Primary peer of drone '%v' is requesting new connection. Restart the peer connection.", unit.Id)
+						droneFleet.RestartRTCHandler(unit)
 					} else {
 						applog.Info("Audience peer(%v) is requesting new connectiond.", peerType.PeerConnectionId)
 						rtcHandler.SendAudienceRTCStopChannel(peerType.PeerConnectionId)
@@ -380,14 +420,21 @@ func startSignalingConnection(connection *websocket.Conn, rtcHandler *RTCHandler
 				}
 				write()
 
-			case "close":
+			case *CloseMessage:
 
 				applog.Info("One of the peers has been closed.")
-				peerType := rtcMessageData.ToPeerType()
+				peerType := m.ToPeerType()
+				unit, err := droneFleet.Get(peerType.DroneId)
+				if err != nil {
+					applog.Warn("Ignores a closed peer(%v). %v", peerType.PeerConnectionId, err)
+					continue
+				}
+				rtcHandler := unit.RTCHandler()
 				if rtcHandler.IsPrimary(peerType.PeerConnectionId) {
-					applog.Info("Primary peer has been closed. Restart the application.")
-					restartApp()
-					return
+					applog.Info("Primary peer of drone '%v' has been closed. Restart the peer connection.", unit.Id)
+					// The drone hovers awaiting reconnection and lands if the primary peer does not come back.
+					unit.linkFailsafe.OnDisconnected("primary peer closed")
+					droneFleet.RestartRTCHandler(unit)
 
 				} else {
 					if !peerType.IsPrimary {
@@ -396,33 +443,31 @@ func startSignalingConnection(connection *websocket.Conn, rtcHandler *RTCHandler
 					}
 				}
 
-			case "offer":
+			case *OfferMessage:
 				applog.Info("offer")
 
-				peerConnectionId := rtcMessageData.ToPeerConnectionId()
+				peerConnectionId := m.PeerConnectionId
+				unit, err := droneFleet.Get(m.DroneId)
+				if err != nil {
+					applog.Warn("Rejects an offer from %v. %v", peerConnectionId, err)
+					connection.WriteJSON(NewErrorAnswerMessage(m.DroneId, peerConnectionId))
+					continue
+				}
+				rtcHandler := unit.RTCHandler()
 
 				writeErrAnswer := func() {
 					rtcHandler.DeleteAudience(peerConnectionId)
-					connection.WriteJSON(map[string]interface{}{
-						"messageType":      "answer",
-						"peerConnectionId": peerConnectionId,
-						"err":              true,
-					})
-				}
-				sdp, err := rtcMessageData.ToSessionDescription()
-				if err != nil {
-					applog.Info("%v", err)
-					writeErrAnswer()
-					continue
+					connection.WriteJSON(NewErrorAnswerMessage(m.DroneId, peerConnectionId))
 				}
 
 				var localDescription *webrtc.SessionDescription
 
 				if rtcHandler.IsPrimary(peerConnectionId) {
+					drone := unit.Drone()
 					drone.StartVideoStreaming()
-					localDescription, err = rtcHandler.StartPrimaryConnection(sdp, &routineCoordinator, applicationStates)
+					localDescription, err = rtcHandler.StartPrimaryConnection(m.Offer, unit.routineCoordinator, unit.applicationStates, drone.Recorder())
 				} else {
-					localDescription, err = rtcHandler.StartAudienceConnection(peerConnectionId, sdp, &routineCoordinator)
+					localDescription, err = rtcHandler.StartAudienceConnection(peerConnectionId, m.Offer, unit.routineCoordinator)
 				}
 
 				if err != nil {
@@ -431,15 +476,7 @@ func startSignalingConnection(connection *websocket.Conn, rtcHandler *RTCHandler
 					continue
 				}
 
-				connection.WriteJSON(map[string]interface{}{
-					"messageType":      "answer",
-					"peerConnectionId": peerConnectionId,
-					"err":              false,
-					"answer": map[string]string{
-						"sdp":  localDescription.SDP,
-						"type": localDescription.Type.String(),
-					},
-				})
+				connection.WriteJSON(NewAnswerMessage(m.DroneId, peerConnectionId, localDescription))
 			}
 
 		}
@@ -449,15 +486,21 @@ func startSignalingConnection(connection *websocket.Conn, rtcHandler *RTCHandler
 func state(w http.ResponseWriter, r *http.Request) {
 
 	server := NewApplicationStatesServer()
-	server.Start(w, r, applicationStates)
+	server.Start(w, r, applicationStates, droneFleet)
 }
 
+// The handlers below operate the drone specified by the 'droneId' query parameter or the default drone.
 func takeoff(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
 
-	routineCoordinator.SendDataChannelMessageChannel("takeoff")
-	routineCoordinator.SendDroneCommandChannel(DroneCommand{
+	unit, err := droneFleet.FromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	unit.routineCoordinator.SendDroneCommandChannel(DroneCommand{
 		CommandType: "takeoff",
 	})
+	unit.routineCoordinator.SendDataChannelMessage("takeoff")
 
 	responseBody := map[string]interface{}{}
 	return &responseBody, nil
@@ -465,23 +508,281 @@ func takeoff(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, e
 
 func land(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
 
-	routineCoordinator.SendDataChannelMessageChannel("land")
-	routineCoordinator.SendDroneCommandChannel(DroneCommand{
-		CommandType: "land",
+	unit, err := droneFleet.FromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	RequestLanding(unit.routineCoordinator)
+
+	responseBody := map[string]interface{}{}
+	return &responseBody, nil
+}
+
+// Handles '/cgi/<action type>'. The body is a DroneAction whose type can be omitted.
+func newDroneActionHandler(actionType string) func(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
+	return func(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
+
+		unit, err := droneFleet.FromRequest(r)
+		if err != nil {
+			return nil, err
+		}
+
+		var action DroneAction
+		if err := json.NewDecoder(r.Body).Decode(&action); err != nil && err != io.EOF {
+			return nil, err
+		}
+		action.Type = actionType
+
+		command, err := action.ToDroneCommand()
+		if err != nil {
+			return nil, err
+		}
+		unit.routineCoordinator.SendDroneCommandChannel(command)
+
+		responseBody := map[string]interface{}{}
+		return &responseBody, nil
+	}
+}
+
+func takePicture(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
+
+	unit, err := droneFleet.FromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	unit.routineCoordinator.SendDroneCommandChannel(DroneCommand{
+		CommandType: "takePicture",
 	})
 
 	responseBody := map[string]interface{}{}
 	return &responseBody, nil
 }
 
-func terminate(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
-	stopApp(w, r)
+func listPhotos(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
 
-	go func() {
-		applog.Warn("Application terminates...")
-		time.Sleep(5 * time.Second)
-		os.Exit(2)
-	}()
+	photoStore := NewPhotoStore()
+	photos, err := photoStore.List()
+	if err != nil {
+		return nil, err
+	}
+
+	responseBody := map[string]interface{}{
+		"photos": photos,
+	}
+	return &responseBody, nil
+}
+
+func downloadPhoto(w http.ResponseWriter, r *http.Request) {
+
+	photoStore := NewPhotoStore()
+	data, err := photoStore.Read(mux.Vars(r)["id"])
+	if err != nil {
+		applog.Warn("Fails to read a photo. %v", err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Write(data)
+}
+
+// Validates the uploaded mission against the safety envelope and starts it.
+func startMission(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
+
+	unit, err := droneFleet.FromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var mission Mission
+	if err := json.NewDecoder(r.Body).Decode(&mission); err != nil {
+		return nil, err
+	}
+	if err := mission.Validate(NewSafetyEnvelopeConfig()); err != nil {
+		return nil, err
+	}
+	if err := unit.missionRunner.Start(mission); err != nil {
+		return nil, err
+	}
+
+	responseBody := map[string]interface{}{}
+	return &responseBody, nil
+}
+
+func getMission(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
+
+	unit, err := droneFleet.FromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	responseBody := map[string]interface{}{
+		"mission": unit.applicationStates.GetMissionStatus(),
+	}
+	return &responseBody, nil
+}
+
+func newMissionControlHandler(control string) func(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
+	return func(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
+
+		unit, err := droneFleet.FromRequest(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := unit.missionRunner.Control(control); err != nil {
+			return nil, err
+		}
+
+		responseBody := map[string]interface{}{}
+		return &responseBody, nil
+	}
+}
+
+func startSwarm(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
+
+	var choreography Choreography
+	if err := json.NewDecoder(r.Body).Decode(&choreography); err != nil {
+		return nil, err
+	}
+	if err := choreography.Validate(droneFleet, NewSafetyEnvelopeConfig()); err != nil {
+		return nil, err
+	}
+	if err := swarmRunner.Start(choreography); err != nil {
+		return nil, err
+	}
+
+	responseBody := map[string]interface{}{}
+	return &responseBody, nil
+}
+
+func getSwarm(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
+
+	responseBody := map[string]interface{}{
+		"swarm": applicationStates.GetSwarmStatus(),
+	}
+	return &responseBody, nil
+}
+
+// Aborts the running choreography. All the drones in it hover and land.
+func abortSwarm(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
+
+	if err := swarmRunner.Abort(); err != nil {
+		return nil, err
+	}
+
+	responseBody := map[string]interface{}{}
+	return &responseBody, nil
+}
+
+// The first request arms the emergency stop and the second one within the confirmation window stops the motors.
+func emergency(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
+
+	unit, err := droneFleet.FromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	confirmed := unit.emergencyStopConfirmation.Request(EMERGENCY_STOP_SOURCE_HTTP)
+	if confirmed {
+		RequestEmergencyStop(unit.routineCoordinator, EMERGENCY_STOP_SOURCE_HTTP)
+	}
+
+	responseBody := map[string]interface{}{
+		"confirmed":                confirmed,
+		"confirmationWindowMillis": emergencyStopConfirmationWindow.Milliseconds(),
+	}
+	return &responseBody, nil
+}
+
+// Arms and confirms the emergency stop from the console, for example, when the browser does not respond.
+// It stops the motors of all the drones.
+func watchConsoleEmergencyStop() {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) != "e" {
+			continue
+		}
+		if !emergencyStopConfirmation.Request(EMERGENCY_STOP_SOURCE_CONSOLE) {
+			fmt.Printf("Emergency stop is armed. Type 'e' and press Enter again within %v to stop the motors.", emergencyStopConfirmationWindow)
+			fmt.Println()
+			continue
+		}
+		for _, unit := range droneFleet.Units() {
+			r := unit.routineCoordinator
+			r.Go("console emergency stop request", func(ctx context.Context) error {
+				RequestEmergencyStop(r, EMERGENCY_STOP_SOURCE_CONSOLE)
+				return nil
+			})
+		}
+	}
+}
+
+func startVideoRecording(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
+
+	unit, err := droneFleet.FromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	unit.routineCoordinator.SendDroneCommandChannel(DroneCommand{
+		CommandType: "startVideoRecording",
+	})
+
+	responseBody := map[string]interface{}{}
+	return &responseBody, nil
+}
+
+func stopVideoRecording(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
+
+	unit, err := droneFleet.FromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	unit.routineCoordinator.SendDroneCommandChannel(DroneCommand{
+		CommandType: "stopVideoRecording",
+	})
+
+	responseBody := map[string]interface{}{}
+	return &responseBody, nil
+}
+
+// Provides the latest key frame of the video stream as a single-frame H.264 file.
+func snapshot(w http.ResponseWriter, r *http.Request) {
+
+	unit, err := droneFleet.FromRequest(r)
+	if err != nil {
+		applog.Warn("Fails to provide a snapshot. %v", err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	data, timestamp, ok := unit.snapshot.Get(snapshotMaxAge)
+	if !ok {
+		applog.Warn("Fails to provide a snapshot. No key frame is received within %v.", snapshotMaxAge)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "video/h264")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"snapshot-%v-%v.h264\"", unit.Id, timestamp))
+	w.Write(data)
+}
+
+func listDrones(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
+
+	responseBody := map[string]interface{}{
+		"defaultDroneId": droneFleet.Default().Id,
+		"drones":         droneFleet.Statuses(),
+	}
+	return &responseBody, nil
+}
+
+func terminate(w http.ResponseWriter, r *http.Request) (*map[string]interface{}, error) {
+	go shutDown("Requested by /cgi/terminate.")
 
 	responseBody := map[string]interface{}{}
 	return &responseBody, nil
@@ -504,6 +805,11 @@ func startUsingApplication(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// Responds without a body so that the watchdog can check that the server is serving.
+func health(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func checkSessionKeyMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -534,20 +840,24 @@ func newRootSecureMiddleware() func(next http.Handler) http.Handler {
 	}).Handler
 }
 
-func routes() {
-
-	port := env.Get("PORT")
-	applog.Info("PORT:" + port)
-
-	routineCoordinator.InitRoutineCoordinator(true)
-	routineCoordinator.IsStopped = true
-
+// Prepares what the handlers use. It has to be called before routes.
+func setUp() {
 	km, err := appos.NewKeyChainManager()
 	if err != nil {
 		panic(err)
 	}
 	keyChainManager = km
 
+	routineCoordinator = NewRoutineCoordinator()
+	droneFleet = NewDroneFleetFromEnv()
+	swarmRunner = NewSwarmRunner(droneFleet, applicationStates)
+}
+
+func routes() {
+
+	port := env.Get("PORT")
+	applog.Info("PORT:" + port)
+
 	rootRouter := mux.NewRouter()
 	rootRouter.Use(newRootSecureMiddleware())
 	cgiRouter := rootRouter.PathPrefix("/cgi").Subrouter()
@@ -561,36 +871,78 @@ func routes() {
 	HandleFuncJSON(cgiRouter, "/generateKey", generateKey).Methods(http.MethodGet)
 	HandleFuncJSON(cgiRouter, "/stopApp", stopApp).Methods(http.MethodPost)
 	HandleFuncJSON(cgiRouter, "/startApp", startApp).Methods(http.MethodPost)
+	HandleFuncJSON(cgiRouter, "/drones", listDrones).Methods(http.MethodGet)
 	HandleFuncJSON(cgiRouter, "/takeoff", takeoff).Methods(http.MethodPost)
 	HandleFuncJSON(cgiRouter, "/land", land).Methods(http.MethodPost)
+	HandleFuncJSON(cgiRouter, "/emergency", emergency).Methods(http.MethodPost)
+	HandleFuncJSON(cgiRouter, "/takePicture", takePicture).Methods(http.MethodPost)
+	HandleFuncJSON(cgiRouter, "/photos", listPhotos).Methods(http.MethodGet)
+	cgiRouter.HandleFunc("/photos/{id}", downloadPhoto).Methods(http.MethodGet)
+	HandleFuncJSON(cgiRouter, "/missions", startMission).Methods(http.MethodPost)
+	HandleFuncJSON(cgiRouter, "/missions", getMission).Methods(http.MethodGet)
+	for _, control := range []string{MISSION_CONTROL_PAUSE, MISSION_CONTROL_RESUME, MISSION_CONTROL_ABORT} {
+		HandleFuncJSON(cgiRouter, "/missions/"+control, newMissionControlHandler(control)).Methods(http.MethodPost)
+	}
+	HandleFuncJSON(cgiRouter, "/swarm", startSwarm).Methods(http.MethodPost)
+	HandleFuncJSON(cgiRouter, "/swarm", getSwarm).Methods(http.MethodGet)
+	HandleFuncJSON(cgiRouter, "/swarm/abort", abortSwarm).Methods(http.MethodPost)
+	for _, actionType := range DRONE_ACTION_TYPES {
+		HandleFuncJSON(cgiRouter, "/"+actionType, newDroneActionHandler(actionType)).Methods(http.MethodPost)
+	}
+	HandleFuncJSON(cgiRouter, "/startVideoRecording", startVideoRecording).Methods(http.MethodPost)
+	HandleFuncJSON(cgiRouter, "/stopVideoRecording", stopVideoRecording).Methods(http.MethodPost)
+	cgiRouter.HandleFunc("/snapshot", snapshot).Methods(http.MethodGet)
 	HandleFuncJSON(cgiRouter, "/terminate", terminate).Methods(http.MethodPost)
 	cgiRouter.HandleFunc("/state", state)
 
 	dmzRouter.HandleFunc("/startUsingApplication", startUsingApplication).Methods(http.MethodGet)
+	dmzRouter.HandleFunc("/health", health).Methods(http.MethodGet)
 
 	statics := NewStatics(applicationStates.GetSessionKey())
 	staticRouter.PathPrefix("/").HandlerFunc(statics.HandleStatic)
 
-	log.Fatal(http.ListenAndServe("localhost:"+port, rootRouter))
+	listener, err := net.Listen("tcp", "localhost:"+port)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(http.Serve(listener, rootRouter))
+}
+
+// Falls back to printing the URL, for example, when the application runs in an SSH session.
+// The page served at the URL embeds the access key, so opening it anywhere the port is forwarded to starts a session.
+func openBrowserOrShowURL(url string, delay time.Duration) {
+	err := appos.OpenBrowser(url, delay)
+	if err == nil {
+		return
+	}
+
+	applog.Warn("Fails to open a browser. %v", err)
+	fmt.Println("Could not open a browser. Open the following URL to use the application:")
+	fmt.Println()
+	fmt.Println("  " + url)
+	fmt.Println()
+	fmt.Printf("If the application runs on a remote host, forward the port first. e.g. 'ssh -L %v:localhost:%v <host>'", env.Get("PORT"), env.Get("PORT"))
+	fmt.Println()
 }
 
 func main() {
+	if 1 < len(os.Args) {
+		os.Exit(runHeadless(os.Args[1:]))
+	}
+
+	StartSimulatorIfNeeded()
+
+	setUp()
 	go routes()
 	go func() {
 		if env.GetBool("OPEN_BROWSER_ON_START_UP") {
-			appos.OpenBrowser("http://localhost:"+env.Get("PORT"), 3*time.Second)
+			openBrowserOrShowURL("http://localhost:"+env.Get("PORT"), 3*time.Second)
 		}
 	}()
 
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt)
-
-	fmt.Println("If you want to stop the application, press 'ctrl+c' and start stopping.")
-
-	<-signalChan
+	fmt.Println("If you want to stop the application, press 'ctrl+c'. The drones in the air land before it stops.")
+	fmt.Println("If you want to stop the motors of the drone in an emergency, type 'e' and press Enter twice.")
+	go watchConsoleEmergencyStop()
 
-	fmt.Println("Are you sure you want to stop the application? If so, press 'ctrl+c' twice.")
-	<-signalChan
-	<-signalChan
-	os.Exit(2)
+	waitForSignalsAndShutDown()
 }
@@ -18,13 +18,6 @@ const (
 	DRONE_HEALTH_NG      = 2
 )
 
-const (
-	DRONE_STATE_INIT    = 0
-	DRONE_STATE_READY   = 1
-	DRONE_STATE_LAND    = 2
-	DRONE_STATE_TAKEOFF = 3
-)
-
 const (
 	SESSION_KEY_HTTP_HEADER_KEY = "x-ojm-drone-local-session-key"
 )
@@ -33,7 +26,14 @@ type ApplicationStates struct {
 	applicationState atomic.Value
 	currentStartKey  atomic.Value
 	droneHealths     atomic.Value
-	droneState       atomic.Value
+	telemetry        atomic.Value
+	videoRecording   atomic.Value
+	batteryWarning   atomic.Value
+	linkState        atomic.Value
+	missionStatus    atomic.Value
+	swarmStatus      atomic.Value
+	flightState      atomic.Value
+	peerConnected    atomic.Value
 	sessionKey       atomic.Value
 	StartStopMux     sync.Mutex
 	AccessKey        string
@@ -44,8 +44,6 @@ type DroneHealths struct {
 	BatteryLevel int
 }
 
-type DroneState int
-
 func NewApplicationStates() *ApplicationStates {
 
 	a := &ApplicationStates{}
@@ -54,7 +52,19 @@ func NewApplicationStates() *ApplicationStates {
 	a.SetDroneHealths(DroneHealths{
 		DroneHealth: DRONE_HEALTH_UNKNOWN,
 	})
-	a.SetDroneState(DRONE_STATE_INIT)
+	a.SetTelemetry(Telemetry{})
+	a.SetVideoRecording(false)
+	a.SetBatteryWarning(BATTERY_WARNING_NONE)
+	a.SetLinkState(LINK_STATE_UNKNOWN)
+	a.SetMissionStatus(MissionStatus{
+		State: MISSION_STATE_IDLE,
+	})
+	a.SetSwarmStatus(SwarmStatus{
+		State:  MISSION_STATE_IDLE,
+		Drones: []SwarmDroneStatus{},
+	})
+	a.SetFlightState(FLIGHT_STATE_DISCONNECTED)
+	a.SetPeerConnected(false)
 	a.ChangeSessionKey()
 
 	key, err := uuid.NewRandom()
@@ -90,20 +100,68 @@ func (a *ApplicationStates) SetDroneHealths(healths DroneHealths) {
 	a.droneHealths.Store(healths)
 }
 
-func (a *ApplicationStates) GetDroneState() DroneState {
-	return a.droneState.Load().(DroneState)
+func (a *ApplicationStates) GetTelemetry() Telemetry {
+	return a.telemetry.Load().(Telemetry)
 }
 
-func (a *ApplicationStates) SetDroneState(state DroneState) {
-	a.droneState.Store(state)
+func (a *ApplicationStates) SetTelemetry(telemetry Telemetry) {
+	a.telemetry.Store(telemetry)
 }
 
-func (a *ApplicationStates) SetDroneStateFromConnectionState(isConnected bool) {
-	if isConnected {
-		a.SetDroneState(DRONE_STATE_LAND)
-	} else {
-		a.SetDroneState(DRONE_STATE_READY)
-	}
+func (a *ApplicationStates) IsVideoRecording() bool {
+	return a.videoRecording.Load().(bool)
+}
+
+func (a *ApplicationStates) SetVideoRecording(isRecording bool) {
+	a.videoRecording.Store(isRecording)
+}
+
+func (a *ApplicationStates) GetBatteryWarning() int {
+	return a.batteryWarning.Load().(int)
+}
+
+func (a *ApplicationStates) SetBatteryWarning(level int) {
+	a.batteryWarning.Store(level)
+}
+
+func (a *ApplicationStates) GetLinkState() int {
+	return a.linkState.Load().(int)
+}
+
+func (a *ApplicationStates) SetLinkState(state int) {
+	a.linkState.Store(state)
+}
+
+func (a *ApplicationStates) GetMissionStatus() MissionStatus {
+	return a.missionStatus.Load().(MissionStatus)
+}
+
+func (a *ApplicationStates) SetMissionStatus(status MissionStatus) {
+	a.missionStatus.Store(status)
+}
+
+func (a *ApplicationStates) GetSwarmStatus() SwarmStatus {
+	return a.swarmStatus.Load().(SwarmStatus)
+}
+
+func (a *ApplicationStates) SetSwarmStatus(status SwarmStatus) {
+	a.swarmStatus.Store(status)
+}
+
+func (a *ApplicationStates) GetFlightState() FlightState {
+	return a.flightState.Load().(FlightState)
+}
+
+func (a *ApplicationStates) SetFlightState(state FlightState) {
+	a.flightState.Store(state)
+}
+
+func (a *ApplicationStates) IsPeerConnected() bool {
+	return a.peerConnected.Load().(bool)
+}
+
+func (a *ApplicationStates) SetPeerConnected(isConnected bool) {
+	a.peerConnected.Store(isConnected)
 }
 
 func (a *ApplicationStates) GetSessionKey() string {
@@ -31,6 +31,7 @@ type Logger struct {
 
 var once sync.Once
 var logger *Logger
+var logFile *os.File
 var loadChan = make(chan struct{})
 
 func Debug(format string, v ...interface{}) {
@@ -54,6 +55,12 @@ func Warn(format string, v ...interface{}) {
 	}
 }
 
+// Writes the logs to the storage, for example, before the application exits.
+func Flush() {
+	newLogger()
+	logFile.Sync()
+}
+
 func newLogger() {
 	once.Do(func() {
 		levelInt := 1
@@ -92,6 +99,7 @@ func createWriter() io.Writer {
 	if err != nil {
 		log.Fatal(err)
 	}
+	logFile = fileToDump
 	if !env.GetBool("LOG_OUTPUT_CONSOLE") {
 		return fileToDump
 	}
@@ -114,7 +122,7 @@ func deleteFiles() {
 		log.Fatal(err)
 	}
 	fileBaseName := env.Get("LOG_FILE_BASE_NAME")
-	daysToReserve := env.GetInt("LOG_DAYS_TO_RESERVER")
+	daysToReserve := DaysToReserve()
 	deleteAfter := time.Now().AddDate(0, 0, -daysToReserve)
 
 	for _, f := range files {
@@ -170,6 +178,16 @@ func createFilepath() string {
 	return filepath.Join(createOutputDirPath(), filename)
 }
 
+// The directory log files are written to. Other files sharing the log retention (e.g. flight records) are placed in it too.
+func OutputDir() string {
+	return createOutputDirPath()
+}
+
+// The number of days log files are retained.
+func DaysToReserve() int {
+	return env.GetInt("LOG_DAYS_TO_RESERVER")
+}
+
 func createOutputDirPath() string {
 	return filepath.Join(getDir(), env.Get("LOG_OUTPUT_DIR"))
 }
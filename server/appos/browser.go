@@ -2,11 +2,15 @@ package appos
 
 import (
 	"errors"
+	"os"
 	"os/exec"
 	"runtime"
+	"strings"
 	"time"
 )
 
+var ErrNoBrowserAvailable = errors.New("no browser is available")
+
 func OpenBrowser(url string, delay time.Duration) error {
 	time.Sleep(delay)
 
@@ -16,8 +20,48 @@ func OpenBrowser(url string, delay time.Duration) error {
 	case "darwin":
 		return exec.Command("open", url).Start()
 	case "linux":
-		fallthrough
+		return openBrowserOnLinux(url)
 	default:
 		return errors.New("your OS is not supported")
 	}
 }
+
+// Tries $BROWSER first, then the commands desktop environments provide.
+// Returns ErrNoBrowserAvailable if none can be used (e.g. in an SSH session without X forwarding).
+func openBrowserOnLinux(url string) error {
+	for _, command := range strings.Split(os.Getenv("BROWSER"), ":") {
+		if command == "" {
+			continue
+		}
+		// $BROWSER may contain '%s' as a placeholder of the URL.
+		args := strings.Fields(command)
+		if strings.Contains(command, "%s") {
+			for i, arg := range args {
+				args[i] = strings.ReplaceAll(arg, "%s", url)
+			}
+		} else {
+			args = append(args, url)
+		}
+		if _, err := exec.LookPath(args[0]); err != nil {
+			continue
+		}
+		if err := exec.Command(args[0], args[1:]...).Start(); err == nil {
+			return nil
+		}
+	}
+
+	if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+		return ErrNoBrowserAvailable
+	}
+
+	for _, command := range []string{"xdg-open", "sensible-browser", "x-www-browser", "gnome-open"} {
+		if _, err := exec.LookPath(command); err != nil {
+			continue
+		}
+		if err := exec.Command(command, url).Start(); err == nil {
+			return nil
+		}
+	}
+
+	return ErrNoBrowserAvailable
+}
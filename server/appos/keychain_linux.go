@@ -0,0 +1,86 @@
+package appos
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+)
+
+type KeyChainManager interface {
+	SetToken(token string) error
+	UpdateToken(token string) (string, string, error)
+	GetToken() (string, error)
+	GetTokenAndDesc() (string, string, error)
+	DeleteToken() error
+}
+
+const (
+	keyChainService = "com.ajizablg.ojm-drone/access-token"
+	keyChainLabel   = "OJM-Drone Access Token"
+)
+
+// Environment variables to choose the backend on Linux.
+// These are read from the process environment (not from .env) so that the passphrase never has to be written to a file.
+const (
+	// 'auto'(default), 'secretservice' or 'file'
+	KeyChainBackendEnvKey = "OJM_DRONE_KEYCHAIN_BACKEND"
+	// Passphrase the 'file' backend derives the encryption key from. If empty, a machine specific key is used.
+	KeyChainPassphraseEnvKey = "OJM_DRONE_KEYCHAIN_PASSPHRASE"
+	// Path of the file the 'file' backend stores the token in.
+	KeyChainFileEnvKey = "OJM_DRONE_KEYCHAIN_FILE"
+)
+
+var errTokenNotFound = errors.New("access token is not found")
+
+// On Linux, the token is stored in the Secret Service (e.g. GNOME Keyring, KWallet) if available.
+// On headless hosts where no Secret Service runs, it is stored in an encrypted file.
+func NewKeyChainManager() (KeyChainManager, error) {
+	runtimeOs := runtime.GOOS
+
+	switch runtimeOs {
+	case "linux":
+		backend := strings.ToLower(os.Getenv(KeyChainBackendEnvKey))
+
+		switch backend {
+		case "secretservice":
+			return newSecretServiceKeyChainManager()
+		case "file":
+			return newEncryptedFileKeyChainManager()
+		case "", "auto":
+			km, err := newSecretServiceKeyChainManager()
+			if err == nil {
+				return km, nil
+			}
+			return newEncryptedFileKeyChainManager()
+		default:
+			return nil, errors.New("unknown keychain backend: " + backend)
+		}
+
+	case "darwin":
+		fallthrough
+	case "windows":
+		fallthrough
+	default:
+		return nil, errors.New("your OS is not supported")
+	}
+}
+
+func currentUser() string {
+	user := os.Getenv("USER")
+	if user == "" {
+		user = os.Getenv("LOGNAME")
+	}
+	return user
+}
+
+func makeTokenDesc(token string) string {
+	desc := ""
+	if len(token) > 0 {
+		desc = "**********..."
+		if len(token) > 10 {
+			desc = token[0:5] + desc
+		}
+	}
+	return desc
+}
@@ -0,0 +1,232 @@
+package appos
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	encryptedFileVersion = 1
+
+	keySourcePassphrase = "passphrase"
+	keySourceMachine    = "machine"
+
+	// scrypt parameters recommended for interactive logins.
+	scryptN      = 32768
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	saltLen      = 16
+)
+
+type encryptedToken struct {
+	Version    int    `json:"version"`
+	KeySource  string `json:"keySource"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Stores the token in a file encrypted with AES-256-GCM.
+//
+// The key is derived by scrypt from the passphrase given by OJM_DRONE_KEYCHAIN_PASSPHRASE.
+// Without it, the key is derived from the machine id and the user, which only prevents the file
+// from being read on other hosts. Set a passphrase if other users can read the file.
+type EncryptedFileKeyChainManager struct {
+	path       string
+	passphrase string
+}
+
+func newEncryptedFileKeyChainManager() (*EncryptedFileKeyChainManager, error) {
+	path := os.Getenv(KeyChainFileEnvKey)
+	if path == "" {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(configDir, "ojm-drone", "access-token.enc")
+	}
+
+	return &EncryptedFileKeyChainManager{
+		path:       path,
+		passphrase: os.Getenv(KeyChainPassphraseEnvKey),
+	}, nil
+}
+
+func (km *EncryptedFileKeyChainManager) SetToken(token string) error {
+
+	keySource, secret, err := km.keySecret()
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	gcm, err := newGCM(secret, salt)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(encryptedToken{
+		Version:    encryptedFileVersion,
+		KeySource:  keySource,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, []byte(token), []byte(keyChainService)),
+	})
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(km.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	// Writes to a temporary file first so that a crash never leaves a broken file.
+	tmp, err := ioutil.TempFile(dir, ".access-token-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), km.path)
+}
+
+func (km *EncryptedFileKeyChainManager) UpdateToken(token string) (string, string, error) {
+
+	desc := makeTokenDesc(token)
+	err := km.SetToken(token)
+
+	if err != nil {
+		return token, desc, err
+	}
+
+	return token, desc, err
+}
+
+func (km *EncryptedFileKeyChainManager) GetToken() (string, error) {
+
+	body, err := ioutil.ReadFile(km.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	var stored encryptedToken
+	if err := json.Unmarshal(body, &stored); err != nil {
+		return "", err
+	}
+	if stored.Version != encryptedFileVersion {
+		return "", errors.New("unsupported token file version")
+	}
+
+	keySource, secret, err := km.keySecret()
+	if err != nil {
+		return "", err
+	}
+	if keySource != stored.KeySource {
+		return "", errors.New("the token file is encrypted with the " + stored.KeySource + " key. Check " + KeyChainPassphraseEnvKey)
+	}
+
+	gcm, err := newGCM(secret, stored.Salt)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := gcm.Open(nil, stored.Nonce, stored.Ciphertext, []byte(keyChainService))
+	if err != nil {
+		return "", errors.New("fails to decrypt the token file. The passphrase may be wrong")
+	}
+
+	return string(token), nil
+}
+
+func (km *EncryptedFileKeyChainManager) GetTokenAndDesc() (string, string, error) {
+
+	token, err := km.GetToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, makeTokenDesc(token), nil
+}
+
+func (km *EncryptedFileKeyChainManager) DeleteToken() error {
+
+	err := os.Remove(km.path)
+	if os.IsNotExist(err) {
+		return errTokenNotFound
+	}
+
+	return err
+}
+
+func (km *EncryptedFileKeyChainManager) keySecret() (string, []byte, error) {
+	if km.passphrase != "" {
+		return keySourcePassphrase, []byte(km.passphrase), nil
+	}
+
+	machineId, err := readMachineId()
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256([]byte(keyChainService + "/" + machineId + "/" + currentUser()))
+	return keySourceMachine, sum[:], nil
+}
+
+func readMachineId() (string, error) {
+	for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		body, err := ioutil.ReadFile(path)
+		if err == nil && len(strings.TrimSpace(string(body))) > 0 {
+			return strings.TrimSpace(string(body)), nil
+		}
+	}
+	return "", errors.New("machine id is not found. Set " + KeyChainPassphraseEnvKey)
+}
+
+func newGCM(secret []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(secret, salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
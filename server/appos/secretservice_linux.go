@@ -0,0 +1,280 @@
+package appos
+
+import (
+	"errors"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// freedesktop.org Secret Service API
+// Reference: https://specifications.freedesktop.org/secret-service/latest/
+const (
+	secretServiceName              = "org.freedesktop.secrets"
+	secretServicePath              = dbus.ObjectPath("/org/freedesktop/secrets")
+	secretServiceDefaultCollection = dbus.ObjectPath("/org/freedesktop/secrets/aliases/default")
+	secretServiceInterface         = "org.freedesktop.Secret.Service"
+	secretCollectionInterface      = "org.freedesktop.Secret.Collection"
+	secretItemInterface            = "org.freedesktop.Secret.Item"
+	secretPromptInterface          = "org.freedesktop.Secret.Prompt"
+	secretSessionInterface         = "org.freedesktop.Secret.Session"
+
+	secretPromptTimeout = 60 * time.Second
+)
+
+// No prompt is needed when a method returns this path as a prompt.
+const noPrompt = dbus.ObjectPath("/")
+
+type secret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+type SecretServiceKeyChainManager struct {
+	conn       *dbus.Conn
+	attributes map[string]string
+}
+
+func newSecretServiceKeyChainManager() (*SecretServiceKeyChainManager, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return nil, err
+	}
+
+	var hasOwner bool
+	err = conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, secretServiceName).Store(&hasOwner)
+	if err != nil {
+		return nil, err
+	}
+	if !hasOwner {
+		// The service may be D-Bus activatable.
+		var names []string
+		err = conn.BusObject().Call("org.freedesktop.DBus.ListActivatableNames", 0).Store(&names)
+		if err != nil || !contains(names, secretServiceName) {
+			return nil, errors.New("secret service is not available")
+		}
+	}
+
+	return &SecretServiceKeyChainManager{
+		conn: conn,
+		attributes: map[string]string{
+			"service": keyChainService,
+			"account": currentUser(),
+		},
+	}, nil
+}
+
+func (km *SecretServiceKeyChainManager) SetToken(token string) error {
+
+	session, err := km.openSession()
+	if err != nil {
+		return err
+	}
+	defer km.closeSession(session)
+
+	if err := km.unlock([]dbus.ObjectPath{secretServiceDefaultCollection}); err != nil {
+		return err
+	}
+
+	properties := map[string]dbus.Variant{
+		secretItemInterface + ".Label":      dbus.MakeVariant(keyChainLabel),
+		secretItemInterface + ".Attributes": dbus.MakeVariant(km.attributes),
+	}
+	s := secret{
+		Session:     session,
+		Parameters:  []byte{},
+		Value:       []byte(token),
+		ContentType: "text/plain",
+	}
+
+	var item, prompt dbus.ObjectPath
+	err = km.conn.Object(secretServiceName, secretServiceDefaultCollection).
+		Call(secretCollectionInterface+".CreateItem", 0, properties, s, true).
+		Store(&item, &prompt)
+	if err != nil {
+		return err
+	}
+
+	_, err = km.prompt(prompt)
+	return err
+}
+
+func (km *SecretServiceKeyChainManager) UpdateToken(token string) (string, string, error) {
+
+	desc := makeTokenDesc(token)
+
+	// 'CreateItem' replaces the item having the same attributes.
+	err := km.SetToken(token)
+
+	if err != nil {
+		return token, desc, err
+	}
+
+	return token, desc, err
+}
+
+func (km *SecretServiceKeyChainManager) GetToken() (string, error) {
+
+	item, err := km.findItem()
+	if err != nil {
+		return "", err
+	}
+	if item == "" {
+		return "", nil
+	}
+
+	session, err := km.openSession()
+	if err != nil {
+		return "", err
+	}
+	defer km.closeSession(session)
+
+	var s secret
+	err = km.conn.Object(secretServiceName, item).Call(secretItemInterface+".GetSecret", 0, session).Store(&s)
+	if err != nil {
+		return "", err
+	}
+
+	return string(s.Value), nil
+}
+
+func (km *SecretServiceKeyChainManager) GetTokenAndDesc() (string, string, error) {
+
+	token, err := km.GetToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, makeTokenDesc(token), nil
+}
+
+func (km *SecretServiceKeyChainManager) DeleteToken() error {
+
+	item, err := km.findItem()
+	if err != nil {
+		return err
+	}
+	if item == "" {
+		return errTokenNotFound
+	}
+
+	var prompt dbus.ObjectPath
+	err = km.conn.Object(secretServiceName, item).Call(secretItemInterface+".Delete", 0).Store(&prompt)
+	if err != nil {
+		return err
+	}
+
+	_, err = km.prompt(prompt)
+	return err
+}
+
+// Returns the item storing the token or an empty path if there is none.
+// A locked item is unlocked so that its secret can be read.
+func (km *SecretServiceKeyChainManager) findItem() (dbus.ObjectPath, error) {
+
+	var unlocked, locked []dbus.ObjectPath
+	err := km.service().Call(secretServiceInterface+".SearchItems", 0, km.attributes).Store(&unlocked, &locked)
+	if err != nil {
+		return "", err
+	}
+
+	if len(unlocked) > 0 {
+		return unlocked[0], nil
+	}
+	if len(locked) > 0 {
+		if err := km.unlock(locked[0:1]); err != nil {
+			return "", err
+		}
+		return locked[0], nil
+	}
+	return "", nil
+}
+
+func (km *SecretServiceKeyChainManager) openSession() (dbus.ObjectPath, error) {
+	// The secret travels in plain over the session bus, which is only accessible by the current user.
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	err := km.service().Call(secretServiceInterface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session)
+	if err != nil {
+		return "", err
+	}
+	return session, nil
+}
+
+func (km *SecretServiceKeyChainManager) closeSession(session dbus.ObjectPath) {
+	km.conn.Object(secretServiceName, session).Call(secretSessionInterface+".Close", 0)
+}
+
+func (km *SecretServiceKeyChainManager) unlock(objects []dbus.ObjectPath) error {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	err := km.service().Call(secretServiceInterface+".Unlock", 0, objects).Store(&unlocked, &prompt)
+	if err != nil {
+		return err
+	}
+
+	dismissed, err := km.prompt(prompt)
+	if err != nil {
+		return err
+	}
+	if dismissed {
+		return errors.New("unlocking the keyring is dismissed")
+	}
+	return nil
+}
+
+// Shows the prompt (e.g. a dialog asking the keyring password) and waits for it to complete.
+// Returns true if the user dismisses it.
+func (km *SecretServiceKeyChainManager) prompt(prompt dbus.ObjectPath) (bool, error) {
+	if prompt == "" || prompt == noPrompt {
+		return false, nil
+	}
+
+	matchOptions := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(prompt),
+		dbus.WithMatchInterface(secretPromptInterface),
+		dbus.WithMatchMember("Completed"),
+	}
+	if err := km.conn.AddMatchSignal(matchOptions...); err != nil {
+		return false, err
+	}
+	defer km.conn.RemoveMatchSignal(matchOptions...)
+
+	signals := make(chan *dbus.Signal, 8)
+	km.conn.Signal(signals)
+	defer km.conn.RemoveSignal(signals)
+
+	err := km.conn.Object(secretServiceName, prompt).Call(secretPromptInterface+".Prompt", 0, "").Err
+	if err != nil {
+		return false, err
+	}
+
+	timeout := time.After(secretPromptTimeout)
+	for {
+		select {
+		case signal := <-signals:
+			if signal.Path != prompt || len(signal.Body) < 1 {
+				continue
+			}
+			dismissed, _ := signal.Body[0].(bool)
+			return dismissed, nil
+		case <-timeout:
+			return false, errors.New("timed out waiting for the keyring prompt")
+		}
+	}
+}
+
+func (km *SecretServiceKeyChainManager) service() dbus.BusObject {
+	return km.conn.Object(secretServiceName, secretServicePath)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
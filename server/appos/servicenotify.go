@@ -0,0 +1,43 @@
+package appos
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Sends a notification like 'READY=1' to systemd if the application runs as a 'Type=notify' service.
+// Returns false without an error if it does not run under systemd.
+func NotifyService(state string) (bool, error) {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return false, nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{
+		Name: socketPath,
+		Net:  "unixgram",
+	})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Returns the interval systemd expects 'WATCHDOG=1' within. Returns 0 if the watchdog is not enabled.
+func ServiceWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
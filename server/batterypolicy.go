@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/st-user/ojm-drone-local/applog"
+	"github.com/st-user/ojm-drone-local/env"
+)
+
+const (
+	BATTERY_WARNING_NONE     = 0
+	BATTERY_WARNING_LOW      = 1
+	BATTERY_WARNING_CRITICAL = 2
+)
+
+const (
+	defaultBatteryWarnPercentage     = 30
+	defaultBatteryCriticalPercentage = 10
+	defaultBatteryGracePeriod        = 5 * time.Second
+)
+
+type BatteryPolicyConfig struct {
+	WarnPercentage     int
+	CriticalPercentage int
+	// How long the battery level has to stay below a threshold before the warning level is raised.
+	GracePeriod time.Duration
+}
+
+func NewBatteryPolicyConfig() BatteryPolicyConfig {
+	config := BatteryPolicyConfig{
+		WarnPercentage:     env.GetInt("BATTERY_WARN_PERCENTAGE"),
+		CriticalPercentage: env.GetInt("BATTERY_CRITICAL_PERCENTAGE"),
+		GracePeriod:        env.GetDuration("BATTERY_GRACE_PERIOD"),
+	}
+	if config.WarnPercentage <= 0 {
+		config.WarnPercentage = defaultBatteryWarnPercentage
+	}
+	if config.CriticalPercentage <= 0 {
+		config.CriticalPercentage = defaultBatteryCriticalPercentage
+	}
+	if config.GracePeriod <= 0 {
+		config.GracePeriod = defaultBatteryGracePeriod
+	}
+	return config
+}
+
+// BatteryPolicy raises the battery warning level and forces the drone to land at the critical level.
+// Once the landing is forced, motion vectors are ignored until the next takeoff.
+type BatteryPolicy struct {
+	config          BatteryPolicyConfig
+	level           int
+	pendingLevel    int
+	pendingSince    time.Time
+	isForcedLanding bool
+	mutex           sync.Mutex
+}
+
+func NewBatteryPolicy(config BatteryPolicyConfig) *BatteryPolicy {
+	return &BatteryPolicy{
+		config: config,
+	}
+}
+
+// Updates the warning level with the latest flight data.
+// Returns the warning level and whether the drone has to land now.
+func (p *BatteryPolicy) Update(fd FlightData) (int, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	level := BATTERY_WARNING_NONE
+	switch {
+	case fd.BatteryPercentage <= p.config.CriticalPercentage:
+		level = BATTERY_WARNING_CRITICAL
+	case fd.BatteryPercentage <= p.config.WarnPercentage:
+		level = BATTERY_WARNING_LOW
+	}
+
+	now := time.Now()
+	switch {
+	case level <= p.level:
+		p.level = level
+		p.pendingLevel = level
+	case level != p.pendingLevel:
+		p.pendingLevel = level
+		p.pendingSince = now
+	case p.config.GracePeriod <= now.Sub(p.pendingSince):
+		p.level = level
+		applog.Warn("Battery level is %v%%. The warning level changes to %v.", fd.BatteryPercentage, level)
+	}
+
+	if p.level == BATTERY_WARNING_CRITICAL && fd.Flying && !p.isForcedLanding {
+		applog.Warn("Lands automatically because the battery level(%v%%) is critical.", fd.BatteryPercentage)
+		p.isForcedLanding = true
+		return p.level, true
+	}
+	return p.level, false
+}
+
+func (p *BatteryPolicy) IsForcedLanding() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.isForcedLanding
+}
+
+func (p *BatteryPolicy) CanTakeOff() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.level == BATTERY_WARNING_CRITICAL {
+		return fmt.Errorf("the battery level is critical")
+	}
+	return nil
+}
+
+// Accepts motion vectors again. Called when the drone takes off.
+func (p *BatteryPolicy) Reset() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.isForcedLanding = false
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBatteryPolicyUpdate(t *testing.T) {
+	type update struct {
+		batteryPercentage int
+		flying            bool
+		expectedLevel     int
+		expectedLand      bool
+	}
+	tests := []struct {
+		name        string
+		gracePeriod time.Duration
+		updates     []update
+	}{
+		{
+			name:        "above the thresholds",
+			gracePeriod: time.Nanosecond,
+			updates: []update{
+				{batteryPercentage: 80, flying: true, expectedLevel: BATTERY_WARNING_NONE},
+				{batteryPercentage: 31, flying: true, expectedLevel: BATTERY_WARNING_NONE},
+			},
+		},
+		{
+			name:        "warns after the level stays low",
+			gracePeriod: time.Nanosecond,
+			updates: []update{
+				{batteryPercentage: 30, flying: true, expectedLevel: BATTERY_WARNING_NONE},
+				{batteryPercentage: 30, flying: true, expectedLevel: BATTERY_WARNING_LOW},
+			},
+		},
+		{
+			name:        "ignores a drop within the grace period",
+			gracePeriod: time.Hour,
+			updates: []update{
+				{batteryPercentage: 5, flying: true, expectedLevel: BATTERY_WARNING_NONE},
+				{batteryPercentage: 5, flying: true, expectedLevel: BATTERY_WARNING_NONE},
+			},
+		},
+		{
+			name:        "forces landing once at the critical level",
+			gracePeriod: time.Nanosecond,
+			updates: []update{
+				{batteryPercentage: 10, flying: true, expectedLevel: BATTERY_WARNING_NONE},
+				{batteryPercentage: 10, flying: true, expectedLevel: BATTERY_WARNING_CRITICAL, expectedLand: true},
+				{batteryPercentage: 9, flying: true, expectedLevel: BATTERY_WARNING_CRITICAL},
+			},
+		},
+		{
+			name:        "does not force landing on the ground",
+			gracePeriod: time.Nanosecond,
+			updates: []update{
+				{batteryPercentage: 10, expectedLevel: BATTERY_WARNING_NONE},
+				{batteryPercentage: 10, expectedLevel: BATTERY_WARNING_CRITICAL},
+			},
+		},
+		{
+			name:        "lowers the level immediately",
+			gracePeriod: time.Nanosecond,
+			updates: []update{
+				{batteryPercentage: 20, expectedLevel: BATTERY_WARNING_NONE},
+				{batteryPercentage: 20, expectedLevel: BATTERY_WARNING_LOW},
+				{batteryPercentage: 90, expectedLevel: BATTERY_WARNING_NONE},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewBatteryPolicy(BatteryPolicyConfig{
+				WarnPercentage:     30,
+				CriticalPercentage: 10,
+				GracePeriod:        tt.gracePeriod,
+			})
+
+			for i, u := range tt.updates {
+				// Lets the grace period pass between the updates.
+				time.Sleep(time.Millisecond)
+				level, land := p.Update(FlightData{BatteryPercentage: u.batteryPercentage, Flying: u.flying})
+				if level != u.expectedLevel || land != u.expectedLand {
+					t.Errorf("update %v = %v, %v, want %v, %v", i, level, land, u.expectedLevel, u.expectedLand)
+				}
+			}
+		})
+	}
+}
+
+func TestBatteryPolicyForcedLanding(t *testing.T) {
+	p := NewBatteryPolicy(BatteryPolicyConfig{
+		WarnPercentage:     30,
+		CriticalPercentage: 10,
+		GracePeriod:        time.Nanosecond,
+	})
+	p.Update(FlightData{BatteryPercentage: 5, Flying: true})
+	time.Sleep(time.Millisecond)
+	if _, land := p.Update(FlightData{BatteryPercentage: 5, Flying: true}); !land {
+		t.Fatal("landing is not forced at the critical level")
+	}
+	if !p.IsForcedLanding() {
+		t.Fatal("IsForcedLanding is false after the landing is forced")
+	}
+	if p.CanTakeOff() == nil {
+		t.Fatal("takeoff is accepted at the critical level")
+	}
+
+	p.Reset()
+	if p.IsForcedLanding() {
+		t.Fatal("IsForcedLanding is true after Reset")
+	}
+}
@@ -1,20 +1,29 @@
 package main
 
 import (
+	"context"
 	"sync"
 
 	"github.com/pion/rtcp"
+	"github.com/st-user/ojm-drone-local/applog"
 )
 
+// RoutineCoordinator owns the goroutines of a run of the signaling connection or a drone.
+// A run begins with Start and ends with StopApp, which cancels the context of the run.
+// Every goroutine of a run is started by Go and receives the context, so Start and Wait can wait until all of them return.
+// The channels are never closed. A send gives up when the run is cancelled instead.
 type RoutineCoordinator struct {
-	DroneCommandChannel           chan DroneCommand
-	DroneFrameChannel             chan []byte
-	DataChannelMessageChannel     chan string
-	RTCPPacketChannel             chan rtcp.Packet
-	StopSignalChannel             chan struct{}
-	IsStopped                     bool
-	waitGroupUntilReleasingSocket sync.WaitGroup
-	mutex                         sync.Mutex
+	DroneCommandChannel   chan DroneCommand
+	DroneFrames           *FramePipeline
+	RTCPPacketChannel     chan rtcp.Packet
+	MissionControlChannel chan string
+	ctx                   context.Context
+	cancel                context.CancelFunc
+	routines              sync.WaitGroup
+	mutex                 sync.Mutex
+	// Opened by the DataChannel writer while it runs. nil while no primary peer is connected.
+	messageQueue chan string
+	photoQueue   chan PhotoMetadata
 }
 
 type DroneCommand struct {
@@ -29,78 +38,234 @@ type MotionVector struct {
 	R float32
 }
 
-func (r *RoutineCoordinator) InitRoutineCoordinator(force bool) {
+// The number of the messages and the photos waiting to be sent to the primary peer.
+const (
+	messageQueueSize = 8
+	photoQueueSize   = 4
+)
+
+// Creates a stopped RoutineCoordinator.
+func NewRoutineCoordinator() *RoutineCoordinator {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	return &RoutineCoordinator{
+		DroneCommandChannel:   make(chan DroneCommand),
+		DroneFrames:           NewFramePipelineFromEnv(),
+		RTCPPacketChannel:     make(chan rtcp.Packet),
+		MissionControlChannel: make(chan string, missionControlQueueSize),
+		ctx:                   ctx,
+		cancel:                cancel,
+	}
+}
+
+// Begins a new run after all the goroutines of the previous run return. Does nothing if it is running.
+func (r *RoutineCoordinator) Start() {
+	if !r.IsStopped() {
+		return
+	}
+	r.routines.Wait()
+
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	if r.IsStopped || force {
-		r.DroneCommandChannel = make(chan DroneCommand)
-		r.DroneFrameChannel = make(chan []byte)
-		r.DataChannelMessageChannel = make(chan string)
-		r.RTCPPacketChannel = make(chan rtcp.Packet)
-		r.StopSignalChannel = make(chan struct{})
+	if r.ctx.Err() == nil {
+		return
 	}
-	r.IsStopped = false
+	r.DroneFrames.Clear()
+	r.ctx, r.cancel = context.WithCancel(context.Background())
 }
 
+// Cancels the current run. The goroutines of the run return asynchronously. Use Wait to wait for them.
 func (r *RoutineCoordinator) StopApp() {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	if r.IsStopped {
-		return
+	r.cancel()
+}
+
+// Starts a goroutine owned by the current run. It has to return when the context is done.
+// If it returns an error, the run is cancelled like errgroup does.
+// Returns false without starting it if the run has already been cancelled.
+func (r *RoutineCoordinator) Go(name string, routine func(ctx context.Context) error) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ctx, cancel := r.ctx, r.cancel
+	if ctx.Err() != nil {
+		return false
 	}
 
-	r.IsStopped = true
-	close(r.DroneCommandChannel)
-	close(r.DroneFrameChannel)
-	close(r.DataChannelMessageChannel)
-	close(r.RTCPPacketChannel)
-	close(r.StopSignalChannel)
+	r.routines.Add(1)
+	go func() {
+		defer r.routines.Done()
+
+		if err := routine(ctx); err != nil {
+			applog.Warn("Routine '%v' fails. Stops the others. %v", name, err)
+			cancel()
+			return
+		}
+		applog.Debug("Routine '%v' returns.", name)
+	}()
+	return true
 }
 
-func (r *RoutineCoordinator) WaitUntilReleasingSocket() {
-	r.waitGroupUntilReleasingSocket.Wait()
+// Waits until all the goroutines started by Go return.
+func (r *RoutineCoordinator) Wait() {
+	r.routines.Wait()
 }
 
-func (r *RoutineCoordinator) AddWaitGroupUntilReleasingSocket() {
-	r.waitGroupUntilReleasingSocket.Add(1)
+// The context of the current run. Capture it once so that a goroutine is not affected by the next run.
+func (r *RoutineCoordinator) Context() context.Context {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.ctx
 }
 
-func (r *RoutineCoordinator) DoneWaitGroupUntilReleasingSocket() {
-	r.waitGroupUntilReleasingSocket.Done()
+func (r *RoutineCoordinator) IsStopped() bool {
+	return r.Context().Err() != nil
 }
 
 func (r *RoutineCoordinator) SendDroneCommandChannel(data DroneCommand) {
-	if !r.IsStopped {
-		r.DroneCommandChannel <- data
+	ctx := r.Context()
+	select {
+	case r.DroneCommandChannel <- data:
+	case <-ctx.Done():
 	}
 }
 
-func (r *RoutineCoordinator) SendDroneFrameChannel(data *[]byte) {
-	if !r.IsStopped {
-		r.DroneFrameChannel <- *data
-	}
+// Sends the command from a goroutine owned by the current run,
+// for a caller such as a callback of the controller which the command loop may be waiting for.
+func (r *RoutineCoordinator) GoSendDroneCommand(data DroneCommand) {
+	r.Go("drone command '"+data.CommandType+"'", func(ctx context.Context) error {
+		r.SendDroneCommandChannel(data)
+		return nil
+	})
 }
 
-func (r *RoutineCoordinator) SendDataChannelMessageChannel(data string) {
-	if !r.IsStopped {
-		r.DataChannelMessageChannel <- data
+// Unlike the channels, it does not block. A frame is dropped if the writer does not keep up.
+func (r *RoutineCoordinator) SendDroneFrame(frame VideoFrame) {
+	if !r.IsStopped() {
+		r.DroneFrames.Push(frame)
 	}
 }
 
 func (r *RoutineCoordinator) SendRTCPPacketChannel(data rtcp.Packet) {
-	if !r.IsStopped {
-		r.RTCPPacketChannel <- data
+	ctx := r.Context()
+	select {
+	case r.RTCPPacketChannel <- data:
+	case <-ctx.Done():
+	}
+}
+
+// Unlike the other channels, it does not block. Returns false if the control is not queued
+// because the run is stopped or 'missionControlQueueSize' controls are waiting.
+func (r *RoutineCoordinator) SendMissionControl(data string) bool {
+	if r.IsStopped() {
+		return false
+	}
+	select {
+	case r.MissionControlChannel <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// Unlike the channels, it does not block, so that a request to the drone does not wait for the primary peer.
+// The message is skipped if no primary peer is connected and dropped if 'messageQueueSize' messages are already waiting.
+// Returns whether it is queued.
+func (r *RoutineCoordinator) SendDataChannelMessage(data string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.messageQueue == nil || r.ctx.Err() != nil {
+		return false
+	}
+	select {
+	case r.messageQueue <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// Opens the queue SendDataChannelMessage puts the messages into. Called by the writer which sends them.
+func (r *RoutineCoordinator) OpenMessageQueue() <-chan string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.messageQueue = make(chan string, messageQueueSize)
+	return r.messageQueue
+}
+
+// Closes the queue opened by OpenMessageQueue. The messages left in it are dropped.
+func (r *RoutineCoordinator) CloseMessageQueue(queue <-chan string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// A new writer may have opened another one.
+	if r.messageQueue == queue {
+		r.messageQueue = nil
 	}
 }
 
-func (r *RoutineCoordinator) SendStopSignalChannel(data struct{}) {
-	if !r.IsStopped {
-		r.StopSignalChannel <- data
+// Unlike the channels, it does not block. The photo is skipped if no primary peer is connected
+// and dropped if 'photoQueueSize' photos are already waiting. Returns whether it is queued.
+func (r *RoutineCoordinator) SendPhoto(data PhotoMetadata) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.photoQueue == nil || r.ctx.Err() != nil {
+		return false
+	}
+	select {
+	case r.photoQueue <- data:
+		return true
+	default:
+		return false
 	}
 }
 
+// Opens the queue SendPhoto puts the photos into. Called by the writer which sends them.
+func (r *RoutineCoordinator) OpenPhotoQueue() <-chan PhotoMetadata {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.photoQueue = make(chan PhotoMetadata, photoQueueSize)
+	return r.photoQueue
+}
+
+// Closes the queue opened by OpenPhotoQueue. The photos left in it are dropped.
+func (r *RoutineCoordinator) ClosePhotoQueue(queue <-chan PhotoMetadata) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// A new writer may have opened another one.
+	if r.photoQueue == queue {
+		r.photoQueue = nil
+	}
+}
+
+// Lands the drone and tells the primary peer that it is landing.
+func RequestLanding(r *RoutineCoordinator) {
+	r.SendDroneCommandChannel(DroneCommand{
+		CommandType: "land",
+	})
+	r.SendDataChannelMessage("land")
+}
+
+// Stops the motors of the drone and tells the primary peer about it.
+// 'source' is recorded to the audit log.
+func RequestEmergencyStop(r *RoutineCoordinator, source string) {
+	r.SendDroneCommandChannel(DroneCommand{
+		CommandType: "emergency",
+		Command:     source,
+	})
+	r.SendDataChannelMessage("emergency")
+}
+
 func (mVec *MotionVector) isZeroVector() bool {
 	return mVec.X == 0 && mVec.Y == 0 && mVec.Z == 0 && mVec.R == 0
 }
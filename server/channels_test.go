@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+func TestMain(m *testing.M) {
+	// The tests run with the default settings.
+	os.Setenv("GO_ENV_FILE_PATH", filepath.Join("..", "template.env"))
+	os.Exit(m.Run())
+}
+
+// Fails the test if f does not return within the timeout.
+func returnsWithin(t *testing.T, timeout time.Duration, name string, f func()) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatalf("%v does not return within %v", name, timeout)
+	}
+}
+
+func TestRoutineCoordinatorIsStoppedUntilStart(t *testing.T) {
+	r := NewRoutineCoordinator()
+
+	if !r.IsStopped() {
+		t.Fatal("a new RoutineCoordinator is running")
+	}
+	if r.Go("routine", func(ctx context.Context) error { return nil }) {
+		t.Fatal("Go starts a routine before Start")
+	}
+	returnsWithin(t, time.Second, "SendDroneCommandChannel", func() {
+		r.SendDroneCommandChannel(DroneCommand{CommandType: "land"})
+	})
+}
+
+func TestRoutineCoordinatorCycles(t *testing.T) {
+	r := NewRoutineCoordinator()
+
+	for cycle := 0; cycle < 20; cycle++ {
+		r.Start()
+		if r.IsStopped() {
+			t.Fatalf("cycle %v: not running after Start", cycle)
+		}
+
+		received := make(chan DroneCommand, 100)
+		r.Go("command reader", func(ctx context.Context) error {
+			for {
+				select {
+				case command := <-r.DroneCommandChannel:
+					received <- command
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		})
+		r.Go("frame reader", func(ctx context.Context) error {
+			for {
+				if _, ok := r.DroneFrames.Pop(ctx); !ok {
+					return nil
+				}
+			}
+		})
+
+		// Nothing reads the other channels, so the sends return only when the run is stopped.
+		var senders sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			senders.Add(1)
+			go func(i int) {
+				defer senders.Done()
+
+				r.SendDroneCommandChannel(DroneCommand{CommandType: "vector", Command: MotionVector{}})
+				r.GoSendDroneCommand(DroneCommand{CommandType: "land"})
+				r.SendDroneFrame(NewVideoFrame([]byte{0, 0, 0, 1, 0x65}, time.Now()))
+				r.SendPhoto(PhotoMetadata{})
+				switch i % 3 {
+				case 0:
+					r.SendDataChannelMessage("land")
+				case 1:
+					r.SendRTCPPacketChannel(&rtcp.PictureLossIndication{})
+				case 2:
+					r.SendMissionControl(MISSION_CONTROL_PAUSE)
+				}
+			}(i)
+		}
+
+		// A sender of the commands is received before stopping.
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatalf("cycle %v: no command is received", cycle)
+		}
+
+		r.StopApp()
+		if !r.IsStopped() {
+			t.Fatalf("cycle %v: running after StopApp", cycle)
+		}
+		returnsWithin(t, 5*time.Second, "the senders", senders.Wait)
+		returnsWithin(t, 5*time.Second, "Wait", r.Wait)
+
+		if r.Go("late routine", func(ctx context.Context) error { return nil }) {
+			t.Fatalf("cycle %v: Go starts a routine after StopApp", cycle)
+		}
+	}
+}
+
+func TestRoutineCoordinatorStartWhileRunning(t *testing.T) {
+	r := NewRoutineCoordinator()
+	r.Start()
+	ctx := r.Context()
+
+	returnsWithin(t, time.Second, "Start", r.Start)
+	if r.Context() != ctx {
+		t.Fatal("Start while running begins a new run")
+	}
+
+	r.StopApp()
+	r.Wait()
+}
+
+func TestRoutineCoordinatorStartWaitsForPreviousRun(t *testing.T) {
+	r := NewRoutineCoordinator()
+	r.Start()
+
+	var returned sync.WaitGroup
+	returned.Add(1)
+	release := make(chan struct{})
+	previousCtx := r.Context()
+	r.Go("slow routine", func(ctx context.Context) error {
+		defer returned.Done()
+		<-ctx.Done()
+		<-release
+		return nil
+	})
+	r.StopApp()
+
+	started := make(chan struct{})
+	go func() {
+		r.Start()
+		close(started)
+	}()
+
+	select {
+	case <-started:
+		t.Fatal("Start returns before the routines of the previous run return")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	returned.Wait()
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("Start does not return after the routines of the previous run return")
+	}
+
+	if r.Context() == previousCtx || r.IsStopped() {
+		t.Fatal("Start does not begin a new run")
+	}
+	r.StopApp()
+	r.Wait()
+}
+
+func TestRoutineCoordinatorErrorStopsRun(t *testing.T) {
+	r := NewRoutineCoordinator()
+	r.Start()
+
+	other := make(chan struct{})
+	r.Go("other", func(ctx context.Context) error {
+		<-ctx.Done()
+		close(other)
+		return nil
+	})
+	r.Go("failing", func(ctx context.Context) error {
+		return errors.New("fails")
+	})
+
+	select {
+	case <-other:
+	case <-time.After(time.Second):
+		t.Fatal("an error of a routine does not stop the others")
+	}
+	if !r.IsStopped() {
+		t.Fatal("an error of a routine does not stop the run")
+	}
+	r.Wait()
+}
+
+func TestRoutineCoordinatorSendPhoto(t *testing.T) {
+	r := NewRoutineCoordinator()
+	r.Start()
+	defer r.Wait()
+	defer r.StopApp()
+
+	if r.SendPhoto(PhotoMetadata{Id: "1"}) {
+		t.Fatal("a photo is queued while no writer is connected")
+	}
+
+	photos := r.OpenPhotoQueue()
+	for i := 0; i < photoQueueSize; i++ {
+		if !r.SendPhoto(PhotoMetadata{Id: "1"}) {
+			t.Fatalf("photo %v is not queued", i)
+		}
+	}
+	if r.SendPhoto(PhotoMetadata{Id: "1"}) {
+		t.Fatal("a photo is queued beyond the size of the queue")
+	}
+	<-photos
+	if !r.SendPhoto(PhotoMetadata{Id: "1"}) {
+		t.Fatal("a photo is not queued after the writer takes one")
+	}
+
+	// The queue of a previous writer does not close the one of the current writer.
+	current := r.OpenPhotoQueue()
+	r.ClosePhotoQueue(photos)
+	if !r.SendPhoto(PhotoMetadata{Id: "2"}) {
+		t.Fatal("closing a previous queue closes the current one")
+	}
+	r.ClosePhotoQueue(current)
+	if r.SendPhoto(PhotoMetadata{Id: "3"}) {
+		t.Fatal("a photo is queued after the writer closes the queue")
+	}
+}
+
+func TestRequestLandingWithoutPrimaryPeer(t *testing.T) {
+	r := NewRoutineCoordinator()
+	r.Start()
+	defer r.Wait()
+	defer r.StopApp()
+
+	commands := make(chan DroneCommand, 2)
+	r.Go("drone command reader", func(ctx context.Context) error {
+		for {
+			select {
+			case command := <-r.DroneCommandChannel:
+				commands <- command
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	})
+
+	// No DataChannel writer is open, so the peer notifications are skipped instead of blocking.
+	returnsWithin(t, time.Second, "RequestLanding", func() {
+		RequestLanding(r)
+	})
+	returnsWithin(t, time.Second, "RequestEmergencyStop", func() {
+		RequestEmergencyStop(r, EMERGENCY_STOP_SOURCE_HTTP)
+	})
+	if command := <-commands; command.CommandType != "land" {
+		t.Fatalf("unexpected command '%v'", command.CommandType)
+	}
+	if command := <-commands; command.CommandType != "emergency" {
+		t.Fatalf("unexpected command '%v'", command.CommandType)
+	}
+
+	messages := r.OpenMessageQueue()
+	defer r.CloseMessageQueue(messages)
+	RequestLanding(r)
+	if message := <-messages; message != "land" {
+		t.Fatalf("unexpected message '%v'", message)
+	}
+}
@@ -0,0 +1,54 @@
+// Command fakesignaling starts a stand-in for 'ojm-drone-remote' which ojm-drone-local can connect to.
+//
+//	go run ./cmd/fakesignaling -addr localhost:8080 -start-key test
+//
+// Peers are simulated through the control endpoints. For example:
+//
+//	curl -X POST 'http://localhost:8080/fake/canOffer?startKey=test&peerConnectionId=p1&isPrimary=true'
+//	curl -X POST 'http://localhost:8080/fake/close?startKey=test&peerConnectionId=p1&isPrimary=true'
+//	curl -X POST 'http://localhost:8080/fake/disconnect?startKey=test'
+//
+// 'droneId' selects the drone of the peer when the application flies several drones.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/st-user/ojm-drone-local/fakesignaling"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "address to listen on")
+	tokens := flag.String("tokens", "", "comma separated access tokens to accept. any token is accepted if empty")
+	startKeys := flag.String("start-key", "", "comma separated start keys to accept in addition to the generated ones")
+	stunURL := flag.String("stun", "stun:stun.l.google.com:19302", "STUN server sent in iceServerInfo. none if empty")
+	pingInterval := flag.Duration("ping-interval", 30*time.Second, "interval of ping messages")
+	flag.Parse()
+
+	options := fakesignaling.Options{
+		PingInterval: *pingInterval,
+		Logf:         log.Printf,
+	}
+	if *tokens != "" {
+		options.AccessTokens = strings.Split(*tokens, ",")
+	}
+	if *stunURL != "" {
+		options.ICEServers = []fakesignaling.ICEServer{
+			{URLs: []string{*stunURL}},
+		}
+	}
+
+	server := fakesignaling.NewServer(options)
+	if *startKeys != "" {
+		for _, startKey := range strings.Split(*startKeys, ",") {
+			server.RegisterStartKey(startKey)
+		}
+	}
+
+	log.Printf("Fake signaling server listens on %v", *addr)
+	log.Fatal(http.ListenAndServe(*addr, server))
+}
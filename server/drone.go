@@ -1,34 +1,111 @@
 package main
 
 import (
+	"context"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/pion/rtcp"
 	"github.com/st-user/ojm-drone-local/applog"
-	"gobot.io/x/gobot"
-	"gobot.io/x/gobot/platforms/dji/tello"
+	"github.com/st-user/ojm-drone-local/env"
+	"github.com/st-user/ojm-drone-local/flightrecorder"
 )
 
+// The health check completes an iteration every second or two. Reconnecting the drone may take longer.
+const droneLivenessTimeout = 30 * time.Second
+
 type Drone struct {
-	driver                *tello.Driver
+	id                    string
+	controller            FlightController
+	newController         FlightControllerFactory
 	videoStreamingStarted atomic.Value
 	safetySignal          SafetySignal
+	safetyEnvelope        *SafetyEnvelope
+	batteryPolicy         *BatteryPolicy
+	flightState           *FlightStateMachine
+	recorder              *flightrecorder.Recorder
+	videoRecorder         *flightrecorder.VideoRecorder
+	videoRecorderMux      sync.Mutex
+	// When the health check last completed an iteration. Stale if the loops of the drone are stuck.
+	checkedAt atomic.Value
+	// The latest key frame of the video stream, which '/cgi/snapshot' provides.
+	snapshot *flightrecorder.Snapshot
 }
 
-func NewDrone() *Drone {
+func NewDrone(id string, newController FlightControllerFactory, snapshot *flightrecorder.Snapshot) *Drone {
 	d := Drone{
-		safetySignal: NewSafetySignal(),
+		id:             id,
+		newController:  newController,
+		snapshot:       snapshot,
+		safetySignal:   NewSafetySignal(),
+		safetyEnvelope: NewSafetyEnvelope(NewSafetyEnvelopeConfig()),
+		batteryPolicy:  NewBatteryPolicy(NewBatteryPolicyConfig()),
 	}
 	d.endVideoStreaming()
+	d.checkedAt.Store(time.Now())
 	return &d
 }
 
+// Returns false if the health check has not completed an iteration for 'droneLivenessTimeout'.
+func (drone *Drone) IsAlive() bool {
+	return time.Since(drone.checkedAt.Load().(time.Time)) < droneLivenessTimeout
+}
+
 func (drone *Drone) isVideoStreamingStarted() bool {
 	return drone.videoStreamingStarted.Load().(bool)
 }
 
+// The recorder of the current flight. Nil if the flight record file could not be created.
+func (drone *Drone) Recorder() *flightrecorder.Recorder {
+	return drone.recorder
+}
+
+func (drone *Drone) startVideoRecording(applicationStates *ApplicationStates) {
+	drone.videoRecorderMux.Lock()
+	defer drone.videoRecorderMux.Unlock()
+
+	if drone.videoRecorder != nil {
+		return
+	}
+
+	videoRecorder, err := flightrecorder.NewVideoRecorder(drone.id)
+	if err != nil {
+		applog.Warn("Fails to start video recording. %v", err)
+		return
+	}
+	drone.videoRecorder = videoRecorder
+	applicationStates.SetVideoRecording(true)
+}
+
+// Closing the recorder waits until the queued frames are written, so it is done in another routine
+// while the drone is running. When the drone is stopping, it is done in this routine so that Wait waits for it.
+func (drone *Drone) stopVideoRecording(routineCoordinator *RoutineCoordinator, applicationStates *ApplicationStates) {
+	drone.videoRecorderMux.Lock()
+	videoRecorder := drone.videoRecorder
+	drone.videoRecorder = nil
+	drone.videoRecorderMux.Unlock()
+
+	applicationStates.SetVideoRecording(false)
+	if videoRecorder == nil {
+		return
+	}
+	started := routineCoordinator.Go("video recorder close", func(_ context.Context) error {
+		videoRecorder.Close()
+		return nil
+	})
+	if !started {
+		videoRecorder.Close()
+	}
+}
+
+func (drone *Drone) recordVideoFrame(data []byte) {
+	drone.videoRecorderMux.Lock()
+	defer drone.videoRecorderMux.Unlock()
+
+	drone.videoRecorder.WriteFrame(data)
+}
+
 func (drone *Drone) StartVideoStreaming() {
 	drone.videoStreamingStarted.Store(true)
 }
@@ -39,41 +116,73 @@ func (drone *Drone) endVideoStreaming() {
 
 func (drone *Drone) Start(routineCoordinator *RoutineCoordinator, applicationStates *ApplicationStates) {
 
-	var driver *tello.Driver
-	var robot *gobot.Robot
+	var controller FlightController
 	var robotMux sync.Mutex
 
-	lastTimestampVideoReceived := time.Now().Add(-1 * time.Hour)
-	lastTimestampFightDataReceived := time.Now().Add(-1 * time.Hour)
-	latestBatteryLevel := 0
+	drone.flightState = NewFlightStateMachine(applicationStates)
+
+	recorder, err := flightrecorder.NewRecorder(drone.id)
+	if err != nil {
+		applog.Warn("Fails to create a flight record file. The flight is not recorded. %v", err)
+	}
+	drone.recorder = recorder
+	drone.flightState.OnTransit(func(from FlightState, to FlightState) {
+		isTakeOff := from == FLIGHT_STATE_LANDED && to.isAirborne()
+		isLanding := from.isAirborne() && to == FLIGHT_STATE_LANDED
+		if isTakeOff || isLanding {
+			recorder.Rotate()
+		}
+	})
+
+	// Written by the callbacks of the controller and read by the health check.
+	lastTimestampVideoReceived := time.Now().Add(-1 * time.Hour).UnixNano()
+	lastTimestampFightDataReceived := time.Now().Add(-1 * time.Hour).UnixNano()
+	var latestBatteryLevel int32
+	sinceLastReceived := func(timestamp *int64) time.Duration {
+		return time.Since(time.Unix(0, atomic.LoadInt64(timestamp)))
+	}
 
 	startRobot := func() {
 
 		robotMux.Lock()
 
-		driver = tello.NewDriver("8888")
-		drone.driver = driver
-
-		var once sync.Once
-		driver.On(tello.ConnectedEvent, func(data interface{}) {
-			once.Do(func() {
-				applog.Info("Starts receiving video frames from your drone.")
-				driver.StartVideo()
-				driver.SetVideoEncoderRate(tello.VideoBitRate1M)
-				gobot.Every(10*time.Second, func() {
-					driver.StartVideo()
-				})
-			})
-		})
+		controller = drone.newController()
+		drone.controller = controller
 
 		lastLoggedTime := time.Now()
-		driver.On(tello.FlightDataEvent, func(data interface{}) {
-			lastTimestampFightDataReceived = time.Now()
+		controller.OnFlightData(func(fd FlightData) {
+			atomic.StoreInt64(&lastTimestampFightDataReceived, time.Now().UnixNano())
+			applicationStates.SetTelemetry(NewTelemetry(fd))
+			recorder.Record(flightrecorder.RECORD_TYPE_FLIGHT_DATA, fd)
+
+			warningLevel, mustLand := drone.batteryPolicy.Update(fd)
+			applicationStates.SetBatteryWarning(warningLevel)
+			if mustLand {
+				// Sent asynchronously because the command loop may be waiting for this handler to return.
+				routineCoordinator.Go("battery landing request", func(ctx context.Context) error {
+					RequestLanding(routineCoordinator)
+					return nil
+				})
+			}
+
+			isFailsafe := drone.batteryPolicy.IsForcedLanding() || applicationStates.GetLinkState() == LINK_STATE_LOST
+			drone.flightState.OnFlightData(fd, isFailsafe)
+
+			switch drone.safetyEnvelope.UpdateFlightData(fd) {
+			case SAFETY_ACTION_LAND:
+				routineCoordinator.GoSendDroneCommand(DroneCommand{
+					CommandType: "land",
+				})
+			case SAFETY_ACTION_HOVER:
+				routineCoordinator.GoSendDroneCommand(DroneCommand{
+					CommandType: "vector",
+					Command:     MotionVector{},
+				})
+			}
 
 			if 3 < time.Since(lastLoggedTime).Seconds() {
-				fd := data.(*tello.FlightData)
 
-				latestBatteryLevel = int(fd.BatteryPercentage)
+				atomic.StoreInt32(&latestBatteryLevel, int32(fd.BatteryPercentage))
 				applog.Info("Battery level %v%%", fd.BatteryPercentage)
 
 				lastLoggedTime = time.Now()
@@ -83,6 +192,7 @@ func (drone *Drone) Start(routineCoordinator *RoutineCoordinator, applicationSta
 		// Thanks to [oliverpool/tello-webrtc-fpv](https://github.com/oliverpool/tello-webrtc-fpv)
 		// I was able to figure out the timing at which h264 packets should be send to a browser.
 		var buf []byte
+		var bufReceivedAt time.Time
 		isNalUnitStart := func(b []byte) bool {
 			return len(b) > 3 && b[0] == 0 && b[1] == 0 && b[2] == 0 && b[3] == 1
 		}
@@ -92,8 +202,9 @@ func (drone *Drone) Start(routineCoordinator *RoutineCoordinator, applicationSta
 		}
 
 		loggedRecoverCount := 0
-		handleData := func(_data interface{}) {
-			lastTimestampVideoReceived = time.Now()
+		handleData := func(data []byte) {
+			receivedAt := time.Now()
+			atomic.StoreInt64(&lastTimestampVideoReceived, receivedAt.UnixNano())
 
 			defer func() {
 				if r := recover(); r != nil {
@@ -105,37 +216,59 @@ func (drone *Drone) Start(routineCoordinator *RoutineCoordinator, applicationSta
 				}
 			}()
 
-			data := _data.([]byte)
-
 			if !isNalUnitStart(data) || !sendPreviousBytes(data) {
 				buf = append(buf, data...)
 				return
 			} else {
 
+				drone.recordVideoFrame(buf)
+				drone.snapshot.Update(buf)
+
 				if drone.isVideoStreamingStarted() {
-					routineCoordinator.SendDroneFrameChannel(&buf)
+					routineCoordinator.SendDroneFrame(NewVideoFrame(buf, bufReceivedAt))
 				}
 
 				var zero []byte
 				buf = append(zero, data...)
+				bufReceivedAt = receivedAt
 			}
 
 		}
-		driver.On(tello.VideoFrameEvent, handleData)
+		controller.OnVideoFrame(handleData)
+
+		photoStore := NewPhotoStore()
+		controller.OnPicture(func(data []byte) {
+			metadata, err := photoStore.Save(drone.id, data, applicationStates.GetTelemetry())
+			if err != nil {
+				applog.Warn("Fails to save a photo. %v", err)
+				return
+			}
+			applog.Info("Saves a photo %v of drone '%v'.", metadata.Id, drone.id)
+			recorder.Record(flightrecorder.RECORD_TYPE_PHOTO, metadata)
 
-		robot = gobot.NewRobot(
-			[]gobot.Connection{},
-			[]gobot.Device{driver},
-		)
-		robot.AutoRun = false
-		robot.Start()
+			if env.GetBool("PHOTO_TRANSFER_TO_PRIMARY_PEER") && !routineCoordinator.SendPhoto(metadata) {
+				applog.Info("Photo %v is not sent to the primary peer because it is not connected or busy.", metadata.Id)
+			}
+		})
+
+		controller.OnRotationEnd(func() {
+			routineCoordinator.GoSendDroneCommand(DroneCommand{
+				CommandType: "ceaseRotation",
+			})
+		})
+
+		if err := controller.Connect(); err != nil {
+			applog.Warn("Fails to connect to the drone. %v", err)
+			recorder.RecordConnection("drone", "failed")
+		} else {
+			recorder.RecordConnection("drone", "connected")
+			drone.flightState.OnConnected()
+		}
 
 		robotMux.Unlock()
 	}
 
-	routineCoordinator.AddWaitGroupUntilReleasingSocket()
-	go func() {
-		defer routineCoordinator.DoneWaitGroupUntilReleasingSocket()
+	routineCoordinator.Go("drone command loop", func(ctx context.Context) error {
 
 		for {
 
@@ -145,15 +278,84 @@ func (drone *Drone) Start(routineCoordinator *RoutineCoordinator, applicationSta
 				robotMux.Lock()
 
 				switch command.CommandType {
-				case "takeoff":
-					drone.driver.TakeOff()
+				case "takeoff", DRONE_ACTION_THROW_TAKE_OFF:
+					if err := drone.safetyEnvelope.CanTakeOff(); err != nil {
+						applog.Warn("Rejects %v. %v", command.CommandType, err)
+						break
+					}
+					if err := drone.batteryPolicy.CanTakeOff(); err != nil {
+						applog.Warn("Rejects %v. %v", command.CommandType, err)
+						break
+					}
+					if err := drone.flightState.Accept(command); err != nil {
+						applog.Warn("Rejects %v. %v", command.CommandType, err)
+						break
+					}
+					drone.safetyEnvelope.ResetOrigin()
+					drone.batteryPolicy.Reset()
+					if command.CommandType == DRONE_ACTION_THROW_TAKE_OFF {
+						drone.controller.ThrowTakeOff()
+					} else {
+						drone.controller.TakeOff()
+					}
 				case "land":
-					drone.driver.Land()
+					drone.flightState.Accept(command)
+					drone.controller.Land()
+				case DRONE_ACTION_PALM_LAND:
+					drone.flightState.Accept(command)
+					drone.controller.PalmLand()
+				case DRONE_ACTION_FLIP, DRONE_ACTION_BOUNCE, DRONE_ACTION_ROTATE:
+					if drone.batteryPolicy.IsForcedLanding() {
+						break
+					}
+					if err := drone.flightState.Accept(command); err != nil {
+						applog.Warn("Rejects %v. %v", command.CommandType, err)
+						break
+					}
+					switch command.CommandType {
+					case DRONE_ACTION_FLIP:
+						drone.controller.Flip(command.Command.(string))
+					case DRONE_ACTION_BOUNCE:
+						drone.controller.Bounce()
+					case DRONE_ACTION_ROTATE:
+						drone.controller.Rotate(command.Command.(int))
+					}
+				case "takePicture":
+					if err := drone.controller.TakePicture(); err != nil {
+						applog.Warn("Fails to take a picture. %v", err)
+					}
+				case "ceaseRotation":
+					drone.controller.CeaseRotation()
+				case DRONE_ACTION_HOVER:
+					drone.controller.Hover()
+				case DRONE_ACTION_FAST_MODE:
+					drone.controller.SetFastMode(true)
+				case DRONE_ACTION_SLOW_MODE:
+					drone.controller.SetFastMode(false)
+				case "emergency":
+					applog.Warn("Stops the motors in an emergency. Requested by %v.", command.Command)
+					drone.flightState.Accept(command)
+					if err := drone.controller.Emergency(); err != nil {
+						applog.Warn("Fails to stop the motors. %v", err)
+					}
 				case "vector":
-					mVec := command.Command.(MotionVector)
-					drone.safetySignal.ConsumeSignal(mVec, drone)
-					drone.driver.SetVector(mVec.Y, mVec.X, mVec.Z, mVec.R)
+					if drone.batteryPolicy.IsForcedLanding() {
+						break
+					}
+					if err := drone.flightState.Accept(command); err != nil {
+						applog.Debug("Rejects vector. %v", err)
+						break
+					}
+					mVec := drone.safetyEnvelope.Filter(command.Command.(MotionVector))
+					drone.safetySignal.ConsumeSignal(mVec, routineCoordinator)
+					drone.controller.SetVector(mVec)
+				case "startVideoRecording":
+					drone.startVideoRecording(applicationStates)
+				case "stopVideoRecording":
+					drone.stopVideoRecording(routineCoordinator, applicationStates)
 				}
+				// Recorded after the command is accepted, so that the takeoff goes to the file the takeoff rotates to.
+				recorder.Record(flightrecorder.RECORD_TYPE_COMMAND, command)
 
 				robotMux.Unlock()
 
@@ -164,7 +366,7 @@ func (drone *Drone) Start(routineCoordinator *RoutineCoordinator, applicationSta
 				switch _pkt := pkt.(type) {
 				case *rtcp.PictureLossIndication:
 					applog.Debug("Receives RTCP PictureLossIndication. %v", _pkt)
-					drone.driver.StartVideo()
+					drone.controller.StartVideo()
 
 				case *rtcp.ReceiverEstimatedMaximumBitrate:
 					applog.Debug("Receives RTCP ReceiverEstimatedMaximumBitrate. %v", _pkt)
@@ -173,73 +375,64 @@ func (drone *Drone) Start(routineCoordinator *RoutineCoordinator, applicationSta
 					// Using the bitrate(MB) value corresponding to the one that 'rtcp.Receiver Estimated Maximum Bitrate.String()' shows.
 					// Reference: github.com/pion/rtcp receiver_estimated_maximum_bitrate.go
 					bitrateMB := bitrate / 1000.0 / 1000.0 // :MB
-					var changeTo float64
-
-					switch {
-					case bitrateMB >= 4.0:
-						drone.driver.SetVideoEncoderRate(tello.VideoBitRate4M)
-						changeTo = 4.0
-					case bitrateMB >= 3.0:
-						drone.driver.SetVideoEncoderRate(tello.VideoBitRate3M)
-						changeTo = 3.0
-					case bitrateMB >= 2.0:
-						drone.driver.SetVideoEncoderRate(tello.VideoBitRate2M)
-						changeTo = 2.0
-					case bitrateMB >= 1.5:
-						drone.driver.SetVideoEncoderRate(tello.VideoBitRate15M)
-						changeTo = 1.5
-					default:
-						drone.driver.SetVideoEncoderRate(tello.VideoBitRate1M)
-						changeTo = 1
-					}
+					changeTo, _ := drone.controller.SetVideoBitRate(bitrateMB)
+					recorder.Record(flightrecorder.RECORD_TYPE_BITRATE, flightrecorder.BitrateChange{
+						Requested: bitrateMB,
+						Applied:   changeTo,
+					})
 					applog.Debug("ReceiverEstimation = %.2f Mb/s. The bit rate changes to %v Mb/s", bitrateMB, changeTo)
 				}
 
 				robotMux.Unlock()
 
-			case <-routineCoordinator.StopSignalChannel:
+			case <-ctx.Done():
 				applog.Info("Stop drone event loop.")
 
 				robotMux.Lock()
 
-				robot.Stop()
+				controller.Disconnect()
 
 				robotMux.Unlock()
-				return
+				return nil
 			}
 
 		}
-	}()
+	})
 
-	checkerFunc := func() {
-
-		defer routineCoordinator.DoneWaitGroupUntilReleasingSocket()
+	checkerFunc := func(ctx context.Context) error {
 
 		for {
 			select {
-			case <-routineCoordinator.StopSignalChannel:
+			case <-ctx.Done():
 				applog.Info("Stop drone health check loop.")
 
 				applicationStates.SetDroneHealths(DroneHealths{
 					DroneHealth:  DRONE_HEALTH_UNKNOWN,
-					BatteryLevel: latestBatteryLevel,
+					BatteryLevel: int(atomic.LoadInt32(&latestBatteryLevel)),
 				})
+				applicationStates.SetTelemetry(Telemetry{})
+				applicationStates.SetBatteryWarning(BATTERY_WARNING_NONE)
 				robotMux.Lock()
 
-				robot.Stop()
+				controller.Disconnect()
+				recorder.RecordConnection("drone", "disconnected")
+				drone.flightState.OnDisconnected()
+				recorder.Close()
 
 				robotMux.Unlock()
+
+				drone.stopVideoRecording(routineCoordinator, applicationStates)
 				applog.Info("End stopping robot.")
-				return
+				return nil
 			default:
 
 				ok := true
-				if time.Since(lastTimestampVideoReceived).Seconds() > 5 {
+				if sinceLastReceived(&lastTimestampVideoReceived).Seconds() > 5 {
 					applog.Warn("Drone fails to receive video stream.")
 					ok = false
 				}
 
-				if time.Since(lastTimestampFightDataReceived).Seconds() > 5 {
+				if sinceLastReceived(&lastTimestampFightDataReceived).Seconds() > 5 {
 					applog.Warn("Drone fails to receive flight data.")
 					ok = false
 				}
@@ -248,7 +441,7 @@ func (drone *Drone) Start(routineCoordinator *RoutineCoordinator, applicationSta
 
 					applicationStates.SetDroneHealths(DroneHealths{
 						DroneHealth:  DRONE_HEALTH_OK,
-						BatteryLevel: latestBatteryLevel,
+						BatteryLevel: int(atomic.LoadInt32(&latestBatteryLevel)),
 					})
 
 					applog.Debug("Drone is successfully receiving data.")
@@ -256,12 +449,14 @@ func (drone *Drone) Start(routineCoordinator *RoutineCoordinator, applicationSta
 
 					applicationStates.SetDroneHealths(DroneHealths{
 						DroneHealth:  DRONE_HEALTH_NG,
-						BatteryLevel: latestBatteryLevel,
+						BatteryLevel: int(atomic.LoadInt32(&latestBatteryLevel)),
 					})
 
 					robotMux.Lock()
 
-					robot.Stop()
+					controller.Disconnect()
+					recorder.RecordConnection("drone", "lost")
+					drone.flightState.OnDisconnected()
 
 					robotMux.Unlock()
 
@@ -270,6 +465,11 @@ func (drone *Drone) Start(routineCoordinator *RoutineCoordinator, applicationSta
 					time.Sleep(1 * time.Second)
 				}
 
+				// Waits for the command loop so that the drone is not regarded as alive while the loop is stuck holding the lock.
+				robotMux.Lock()
+				robotMux.Unlock()
+				drone.checkedAt.Store(time.Now())
+
 				time.Sleep(1 * time.Second)
 			}
 		}
@@ -277,14 +477,14 @@ func (drone *Drone) Start(routineCoordinator *RoutineCoordinator, applicationSta
 
 	startRobot()
 
-	routineCoordinator.AddWaitGroupUntilReleasingSocket()
-	go checkerFunc()
+	routineCoordinator.Go("drone health check", checkerFunc)
 
-	applog.Info("Drone starts.")
+	applog.Info("Drone '%v' starts.", drone.id)
 }
 
 // In case of losing a stop signal (i.e '{ x: 0, y: 0 }' or '{ r: 0, z: 0 }') for some reason,
-// if no signal is received during 500ms, a stop signal is set automatically
+// if no signal is received during 500ms, a stop signal is set automatically.
+// The stop signal is sent through the command loop, which owns the controller.
 type SafetySignal struct {
 	isStarted             bool
 	endChannel            chan struct{}
@@ -296,51 +496,71 @@ func NewSafetySignal() SafetySignal {
 	return SafetySignal{}
 }
 
-func (s *SafetySignal) ConsumeSignal(mVec MotionVector, drone *Drone) {
+func (s *SafetySignal) ConsumeSignal(mVec MotionVector, routineCoordinator *RoutineCoordinator) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	s.startChecking(drone)
-
 	if mVec.isZeroVector() {
 		s.endChecking()
 		return
 	}
+	s.startChecking(routineCoordinator)
 	s.lastAccessedTimestamp = time.Now()
 }
 
-func (s *SafetySignal) startChecking(drone *Drone) {
+func (s *SafetySignal) startChecking(routineCoordinator *RoutineCoordinator) {
 	if s.isStarted {
 		return
 	}
-	s.endChannel = make(chan struct{})
+	endChannel := make(chan struct{})
+	s.endChannel = endChannel
 	s.lastAccessedTimestamp = time.Now()
 	s.isStarted = true
-	go func() {
+
+	started := routineCoordinator.Go("safety signal check", func(ctx context.Context) error {
 
 		ticker := time.NewTicker(100 * time.Millisecond)
 		defer ticker.Stop()
 
 		for {
 			select {
-			case <-s.endChannel:
-				return
+			case <-ctx.Done():
+				return nil
+			case <-endChannel:
+				return nil
 			case <-ticker.C:
-				if 500 < time.Since(s.lastAccessedTimestamp).Milliseconds() {
-					s.mutex.Lock()
-					defer s.mutex.Unlock()
-
+				if s.isLost(endChannel) {
 					applog.Info("Set a zero translation vector because of losing a stop signal.")
-					drone.driver.SetVector(0, 0, 0, 0)
-					s.endChecking()
-					return
+					routineCoordinator.SendDroneCommandChannel(DroneCommand{
+						CommandType: "vector",
+						Command:     MotionVector{},
+					})
+					return nil
 				}
 			}
 		}
-	}()
+	})
+	if !started {
+		s.isStarted = false
+	}
+}
+
+// Ends the check started with endChannel if no signal is received during 500ms.
+func (s *SafetySignal) isLost(endChannel chan struct{}) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.isStarted || s.endChannel != endChannel || time.Since(s.lastAccessedTimestamp).Milliseconds() <= 500 {
+		return false
+	}
+	s.endChecking()
+	return true
 }
 
 func (s *SafetySignal) endChecking() {
+	if !s.isStarted {
+		return
+	}
 	s.isStarted = false
 	s.lastAccessedTimestamp = time.Now()
 	close(s.endChannel)
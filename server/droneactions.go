@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	DRONE_ACTION_FLIP           = "flip"
+	DRONE_ACTION_BOUNCE         = "bounce"
+	DRONE_ACTION_PALM_LAND      = "palmLand"
+	DRONE_ACTION_THROW_TAKE_OFF = "throwTakeOff"
+	DRONE_ACTION_HOVER          = "hover"
+	DRONE_ACTION_FAST_MODE      = "fastMode"
+	DRONE_ACTION_SLOW_MODE      = "slowMode"
+	DRONE_ACTION_ROTATE         = "rotate"
+)
+
+const (
+	FLIP_DIRECTION_FRONT = "front"
+	FLIP_DIRECTION_BACK  = "back"
+	FLIP_DIRECTION_LEFT  = "left"
+	FLIP_DIRECTION_RIGHT = "right"
+)
+
+var DRONE_ACTION_TYPES = []string{
+	DRONE_ACTION_FLIP,
+	DRONE_ACTION_BOUNCE,
+	DRONE_ACTION_PALM_LAND,
+	DRONE_ACTION_THROW_TAKE_OFF,
+	DRONE_ACTION_HOVER,
+	DRONE_ACTION_FAST_MODE,
+	DRONE_ACTION_SLOW_MODE,
+	DRONE_ACTION_ROTATE,
+}
+
+// An action other than takeoff, land and motion vectors.
+// It is sent as '{ "action": { ... } }' over the DataChannel or as the body of '/cgi/<type>'.
+type DroneAction struct {
+	Type string `json:"type"`
+	// One of 'front', 'back', 'left' and 'right'. Only for 'flip'.
+	Direction string `json:"direction,omitempty"`
+	// Positive for clockwise, negative for counter-clockwise. Only for 'rotate'.
+	Degrees int `json:"degrees,omitempty"`
+}
+
+// Validates the action and converts it to the command the Drone command loop handles.
+func (a *DroneAction) ToDroneCommand() (DroneCommand, error) {
+	switch a.Type {
+	case DRONE_ACTION_FLIP:
+		switch a.Direction {
+		case FLIP_DIRECTION_FRONT, FLIP_DIRECTION_BACK, FLIP_DIRECTION_LEFT, FLIP_DIRECTION_RIGHT:
+			return DroneCommand{CommandType: a.Type, Command: a.Direction}, nil
+		}
+		return DroneCommand{}, fmt.Errorf("invalid flip direction '%v'", a.Direction)
+	case DRONE_ACTION_ROTATE:
+		if a.Degrees == 0 || 360 < a.Degrees || a.Degrees < -360 {
+			return DroneCommand{}, fmt.Errorf("invalid rotation degrees %v", a.Degrees)
+		}
+		return DroneCommand{CommandType: a.Type, Command: a.Degrees}, nil
+	case DRONE_ACTION_BOUNCE, DRONE_ACTION_PALM_LAND, DRONE_ACTION_THROW_TAKE_OFF,
+		DRONE_ACTION_HOVER, DRONE_ACTION_FAST_MODE, DRONE_ACTION_SLOW_MODE:
+		return DroneCommand{CommandType: a.Type}, nil
+	}
+	return DroneCommand{}, fmt.Errorf("unknown action type '%v'", a.Type)
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/st-user/ojm-drone-local/applog"
+	"github.com/st-user/ojm-drone-local/env"
+	"github.com/st-user/ojm-drone-local/flightrecorder"
+)
+
+const DEFAULT_DRONE_ID = "default"
+
+const (
+	defaultTelloHost      = "192.168.10.1"
+	defaultTelloLocalPort = "8888"
+)
+
+var droneIdPattern = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+
+var droneFleet *DroneFleet
+
+// The address of a drone configured by 'DRONES'.
+type DroneConfig struct {
+	Id string `json:"id"`
+	// The address of the drone. In station mode, each Tello has the address the router assigns.
+	Host string `json:"host"`
+	// The local UDP port the driver receives the responses of the drone on. It has to differ between drones.
+	LocalPort string `json:"localPort"`
+}
+
+// Parses 'DRONES', which is a comma separated list of '<id>@<host>:<local port>'.
+// If it is empty, the single drone in AP mode is used as before.
+func ParseDroneConfigs(value string) ([]DroneConfig, error) {
+	if strings.TrimSpace(value) == "" {
+		return []DroneConfig{
+			{
+				Id:        DEFAULT_DRONE_ID,
+				Host:      defaultTelloHost,
+				LocalPort: defaultTelloLocalPort,
+			},
+		}, nil
+	}
+
+	configs := []DroneConfig{}
+	ids := make(map[string]bool)
+	localPorts := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		idAndAddress := strings.SplitN(entry, "@", 2)
+		if len(idAndAddress) != 2 {
+			return nil, fmt.Errorf("invalid drone '%v'. It has to be '<id>@<host>:<local port>'", entry)
+		}
+		hostAndPort := strings.SplitN(idAndAddress[1], ":", 2)
+		if len(hostAndPort) != 2 || hostAndPort[0] == "" || hostAndPort[1] == "" {
+			return nil, fmt.Errorf("invalid drone '%v'. It has to be '<id>@<host>:<local port>'", entry)
+		}
+
+		config := DroneConfig{
+			Id:        idAndAddress[0],
+			Host:      hostAndPort[0],
+			LocalPort: hostAndPort[1],
+		}
+		if !droneIdPattern.MatchString(config.Id) {
+			return nil, fmt.Errorf("invalid drone id '%v'", config.Id)
+		}
+		if ids[config.Id] {
+			return nil, fmt.Errorf("drone id '%v' is duplicated", config.Id)
+		}
+		if localPorts[config.LocalPort] {
+			return nil, fmt.Errorf("local port %v is duplicated", config.LocalPort)
+		}
+		ids[config.Id] = true
+		localPorts[config.LocalPort] = true
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+// DroneUnit bundles what runs for each drone: its own RoutineCoordinator, drone-related states,
+// primary peer and audiences, failsafe, mission runner and video snapshot.
+// The RTCHandler and the Drone are recreated when the unit restarts. The others outlive them.
+// The RTCHandler is also recreated alone when the primary peer reconnects, while the drone keeps flying.
+type DroneUnit struct {
+	Id                        string
+	config                    DroneConfig
+	routineCoordinator        *RoutineCoordinator
+	applicationStates         *ApplicationStates
+	linkFailsafe              *LinkFailsafe
+	missionRunner             *MissionRunner
+	emergencyStopConfirmation *EmergencyStopConfirmation
+	snapshot                  *flightrecorder.Snapshot
+	rtcHandler                *RTCHandler
+	drone                     *Drone
+	mutex                     sync.Mutex
+}
+
+func NewDroneUnit(config DroneConfig) *DroneUnit {
+	u := &DroneUnit{
+		Id:                        config.Id,
+		config:                    config,
+		routineCoordinator:        NewRoutineCoordinator(),
+		applicationStates:         NewApplicationStates(),
+		emergencyStopConfirmation: NewEmergencyStopConfirmation(),
+		snapshot:                  flightrecorder.NewSnapshot(),
+	}
+	u.linkFailsafe = NewLinkFailsafe(u.routineCoordinator, u.applicationStates)
+	u.missionRunner = NewMissionRunner(u.routineCoordinator, u.applicationStates)
+	return u
+}
+
+// Starts the drone and prepares the RTCHandler for its primary peer.
+// 'iceConfig' is applied to the RTCHandler if the signaling server has already sent it.
+func (u *DroneUnit) Start(iceConfig *webrtc.Configuration) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.routineCoordinator.Start()
+	u.linkFailsafe.Reset()
+
+	u.rtcHandler = u.newRTCHandler(iceConfig)
+
+	u.drone = NewDrone(u.Id, NewFlightControllerFactory(u.config), u.snapshot)
+	u.drone.Start(u.routineCoordinator, u.applicationStates)
+}
+
+// Replaces the RTCHandler with a new one for the reconnecting primary peer.
+// The drone, its controller and the link failsafe keep running, so the drone does not land.
+func (u *DroneUnit) RestartRTCHandler(iceConfig *webrtc.Configuration) {
+	u.mutex.Lock()
+	previous := u.rtcHandler
+	u.rtcHandler = u.newRTCHandler(iceConfig)
+	u.mutex.Unlock()
+
+	previous.Stop()
+}
+
+func (u *DroneUnit) newRTCHandler(iceConfig *webrtc.Configuration) *RTCHandler {
+	rtcHandler := NewRTCHandler(u.routineCoordinator.Context(), u.linkFailsafe, u.missionRunner, u.emergencyStopConfirmation)
+	if iceConfig != nil {
+		if err := rtcHandler.SetConfig(iceConfig); err != nil {
+			applog.Warn("Fails to configure the peer connection of drone '%v'. %v", u.Id, err)
+		}
+	}
+	u.applicationStates.SetPeerConnected(false)
+	return rtcHandler
+}
+
+func (u *DroneUnit) Stop() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.routineCoordinator.StopApp()
+}
+
+func (u *DroneUnit) RTCHandler() *RTCHandler {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.rtcHandler
+}
+
+// Returns false if the loops of the running drone are stuck. A stopped drone is regarded as alive.
+func (u *DroneUnit) IsAlive() bool {
+	drone := u.Drone()
+	return u.routineCoordinator.IsStopped() || drone == nil || drone.IsAlive()
+}
+
+func (u *DroneUnit) Drone() *Drone {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.drone
+}
+
+// The drone-related states published in 'appInfo'.
+func (u *DroneUnit) Status() map[string]interface{} {
+	states := u.applicationStates
+	return map[string]interface{}{
+		"droneId":        u.Id,
+		"flightState":    states.GetFlightState(),
+		"peerConnected":  states.IsPeerConnected(),
+		"videoRecording": states.IsVideoRecording(),
+		"batteryWarning": states.GetBatteryWarning(),
+		"linkState":      states.GetLinkState(),
+		"mission":        states.GetMissionStatus(),
+		"videoFrames":    u.routineCoordinator.DroneFrames.Stats(),
+		"droneHealth": map[string]int{
+			"health":       states.GetDroneHealth().DroneHealth,
+			"batteryLevel": states.GetDroneHealth().BatteryLevel,
+		},
+	}
+}
+
+// DroneFleet holds the drones this application flies in the order of 'DRONES'.
+// The first one is the default drone, which requests without a drone id are sent to.
+type DroneFleet struct {
+	units     []*DroneUnit
+	iceConfig *webrtc.Configuration
+	mutex     sync.Mutex
+}
+
+func NewDroneFleet(configs []DroneConfig) *DroneFleet {
+	f := &DroneFleet{}
+	for _, config := range configs {
+		f.units = append(f.units, NewDroneUnit(config))
+	}
+	return f
+}
+
+// Creates the fleet from 'DRONES'. Panics if it is invalid because the application cannot fly anything.
+func NewDroneFleetFromEnv() *DroneFleet {
+	configs, err := ParseDroneConfigs(env.Get("DRONES"))
+	if err != nil {
+		panic(err)
+	}
+	if IsSimulatorMode() && 1 < len(configs) {
+		panic(fmt.Errorf("DRONE_MODE '%v' supports only one drone", DRONE_MODE_SIM))
+	}
+	return NewDroneFleet(configs)
+}
+
+func (f *DroneFleet) Units() []*DroneUnit {
+	return f.units
+}
+
+func (f *DroneFleet) Default() *DroneUnit {
+	return f.units[0]
+}
+
+// Returns the drone with the id. An empty id means the default drone.
+func (f *DroneFleet) Get(id string) (*DroneUnit, error) {
+	if id == "" {
+		return f.Default(), nil
+	}
+	for _, u := range f.units {
+		if u.Id == id {
+			return u, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown drone id '%v'", id)
+}
+
+// Returns the drone specified by the 'droneId' query parameter.
+func (f *DroneFleet) FromRequest(r *http.Request) (*DroneUnit, error) {
+	return f.Get(r.URL.Query().Get("droneId"))
+}
+
+func (f *DroneFleet) Start() {
+	f.mutex.Lock()
+	f.iceConfig = nil
+	f.mutex.Unlock()
+
+	for _, u := range f.units {
+		u.Start(nil)
+	}
+}
+
+func (f *DroneFleet) Stop() {
+	for _, u := range f.units {
+		u.Stop()
+	}
+}
+
+func (f *DroneFleet) RestartRTCHandler(u *DroneUnit) {
+	u.RestartRTCHandler(f.ICEConfig())
+}
+
+// Applies the ICE servers the signaling server sends to all the drones.
+// It is kept so that a drone restarted later uses it too.
+func (f *DroneFleet) SetICEConfig(config *webrtc.Configuration) error {
+	f.mutex.Lock()
+	f.iceConfig = config
+	f.mutex.Unlock()
+
+	for _, u := range f.units {
+		if err := u.RTCHandler().SetConfig(config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *DroneFleet) ICEConfig() *webrtc.Configuration {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.iceConfig
+}
+
+func (f *DroneFleet) Statuses() []map[string]interface{} {
+	statuses := []map[string]interface{}{}
+	for _, u := range f.units {
+		statuses = append(statuses, u.Status())
+	}
+	return statuses
+}
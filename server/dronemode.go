@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/st-user/ojm-drone-local/applog"
+	"github.com/st-user/ojm-drone-local/env"
+	"github.com/st-user/ojm-drone-local/tello"
+	"github.com/st-user/ojm-drone-local/tellosim"
+)
+
+const (
+	DRONE_MODE_TELLO     = "tello"
+	DRONE_MODE_TELLO_SDK = "tello-sdk"
+	DRONE_MODE_SIM       = "sim"
+)
+
+const (
+	simulatorHost        = "127.0.0.1"
+	simulatorCommandPort = 8889
+)
+
+var telloSimulator *tellosim.Simulator
+
+func IsSimulatorMode() bool {
+	return strings.ToLower(env.Get("DRONE_MODE")) == DRONE_MODE_SIM
+}
+
+// Returns the factory of the FlightController corresponding to 'DRONE_MODE'.
+func NewFlightControllerFactory(config DroneConfig) FlightControllerFactory {
+	switch strings.ToLower(env.Get("DRONE_MODE")) {
+	case DRONE_MODE_SIM:
+		return NewSimulatedTelloFlightController
+	case DRONE_MODE_TELLO_SDK:
+		return NewTelloSDKFlightControllerFactory(config)
+	}
+	return NewTelloFlightControllerFactory(config)
+}
+
+// Connects gobot's Tello driver to the simulator instead of a physical drone.
+func NewSimulatedTelloFlightController() FlightController {
+	return NewTelloFlightControllerWithDriver(tello.NewDriverWithIP(simulatorHost, "8888"))
+}
+
+func StartSimulatorIfNeeded() {
+	if !IsSimulatorMode() {
+		return
+	}
+
+	drain, _ := strconv.ParseFloat(env.Get("DRONE_SIM_BATTERY_DRAIN_PER_SECOND"), 64)
+	telloSimulator = tellosim.NewSimulator(tellosim.Options{
+		CommandAddr:           simulatorHost + ":" + strconv.Itoa(simulatorCommandPort),
+		VideoHost:             simulatorHost,
+		VideoFile:             env.Get("DRONE_SIM_VIDEO_FILE"),
+		BatteryDrainPerSecond: drain,
+	})
+
+	if err := telloSimulator.Start(); err != nil {
+		panic(err)
+	}
+	applog.Warn("DRONE_MODE is '%v'. The application flies the simulated drone.", DRONE_MODE_SIM)
+}
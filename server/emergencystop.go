@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/st-user/ojm-drone-local/applog"
+)
+
+const (
+	EMERGENCY_STOP_SOURCE_HTTP         = "http"
+	EMERGENCY_STOP_SOURCE_PRIMARY_PEER = "primaryPeer"
+	EMERGENCY_STOP_SOURCE_CONSOLE      = "console"
+)
+
+// The second request has to arrive within this after the first one.
+const emergencyStopConfirmationWindow = 3 * time.Second
+
+// Used by the console, which stops all the drones. Each drone has its own one for HTTP and its primary peer.
+var emergencyStopConfirmation = NewEmergencyStopConfirmation()
+
+// EmergencyStopConfirmation makes sure that an emergency stop, which drops the drone, is not requested by accident.
+// The first request from a source arms it and the second request from the same source within the window confirms it.
+type EmergencyStopConfirmation struct {
+	armedAt map[string]time.Time
+	mutex   sync.Mutex
+}
+
+func NewEmergencyStopConfirmation() *EmergencyStopConfirmation {
+	return &EmergencyStopConfirmation{
+		armedAt: make(map[string]time.Time),
+	}
+}
+
+// Returns true if the request confirms the emergency stop. Otherwise, arms it.
+func (c *EmergencyStopConfirmation) Request(source string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	armedAt, ok := c.armedAt[source]
+	if ok && time.Since(armedAt) <= emergencyStopConfirmationWindow {
+		delete(c.armedAt, source)
+		applog.Warn("Emergency stop is confirmed by %v.", source)
+		return true
+	}
+
+	c.armedAt[source] = time.Now()
+	applog.Warn("Emergency stop is armed by %v. Request again within %v to stop the motors.", source, emergencyStopConfirmationWindow)
+	return false
+}
@@ -42,6 +42,14 @@ func GetBool(key string) bool {
 	return ret
 }
 
+func GetFloat(key string) float64 {
+	ret, err := strconv.ParseFloat(Get(key), 64)
+	if err != nil {
+		return 0
+	}
+	return ret
+}
+
 func GetDuration(key string) time.Duration {
 	ret, err := time.ParseDuration(Get(key))
 	if err != nil {
@@ -0,0 +1,316 @@
+// Package fakesignaling implements the signaling protocol of 'ojm-drone-remote' in-process
+// so that the local application can be exercised without the Node service.
+//
+// It can be used as a fixture:
+//
+//	fake := fakesignaling.NewServer(fakesignaling.Options{})
+//	ts := httptest.NewServer(fake)
+//	defer ts.Close()
+//	startKey := fake.IssueStartKey()
+//	// point SIGNALING_ENDPOINT to ts.URL and start the application with startKey
+//	session, _ := fake.WaitForSession(startKey, 5*time.Second)
+//	state, _ := session.CanOffer("primary-peer", true)
+//	// with several drones (DRONES), the peer selects one of them
+//	state, _ = session.CanOfferTo("tello-a", "primary-peer", true)
+//
+// or as a standalone server (see cmd/fakesignaling).
+package fakesignaling
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+type Options struct {
+	// Tokens '/validateAccessToken' and '/generateKey' accept. If empty, any non-empty token is accepted.
+	AccessTokens []string
+	// ICE servers sent in 'iceServerInfo' right after the local application connects.
+	ICEServers []ICEServer
+	// Interval of 'ping' messages. No ping is sent if it is zero.
+	PingInterval time.Duration
+	// Logger for the received/sent messages. Nothing is logged if nil.
+	Logf func(format string, v ...interface{})
+}
+
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+type SessionDescription struct {
+	Type string `json:"type"`
+	SDP  string `json:"sdp"`
+}
+
+// A signaling message. Both directions use JSON objects having 'messageType'.
+type Message map[string]interface{}
+
+func (m Message) MessageType() string {
+	t, _ := m["messageType"].(string)
+	return t
+}
+
+func (m Message) PeerConnectionId() string {
+	id, _ := m["peerConnectionId"].(string)
+	return id
+}
+
+// Empty if the message is not for a specific drone.
+func (m Message) DroneId() string {
+	id, _ := m["droneId"].(string)
+	return id
+}
+
+type Server struct {
+	options  Options
+	router   *mux.Router
+	upgrader websocket.Upgrader
+	mutex    sync.Mutex
+	// Start keys issued by '/generateKey' or registered manually.
+	startKeys map[string]bool
+	// Tickets are single-use and bound to a start key.
+	tickets map[string]string
+	// Sessions waiting to be taken by 'WaitForSession' per start key.
+	pendingSessions map[string]chan *Session
+	// The latest session per start key.
+	currentSessions map[string]*Session
+}
+
+func NewServer(options Options) *Server {
+	if options.Logf == nil {
+		options.Logf = func(format string, v ...interface{}) {}
+	}
+
+	s := &Server{
+		options:         options,
+		startKeys:       make(map[string]bool),
+		tickets:         make(map[string]string),
+		pendingSessions: make(map[string]chan *Session),
+		currentSessions: make(map[string]*Session),
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/validateAccessToken", s.validateAccessToken).Methods(http.MethodGet)
+	router.HandleFunc("/generateKey", s.generateKey).Methods(http.MethodGet)
+	router.HandleFunc("/ticket", s.ticket).Methods(http.MethodPost)
+	router.HandleFunc("/signaling", s.signaling)
+
+	control := router.PathPrefix("/fake").Subrouter()
+	control.HandleFunc("/canOffer", s.controlCanOffer).Methods(http.MethodPost)
+	control.HandleFunc("/close", s.controlClose).Methods(http.MethodPost)
+	control.HandleFunc("/disconnect", s.controlDisconnect).Methods(http.MethodPost)
+
+	s.router = router
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// Issues a start key in the same way as '/generateKey'.
+func (s *Server) IssueStartKey() string {
+	startKey := uuid.NewString()
+	s.RegisterStartKey(startKey)
+	return startKey
+}
+
+func (s *Server) RegisterStartKey(startKey string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.startKeys[startKey] = true
+	s.pendingSessionsOf(startKey)
+}
+
+// Waits for the local application to open the signaling connection with the start key.
+// Every reconnection creates a new session, so calling this again waits for the next one.
+func (s *Server) WaitForSession(startKey string, timeout time.Duration) (*Session, error) {
+	s.mutex.Lock()
+	sessions := s.pendingSessionsOf(startKey)
+	s.mutex.Unlock()
+
+	select {
+	case session := <-sessions:
+		return session, nil
+	case <-time.After(timeout):
+		return nil, errors.New("timed out waiting for a signaling session of " + startKey)
+	}
+}
+
+// Returns the latest session of the start key or nil.
+func (s *Server) CurrentSession(startKey string) *Session {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.currentSessions[startKey]
+}
+
+// Has to be called with the mutex locked.
+func (s *Server) pendingSessionsOf(startKey string) chan *Session {
+	sessions, ok := s.pendingSessions[startKey]
+	if !ok {
+		sessions = make(chan *Session, 16)
+		s.pendingSessions[startKey] = sessions
+	}
+	return sessions
+}
+
+func (s *Server) isValidToken(r *http.Request) bool {
+	token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "bearer "))
+	if token == "" {
+		return false
+	}
+	if len(s.options.AccessTokens) == 0 {
+		return true
+	}
+	for _, t := range s.options.AccessTokens {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) validateAccessToken(w http.ResponseWriter, r *http.Request) {
+	if !s.isValidToken(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, map[string]string{})
+}
+
+func (s *Server) generateKey(w http.ResponseWriter, r *http.Request) {
+	if !s.isValidToken(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	startKey := s.IssueStartKey()
+	s.options.Logf("Issues start key %v", startKey)
+	writeJSON(w, map[string]string{
+		"startKey": startKey,
+	})
+}
+
+func (s *Server) ticket(w http.ResponseWriter, r *http.Request) {
+	var body map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	startKey := body["startKey"]
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.startKeys[startKey] {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	ticket := uuid.NewString()
+	s.tickets[ticket] = startKey
+
+	writeJSON(w, map[string]string{
+		"ticket": ticket,
+	})
+}
+
+func (s *Server) signaling(w http.ResponseWriter, r *http.Request) {
+	ticket := r.URL.Query().Get("ticket")
+
+	s.mutex.Lock()
+	startKey, ok := s.tickets[ticket]
+	delete(s.tickets, ticket)
+	s.mutex.Unlock()
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.options.Logf("Fails to upgrade. %v", err)
+		return
+	}
+
+	session := newSession(startKey, conn, s.options)
+
+	s.mutex.Lock()
+	if previous, ok := s.currentSessions[startKey]; ok {
+		previous.Disconnect()
+	}
+	s.currentSessions[startKey] = session
+	sessions := s.pendingSessionsOf(startKey)
+	s.mutex.Unlock()
+
+	s.options.Logf("Local application is connected with start key %v", startKey)
+	session.start()
+
+	select {
+	case sessions <- session:
+	default:
+		s.options.Logf("Too many sessions are waiting for %v. The session is not queued.", startKey)
+	}
+}
+
+func (s *Server) sessionFromQuery(w http.ResponseWriter, r *http.Request) *Session {
+	session := s.CurrentSession(r.URL.Query().Get("startKey"))
+	if session == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	return session
+}
+
+func (s *Server) controlCanOffer(w http.ResponseWriter, r *http.Request) {
+	session := s.sessionFromQuery(w, r)
+	if session == nil {
+		return
+	}
+	query := r.URL.Query()
+	state, err := session.CanOfferTo(query.Get("droneId"), query.Get("peerConnectionId"), query.Get("isPrimary") == "true")
+	if err != nil {
+		w.WriteHeader(http.StatusGatewayTimeout)
+		return
+	}
+	writeJSON(w, map[string]string{
+		"state": state,
+	})
+}
+
+func (s *Server) controlClose(w http.ResponseWriter, r *http.Request) {
+	session := s.sessionFromQuery(w, r)
+	if session == nil {
+		return
+	}
+	query := r.URL.Query()
+	if err := session.CloseTo(query.Get("droneId"), query.Get("peerConnectionId"), query.Get("isPrimary") == "true"); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]string{})
+}
+
+func (s *Server) controlDisconnect(w http.ResponseWriter, r *http.Request) {
+	session := s.sessionFromQuery(w, r)
+	if session == nil {
+		return
+	}
+	session.Disconnect()
+	writeJSON(w, map[string]string{})
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
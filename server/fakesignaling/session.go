@@ -0,0 +1,269 @@
+package fakesignaling
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const defaultReplyTimeout = 10 * time.Second
+
+var ErrSessionClosed = errors.New("the signaling session is closed")
+
+// A signaling connection from the local application.
+// The methods play the role of the remote peers (i.e. browsers) and the remote server.
+type Session struct {
+	StartKey     string
+	conn         *websocket.Conn
+	options      Options
+	writeMutex   sync.Mutex
+	waiterMutex  sync.Mutex
+	waiters      map[string]chan Message
+	received     chan Message
+	pongCount    int
+	closedSignal chan struct{}
+	closeOnce    sync.Once
+}
+
+func newSession(startKey string, conn *websocket.Conn, options Options) *Session {
+	return &Session{
+		StartKey:     startKey,
+		conn:         conn,
+		options:      options,
+		waiters:      make(map[string]chan Message),
+		received:     make(chan Message, 64),
+		closedSignal: make(chan struct{}),
+	}
+}
+
+func (s *Session) start() {
+	go s.readLoop()
+
+	s.Send(Message{
+		"messageType": "iceServerInfo",
+		"iceServerInfo": map[string]interface{}{
+			"iceServers": s.options.ICEServers,
+		},
+	})
+
+	if s.options.PingInterval > 0 {
+		go s.pingLoop()
+	}
+}
+
+func (s *Session) readLoop() {
+	defer s.Disconnect()
+
+	for {
+		var message Message
+		if err := s.conn.ReadJSON(&message); err != nil {
+			s.options.Logf("Stops reading the signaling session. %v", err)
+			return
+		}
+		s.options.Logf("Receives %v", message)
+
+		switch message.MessageType() {
+		case "pong":
+			s.waiterMutex.Lock()
+			s.pongCount++
+			s.waiterMutex.Unlock()
+			continue
+		case "canOffer", "answer":
+			if s.notifyWaiter(message) {
+				continue
+			}
+		}
+
+		select {
+		case s.received <- message:
+		default:
+			// Nobody reads unsolicited messages. Drops the oldest.
+			select {
+			case <-s.received:
+			default:
+			}
+			s.received <- message
+		}
+	}
+}
+
+func (s *Session) pingLoop() {
+	ticker := time.NewTicker(s.options.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closedSignal:
+			return
+		case <-ticker.C:
+			if err := s.Send(Message{"messageType": "ping"}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func waiterKey(messageType string, droneId string, peerConnectionId string) string {
+	return messageType + "/" + droneId + "/" + peerConnectionId
+}
+
+func (s *Session) notifyWaiter(message Message) bool {
+	s.waiterMutex.Lock()
+	defer s.waiterMutex.Unlock()
+
+	key := waiterKey(message.MessageType(), message.DroneId(), message.PeerConnectionId())
+	waiter, ok := s.waiters[key]
+	if !ok {
+		return false
+	}
+	delete(s.waiters, key)
+	waiter <- message
+	return true
+}
+
+// Sends a message and waits for the reply of the message type to the peer.
+// The local application echoes 'droneId' back, so the reply is told apart by it too.
+func (s *Session) request(message Message, replyType string) (Message, error) {
+	waiter := make(chan Message, 1)
+	key := waiterKey(replyType, message.DroneId(), message.PeerConnectionId())
+
+	s.waiterMutex.Lock()
+	s.waiters[key] = waiter
+	s.waiterMutex.Unlock()
+
+	defer func() {
+		s.waiterMutex.Lock()
+		delete(s.waiters, key)
+		s.waiterMutex.Unlock()
+	}()
+
+	if err := s.Send(message); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-waiter:
+		return reply, nil
+	case <-s.closedSignal:
+		return nil, ErrSessionClosed
+	case <-time.After(defaultReplyTimeout):
+		return nil, errors.New("timed out waiting for " + replyType + " of " + message.PeerConnectionId())
+	}
+}
+
+func (s *Session) Send(message Message) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	select {
+	case <-s.closedSignal:
+		return ErrSessionClosed
+	default:
+	}
+
+	s.options.Logf("Sends %v", message)
+	return s.conn.WriteJSON(message)
+}
+
+// Adds 'droneId' to the message unless it is empty, in which case the local application selects the default drone.
+func withDroneId(message Message, droneId string) Message {
+	if droneId != "" {
+		message["droneId"] = droneId
+	}
+	return message
+}
+
+// Asks whether the peer can start a connection. Returns the state ('EMPTY', 'EXIST' or 'SAME') the local application answers.
+func (s *Session) CanOffer(peerConnectionId string, isPrimary bool) (string, error) {
+	return s.CanOfferTo("", peerConnectionId, isPrimary)
+}
+
+// CanOffer for the peer of the drone.
+func (s *Session) CanOfferTo(droneId string, peerConnectionId string, isPrimary bool) (string, error) {
+	reply, err := s.request(withDroneId(Message{
+		"messageType":      "canOffer",
+		"peerConnectionId": peerConnectionId,
+		"isPrimary":        isPrimary,
+	}, droneId), "canOffer")
+	if err != nil {
+		return "", err
+	}
+	state, _ := reply["state"].(string)
+	return state, nil
+}
+
+// Sends the peer's offer and returns the local application's answer.
+func (s *Session) Offer(peerConnectionId string, offer SessionDescription) (SessionDescription, error) {
+	return s.OfferTo("", peerConnectionId, offer)
+}
+
+// Offer for the peer of the drone.
+func (s *Session) OfferTo(droneId string, peerConnectionId string, offer SessionDescription) (SessionDescription, error) {
+	reply, err := s.request(withDroneId(Message{
+		"messageType":      "offer",
+		"peerConnectionId": peerConnectionId,
+		"offer":            offer,
+	}, droneId), "answer")
+	if err != nil {
+		return SessionDescription{}, err
+	}
+
+	if failed, _ := reply["err"].(bool); failed {
+		return SessionDescription{}, errors.New("the local application fails to answer")
+	}
+	answer, _ := reply["answer"].(map[string]interface{})
+	sdp, _ := answer["sdp"].(string)
+	sdpType, _ := answer["type"].(string)
+	return SessionDescription{
+		Type: sdpType,
+		SDP:  sdp,
+	}, nil
+}
+
+// Notifies that the peer has been closed.
+func (s *Session) Close(peerConnectionId string, isPrimary bool) error {
+	return s.CloseTo("", peerConnectionId, isPrimary)
+}
+
+// Close for the peer of the drone.
+func (s *Session) CloseTo(droneId string, peerConnectionId string, isPrimary bool) error {
+	return s.Send(withDroneId(Message{
+		"messageType":      "close",
+		"peerConnectionId": peerConnectionId,
+		"isPrimary":        isPrimary,
+	}, droneId))
+}
+
+// Waits for a message the local application sends without being asked.
+func (s *Session) Receive(timeout time.Duration) (Message, error) {
+	select {
+	case message := <-s.received:
+		return message, nil
+	case <-s.closedSignal:
+		return nil, ErrSessionClosed
+	case <-time.After(timeout):
+		return nil, errors.New("timed out waiting for a message")
+	}
+}
+
+// Number of 'pong' the local application has replied.
+func (s *Session) PongCount() int {
+	s.waiterMutex.Lock()
+	defer s.waiterMutex.Unlock()
+
+	return s.pongCount
+}
+
+// Drops the websocket connection like a network failure does.
+func (s *Session) Disconnect() {
+	s.closeOnce.Do(func() {
+		close(s.closedSignal)
+		s.conn.Close()
+	})
+}
+
+func (s *Session) Closed() <-chan struct{} {
+	return s.closedSignal
+}
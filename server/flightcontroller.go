@@ -0,0 +1,66 @@
+package main
+
+// FlightController abstracts the airframe the application flies.
+// Drone works against this interface so that the command loop, SafetySignal and the health checker
+// do not depend on a specific driver.
+//
+// Event handlers have to be registered before 'Connect' is called.
+// A FlightController is not reused after 'Disconnect'. A new one is created when the connection is restarted.
+type FlightController interface {
+	Connect() error
+	Disconnect() error
+	TakeOff() error
+	Land() error
+	// Stops the motors immediately. The drone falls.
+	Emergency() error
+	// Takes off when the drone is thrown.
+	ThrowTakeOff() error
+	// Lands on a palm under the drone.
+	PalmLand() error
+	// 'direction' is one of FLIP_DIRECTION_*.
+	Flip(direction string) error
+	// Toggles the bounce mode.
+	Bounce() error
+	Hover() error
+	// Positive for clockwise, negative for counter-clockwise.
+	// An airframe which cannot rotate by degrees keeps rotating until 'CeaseRotation' is called.
+	// The handler registered by 'OnRotationEnd' is notified when it should be called.
+	Rotate(degrees int) error
+	// Stops the rotation unless another rotation or a motion vector has replaced it.
+	CeaseRotation() error
+	SetFastMode(isFast bool) error
+	// Takes a picture. The JPEG is passed to the handler registered by 'OnPicture' when it is transferred.
+	TakePicture() error
+	SetVector(mVec MotionVector) error
+	StartVideo() error
+	// Changes the video bit rate to the nearest one the airframe supports
+	// and returns the bit rate(Mb/s) actually applied.
+	SetVideoBitRate(bitrateMB float64) (float64, error)
+	OnFlightData(handler func(flightData FlightData))
+	OnVideoFrame(handler func(data []byte))
+	OnPicture(handler func(data []byte))
+	OnRotationEnd(handler func())
+}
+
+// Flight data in units independent of airframes.
+type FlightData struct {
+	BatteryPercentage int     `json:"batteryPercentage"`
+	BatteryVoltage    float64 `json:"batteryVoltage"` // :V
+	BatteryLow        bool    `json:"batteryLow"`
+	Height            float64 `json:"height"`        // :m
+	GroundSpeed       float64 `json:"groundSpeed"`   // :m/s
+	VerticalSpeed     float64 `json:"verticalSpeed"` // :m/s
+	NorthSpeed        float64 `json:"northSpeed"`    // :m/s
+	EastSpeed         float64 `json:"eastSpeed"`     // :m/s
+	FlyMode           int     `json:"flyMode"`
+	Flying            bool    `json:"flying"`
+	OnGround          bool    `json:"onGround"`
+	WifiStrength      int     `json:"wifiStrength"`
+	TemperatureHigh   bool    `json:"temperatureHigh"`
+	ImuOk             bool    `json:"imuOk"`
+	WindWarning       bool    `json:"windWarning"`
+	LightWarning      bool    `json:"lightWarning"`
+	FlightTime        float64 `json:"flightTime"` // :s
+}
+
+type FlightControllerFactory func() FlightController
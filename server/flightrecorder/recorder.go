@@ -0,0 +1,192 @@
+package flightrecorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/st-user/ojm-drone-local/applog"
+)
+
+const (
+	RECORD_TYPE_FLIGHT_DATA = "flightData"
+	RECORD_TYPE_COMMAND     = "command"
+	RECORD_TYPE_BITRATE     = "bitrate"
+	RECORD_TYPE_CONNECTION  = "connection"
+	RECORD_TYPE_PHOTO       = "photo"
+)
+
+const (
+	fileBaseName  = "flight"
+	fileExtension = ".jsonl"
+)
+
+// A line of a flight record file.
+type Record struct {
+	// Unix time in milliseconds.
+	Timestamp int64           `json:"timestamp"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+}
+
+type BitrateChange struct {
+	Requested float64 `json:"requested"` // :Mb/s
+	Applied   float64 `json:"applied"`   // :Mb/s
+}
+
+type ConnectionEvent struct {
+	Target string `json:"target"`
+	State  string `json:"state"`
+}
+
+// Recorder writes what the drone reports and what the operator sends to a JSON Lines file per flight.
+// The file is rotated when the drone takes off and lands, so that a flight and the time on the ground between flights
+// are recorded to their own files.
+// All the methods can be called on a nil Recorder, in which case nothing is recorded.
+type Recorder struct {
+	droneId string
+	file    *os.File
+	encoder *json.Encoder
+	mutex   sync.Mutex
+}
+
+// Creates a new flight record file of the drone in the log directory and removes the ones older than the log retention.
+func NewRecorder(droneId string) (*Recorder, error) {
+	file, err := createRecordFile(droneId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recorder{
+		droneId: droneId,
+		file:    file,
+		encoder: json.NewEncoder(file),
+	}, nil
+}
+
+// Continues recording to a new file. Does nothing if the recorder has been closed.
+// If the new file cannot be created, the current file is kept.
+func (r *Recorder) Rotate() {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.file == nil {
+		return
+	}
+	file, err := createRecordFile(r.droneId)
+	if err != nil {
+		applog.Warn("Fails to rotate the flight record file. %v", err)
+		return
+	}
+	if err := r.file.Close(); err != nil {
+		applog.Warn("Fails to close the flight record file. %v", err)
+	}
+	r.file = file
+	r.encoder = json.NewEncoder(file)
+}
+
+func (r *Recorder) Record(recordType string, data interface{}) {
+	if r == nil {
+		return
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		applog.Warn("Fails to marshal a flight record. %v", err)
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.file == nil {
+		return
+	}
+	if err := r.encoder.Encode(Record{
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		Type:      recordType,
+		Data:      b,
+	}); err != nil {
+		applog.Warn("Fails to write a flight record. %v", err)
+	}
+}
+
+func (r *Recorder) RecordConnection(target string, state string) {
+	r.Record(RECORD_TYPE_CONNECTION, ConnectionEvent{
+		Target: target,
+		State:  state,
+	})
+}
+
+func (r *Recorder) Close() {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.file == nil {
+		return
+	}
+	if err := r.file.Close(); err != nil {
+		applog.Warn("Fails to close the flight record file. %v", err)
+	}
+	r.file = nil
+}
+
+func createRecordFile(droneId string) (*os.File, error) {
+	dir := applog.OutputDir()
+	if err := os.MkdirAll(dir, 0744); err != nil {
+		return nil, err
+	}
+	deleteFilesWith(dir, fileBaseName, fileExtension)
+
+	// Appended if the drone takes off and lands within a second, in which case the file name is the same.
+	path := filepath.Join(dir, createFilename(fileBaseName, droneId, time.Now(), fileExtension))
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	applog.Info("Flight records are written to %v", path)
+	return file, nil
+}
+
+// The drone id is included so that the files of drones started at the same time do not collide.
+func createFilename(baseName string, droneId string, t time.Time, extension string) string {
+	return fmt.Sprintf("%v-%v-%v%v", baseName, t.Format("2006-01-02-150405"), droneId, extension)
+}
+
+// Removes the files named '<baseName>-*<extension>' older than the log retention.
+func deleteFilesWith(dir string, baseName string, extension string) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		applog.Warn("Fails to read %v. %v", dir, err)
+		return
+	}
+	deleteAfter := time.Now().AddDate(0, 0, -applog.DaysToReserve())
+
+	for _, f := range files {
+		filename := f.Name()
+		if !strings.HasPrefix(filename, baseName+"-") || !strings.HasSuffix(filename, extension) {
+			continue
+		}
+
+		if deleteAfter.After(f.ModTime()) {
+			if err := os.Remove(filepath.Join(dir, filename)); err != nil {
+				applog.Warn("Failed to remove %v", filename)
+			} else {
+				applog.Info("Removes %v", filename)
+			}
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package flightrecorder
+
+import (
+	"sync"
+	"time"
+)
+
+// Snapshot keeps the latest key frame (SPS, PPS and IDR) of the live H.264 stream.
+// No H.264 decoder is bundled, so the key frame is provided as a single-frame Annex-B .h264 file,
+// which common players and 'ffmpeg -i snapshot.h264 snapshot.jpg' can decode.
+type Snapshot struct {
+	data      []byte
+	timestamp int64
+	mutex     sync.Mutex
+}
+
+func NewSnapshot() *Snapshot {
+	return &Snapshot{}
+}
+
+// Keeps the access unit if it is a key frame. The data is copied.
+func (s *Snapshot) Update(data []byte) {
+	if !IsKeyFrame(data) {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.data = append(s.data[:0], data...)
+	s.timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// Returns a copy of the latest key frame and the unix time in milliseconds when it is received.
+// Returns false if there is no key frame received within maxAge.
+func (s *Snapshot) Get(maxAge time.Duration) ([]byte, int64, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.data) == 0 {
+		return nil, 0, false
+	}
+	if time.Since(time.Unix(0, s.timestamp*int64(time.Millisecond))) > maxAge {
+		return nil, 0, false
+	}
+	return append([]byte(nil), s.data...), s.timestamp, true
+}
@@ -0,0 +1,181 @@
+package flightrecorder
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/st-user/ojm-drone-local/applog"
+)
+
+const (
+	videoFileBaseName       = "video"
+	videoFileExtension      = ".h264"
+	videoIndexFileExtension = ".idx.jsonl"
+	videoFrameBufferSize    = 256
+)
+
+// A line of a video index file. Locates an access unit in the raw H.264 file.
+type VideoIndex struct {
+	// Unix time in milliseconds when the frame is received.
+	Timestamp int64 `json:"timestamp"`
+	Offset    int64 `json:"offset"`
+	Size      int   `json:"size"`
+	KeyFrame  bool  `json:"keyFrame"`
+}
+
+type videoFrame struct {
+	data      []byte
+	timestamp int64
+}
+
+// VideoRecorder writes the H.264 access units (Annex-B) the drone sends to a raw .h264 file
+// and their positions to an index file.
+// Frames are written by a dedicated goroutine so that slow disk I/O does not block the live stream.
+// When the buffer is full, frames are dropped until the next key frame.
+type VideoRecorder struct {
+	frameChannel      chan videoFrame
+	done              chan struct{}
+	isClosed          bool
+	isWaitingKeyFrame bool
+	droppedFrameCount int
+	mutex             sync.Mutex
+}
+
+func NewVideoRecorder(droneId string) (*VideoRecorder, error) {
+	dir := applog.OutputDir()
+	if err := os.MkdirAll(dir, 0744); err != nil {
+		return nil, err
+	}
+	deleteFilesWith(dir, videoFileBaseName, videoFileExtension)
+	deleteFilesWith(dir, videoFileBaseName, videoIndexFileExtension)
+
+	now := time.Now()
+	videoPath := filepath.Join(dir, createFilename(videoFileBaseName, droneId, now, videoFileExtension))
+	videoFile, err := os.OpenFile(videoPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	indexFile, err := os.OpenFile(filepath.Join(dir, createFilename(videoFileBaseName, droneId, now, videoIndexFileExtension)), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		videoFile.Close()
+		return nil, err
+	}
+	applog.Info("Video is recorded to %v", videoPath)
+
+	v := &VideoRecorder{
+		frameChannel: make(chan videoFrame, videoFrameBufferSize),
+		done:         make(chan struct{}),
+		// Starts from a key frame so that the file can be decoded from the beginning.
+		isWaitingKeyFrame: true,
+	}
+	go v.write(videoFile, indexFile)
+
+	return v, nil
+}
+
+// Queues an access unit without blocking. The data is copied.
+func (v *VideoRecorder) WriteFrame(data []byte) {
+	if v == nil {
+		return
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.isClosed {
+		return
+	}
+
+	if v.isWaitingKeyFrame {
+		if !IsKeyFrame(data) {
+			return
+		}
+		if 0 < v.droppedFrameCount {
+			applog.Warn("Drops %v video frames because writing the video is slow.", v.droppedFrameCount)
+			v.droppedFrameCount = 0
+		}
+		v.isWaitingKeyFrame = false
+	}
+
+	frame := videoFrame{
+		data:      append([]byte(nil), data...),
+		timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+	}
+
+	select {
+	case v.frameChannel <- frame:
+	default:
+		v.isWaitingKeyFrame = true
+		v.droppedFrameCount++
+	}
+}
+
+// Stops recording and waits until the queued frames are written.
+func (v *VideoRecorder) Close() {
+	if v == nil {
+		return
+	}
+
+	v.mutex.Lock()
+	if v.isClosed {
+		v.mutex.Unlock()
+		return
+	}
+	v.isClosed = true
+	close(v.frameChannel)
+	v.mutex.Unlock()
+
+	<-v.done
+}
+
+func (v *VideoRecorder) write(videoFile *os.File, indexFile *os.File) {
+	defer close(v.done)
+	defer videoFile.Close()
+	defer indexFile.Close()
+
+	videoWriter := bufio.NewWriter(videoFile)
+	indexWriter := bufio.NewWriter(indexFile)
+	indexEncoder := json.NewEncoder(indexWriter)
+
+	var offset int64
+	hasError := false
+	for frame := range v.frameChannel {
+		if hasError {
+			continue
+		}
+
+		if _, err := videoWriter.Write(frame.data); err != nil {
+			applog.Warn("Fails to write the video. Stops recording. %v", err)
+			hasError = true
+			continue
+		}
+		if err := indexEncoder.Encode(VideoIndex{
+			Timestamp: frame.timestamp,
+			Offset:    offset,
+			Size:      len(frame.data),
+			KeyFrame:  IsKeyFrame(frame.data),
+		}); err != nil {
+			applog.Warn("Fails to write the video index. Stops recording. %v", err)
+			hasError = true
+			continue
+		}
+		offset += int64(len(frame.data))
+	}
+
+	if err := videoWriter.Flush(); err != nil {
+		applog.Warn("Fails to write the video. %v", err)
+	}
+	if err := indexWriter.Flush(); err != nil {
+		applog.Warn("Fails to write the video index. %v", err)
+	}
+	applog.Info("Video recording ends.")
+}
+
+// An access unit starting with SPS(NAL unit type 7) is regarded as a key frame.
+func IsKeyFrame(data []byte) bool {
+	return len(data) > 4 && data[4]&0b11111 == 7
+}
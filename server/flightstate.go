@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/st-user/ojm-drone-local/applog"
+)
+
+type FlightState int
+
+const (
+	FLIGHT_STATE_DISCONNECTED FlightState = 0
+	FLIGHT_STATE_CONNECTED    FlightState = 1
+	FLIGHT_STATE_LANDED       FlightState = 2
+	FLIGHT_STATE_TAKING_OFF   FlightState = 3
+	FLIGHT_STATE_FLYING       FlightState = 4
+	FLIGHT_STATE_LANDING      FlightState = 5
+	FLIGHT_STATE_EMERGENCY    FlightState = 6
+	FLIGHT_STATE_FAILSAFE     FlightState = 7
+)
+
+const (
+	// The height the drone is regarded as having taken off.
+	takeOffCompletedHeight = 0.5 // :m
+	// If the drone is still on the ground after this, the takeoff is regarded as failed.
+	takeOffTimeout = 10 * time.Second
+)
+
+var flightStateNames = map[FlightState]string{
+	FLIGHT_STATE_DISCONNECTED: "disconnected",
+	FLIGHT_STATE_CONNECTED:    "connected",
+	FLIGHT_STATE_LANDED:       "landed",
+	FLIGHT_STATE_TAKING_OFF:   "takingOff",
+	FLIGHT_STATE_FLYING:       "flying",
+	FLIGHT_STATE_LANDING:      "landing",
+	FLIGHT_STATE_EMERGENCY:    "emergency",
+	FLIGHT_STATE_FAILSAFE:     "failsafe",
+}
+
+var validFlightStateTransitions = map[FlightState][]FlightState{
+	FLIGHT_STATE_DISCONNECTED: {FLIGHT_STATE_CONNECTED},
+	FLIGHT_STATE_CONNECTED:    {FLIGHT_STATE_DISCONNECTED, FLIGHT_STATE_LANDED, FLIGHT_STATE_FLYING, FLIGHT_STATE_EMERGENCY, FLIGHT_STATE_FAILSAFE},
+	FLIGHT_STATE_LANDED:       {FLIGHT_STATE_DISCONNECTED, FLIGHT_STATE_TAKING_OFF, FLIGHT_STATE_FLYING},
+	FLIGHT_STATE_TAKING_OFF:   {FLIGHT_STATE_DISCONNECTED, FLIGHT_STATE_LANDED, FLIGHT_STATE_FLYING, FLIGHT_STATE_LANDING, FLIGHT_STATE_EMERGENCY, FLIGHT_STATE_FAILSAFE},
+	FLIGHT_STATE_FLYING:       {FLIGHT_STATE_DISCONNECTED, FLIGHT_STATE_LANDED, FLIGHT_STATE_LANDING, FLIGHT_STATE_EMERGENCY, FLIGHT_STATE_FAILSAFE},
+	FLIGHT_STATE_LANDING:      {FLIGHT_STATE_DISCONNECTED, FLIGHT_STATE_LANDED, FLIGHT_STATE_FLYING, FLIGHT_STATE_EMERGENCY},
+	FLIGHT_STATE_EMERGENCY:    {FLIGHT_STATE_DISCONNECTED, FLIGHT_STATE_LANDED, FLIGHT_STATE_LANDING, FLIGHT_STATE_FLYING},
+	FLIGHT_STATE_FAILSAFE:     {FLIGHT_STATE_DISCONNECTED, FLIGHT_STATE_LANDED, FLIGHT_STATE_LANDING, FLIGHT_STATE_FLYING, FLIGHT_STATE_EMERGENCY},
+}
+
+func (s FlightState) String() string {
+	name, ok := flightStateNames[s]
+	if !ok {
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+	return name
+}
+
+func (s FlightState) isAirborne() bool {
+	switch s {
+	case FLIGHT_STATE_TAKING_OFF, FLIGHT_STATE_FLYING, FLIGHT_STATE_LANDING, FLIGHT_STATE_EMERGENCY, FLIGHT_STATE_FAILSAFE:
+		return true
+	}
+	return false
+}
+
+// FlightStateMachine tracks the flight state of the drone from its connection, the commands sent to it and the flight data.
+// Commands invalid for the current state are rejected by 'Accept'.
+// Every transition is published to ApplicationStates.
+type FlightStateMachine struct {
+	state             FlightState
+	takeOffStartedAt  time.Time
+	applicationStates *ApplicationStates
+	onTransit         func(from FlightState, to FlightState)
+	mutex             sync.Mutex
+}
+
+func NewFlightStateMachine(applicationStates *ApplicationStates) *FlightStateMachine {
+	m := &FlightStateMachine{
+		applicationStates: applicationStates,
+	}
+	m.applicationStates.SetFlightState(FLIGHT_STATE_DISCONNECTED)
+	return m
+}
+
+func (m *FlightStateMachine) State() FlightState {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.state
+}
+
+// Registers the function called on every transition. It is called with the lock held,
+// so it must not call the methods of the FlightStateMachine.
+func (m *FlightStateMachine) OnTransit(handler func(from FlightState, to FlightState)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.onTransit = handler
+}
+
+func (m *FlightStateMachine) OnConnected() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.transit(FLIGHT_STATE_CONNECTED)
+}
+
+func (m *FlightStateMachine) OnDisconnected() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.transit(FLIGHT_STATE_DISCONNECTED)
+}
+
+// Derives the state from the flight data.
+// 'isFailsafe' is true while a failsafe (e.g. link loss, critical battery) controls the drone.
+func (m *FlightStateMachine) OnFlightData(fd FlightData, isFailsafe bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Receiving flight data means the drone is connected even if 'Connect' has not returned yet.
+	if m.state == FLIGHT_STATE_DISCONNECTED {
+		m.transit(FLIGHT_STATE_CONNECTED)
+	}
+
+	switch {
+	case fd.OnGround && !fd.Flying:
+		if m.state != FLIGHT_STATE_TAKING_OFF || takeOffTimeout < time.Since(m.takeOffStartedAt) {
+			m.transit(FLIGHT_STATE_LANDED)
+		}
+	case fd.Flying:
+		switch {
+		case !fd.ImuOk:
+			m.transit(FLIGHT_STATE_EMERGENCY)
+		case m.state == FLIGHT_STATE_LANDING:
+			// Stays landing until the drone is on the ground.
+		case isFailsafe:
+			m.transit(FLIGHT_STATE_FAILSAFE)
+		case m.state == FLIGHT_STATE_TAKING_OFF && fd.Height < takeOffCompletedHeight:
+			// Stays taking off until the drone climbs enough.
+		default:
+			m.transit(FLIGHT_STATE_FLYING)
+		}
+	}
+}
+
+// Validates the command against the current state. If it is valid, transits to the state the command leads to.
+// Land and emergency are never rejected because they must work even if the state is wrong.
+func (m *FlightStateMachine) Accept(command DroneCommand) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	switch command.CommandType {
+	case "takeoff", DRONE_ACTION_THROW_TAKE_OFF:
+		if m.state != FLIGHT_STATE_LANDED {
+			return fmt.Errorf("%v is invalid while %v", command.CommandType, m.state)
+		}
+		m.takeOffStartedAt = time.Now()
+		m.transit(FLIGHT_STATE_TAKING_OFF)
+	case "land", DRONE_ACTION_PALM_LAND:
+		if m.state.isAirborne() {
+			m.transit(FLIGHT_STATE_LANDING)
+		}
+	case "emergency":
+		if m.state.isAirborne() {
+			m.transit(FLIGHT_STATE_EMERGENCY)
+		}
+	case "vector":
+		// A zero vector (hover) is always accepted so that the drone can be stopped in any state.
+		mVec := command.Command.(MotionVector)
+		if !mVec.isZeroVector() && m.state != FLIGHT_STATE_FLYING && m.state != FLIGHT_STATE_TAKING_OFF {
+			return fmt.Errorf("vector is invalid while %v", m.state)
+		}
+	case DRONE_ACTION_FLIP, DRONE_ACTION_BOUNCE, DRONE_ACTION_ROTATE:
+		if m.state != FLIGHT_STATE_FLYING {
+			return fmt.Errorf("%v is invalid while %v", command.CommandType, m.state)
+		}
+	}
+	return nil
+}
+
+func (m *FlightStateMachine) transit(to FlightState) {
+	if m.state == to {
+		return
+	}
+
+	for _, valid := range validFlightStateTransitions[m.state] {
+		if valid == to {
+			applog.Info("Flight state changes from %v to %v.", m.state, to)
+			from := m.state
+			m.state = to
+			m.applicationStates.SetFlightState(to)
+			if m.onTransit != nil {
+				m.onTransit(from, to)
+			}
+			return
+		}
+	}
+	applog.Warn("Ignores an invalid flight state transition from %v to %v.", m.state, to)
+}
@@ -0,0 +1,157 @@
+package main
+
+import "testing"
+
+func TestFlightStateMachine(t *testing.T) {
+	type step struct {
+		name    string
+		apply   func(m *FlightStateMachine) error
+		wantErr bool
+		want    FlightState
+	}
+	connect := func(m *FlightStateMachine) error {
+		m.OnConnected()
+		return nil
+	}
+	disconnect := func(m *FlightStateMachine) error {
+		m.OnDisconnected()
+		return nil
+	}
+	flightData := func(fd FlightData) func(m *FlightStateMachine) error {
+		return func(m *FlightStateMachine) error {
+			m.OnFlightData(fd, false)
+			return nil
+		}
+	}
+	failsafeFlightData := func(fd FlightData) func(m *FlightStateMachine) error {
+		return func(m *FlightStateMachine) error {
+			m.OnFlightData(fd, true)
+			return nil
+		}
+	}
+	accept := func(commandType string, command interface{}) func(m *FlightStateMachine) error {
+		return func(m *FlightStateMachine) error {
+			return m.Accept(DroneCommand{CommandType: commandType, Command: command})
+		}
+	}
+	onGround := FlightData{OnGround: true, ImuOk: true}
+	climbing := FlightData{Flying: true, ImuOk: true, Height: 0.3}
+	flying := FlightData{Flying: true, ImuOk: true, Height: 1.2}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "takeoff is rejected until the drone reports it is on the ground",
+			steps: []step{
+				{"takeoff while disconnected", accept("takeoff", nil), true, FLIGHT_STATE_DISCONNECTED},
+				{"connect", connect, false, FLIGHT_STATE_CONNECTED},
+				{"takeoff while connected", accept("takeoff", nil), true, FLIGHT_STATE_CONNECTED},
+				{"on the ground", flightData(onGround), false, FLIGHT_STATE_LANDED},
+				{"takeoff", accept("takeoff", nil), false, FLIGHT_STATE_TAKING_OFF},
+				{"takeoff again", accept("takeoff", nil), true, FLIGHT_STATE_TAKING_OFF},
+			},
+		},
+		{
+			name: "takeoff completes above the height",
+			steps: []step{
+				{"on the ground", flightData(onGround), false, FLIGHT_STATE_LANDED},
+				{"throw takeoff", accept(DRONE_ACTION_THROW_TAKE_OFF, nil), false, FLIGHT_STATE_TAKING_OFF},
+				{"still on the ground", flightData(onGround), false, FLIGHT_STATE_TAKING_OFF},
+				{"climbing", flightData(climbing), false, FLIGHT_STATE_TAKING_OFF},
+				{"flying", flightData(flying), false, FLIGHT_STATE_FLYING},
+			},
+		},
+		{
+			name: "landing",
+			steps: []step{
+				{"flying", flightData(flying), false, FLIGHT_STATE_FLYING},
+				{"land", accept("land", nil), false, FLIGHT_STATE_LANDING},
+				{"descending", flightData(flying), false, FLIGHT_STATE_LANDING},
+				{"on the ground", flightData(onGround), false, FLIGHT_STATE_LANDED},
+				{"land on the ground", accept("land", nil), false, FLIGHT_STATE_LANDED},
+			},
+		},
+		{
+			name: "emergency",
+			steps: []step{
+				{"on the ground", flightData(onGround), false, FLIGHT_STATE_LANDED},
+				{"emergency on the ground", accept("emergency", EMERGENCY_STOP_SOURCE_HTTP), false, FLIGHT_STATE_LANDED},
+				{"flying", flightData(flying), false, FLIGHT_STATE_FLYING},
+				{"emergency", accept("emergency", EMERGENCY_STOP_SOURCE_HTTP), false, FLIGHT_STATE_EMERGENCY},
+				{"on the ground", flightData(onGround), false, FLIGHT_STATE_LANDED},
+			},
+		},
+		{
+			name: "IMU failure and failsafe",
+			steps: []step{
+				{"flying", flightData(flying), false, FLIGHT_STATE_FLYING},
+				{"failsafe", failsafeFlightData(flying), false, FLIGHT_STATE_FAILSAFE},
+				{"recovered", flightData(flying), false, FLIGHT_STATE_FLYING},
+				{"IMU failure", flightData(FlightData{Flying: true, Height: 1.2}), false, FLIGHT_STATE_EMERGENCY},
+			},
+		},
+		{
+			name: "actions and motion vectors",
+			steps: []step{
+				{"on the ground", flightData(onGround), false, FLIGHT_STATE_LANDED},
+				{"vector on the ground", accept("vector", MotionVector{X: 0.5}), true, FLIGHT_STATE_LANDED},
+				{"hover on the ground", accept("vector", MotionVector{}), false, FLIGHT_STATE_LANDED},
+				{"takeoff", accept("takeoff", nil), false, FLIGHT_STATE_TAKING_OFF},
+				{"flip while taking off", accept(DRONE_ACTION_FLIP, FLIP_DIRECTION_FRONT), true, FLIGHT_STATE_TAKING_OFF},
+				{"vector while taking off", accept("vector", MotionVector{Z: 0.5}), false, FLIGHT_STATE_TAKING_OFF},
+				{"flying", flightData(flying), false, FLIGHT_STATE_FLYING},
+				{"flip", accept(DRONE_ACTION_FLIP, FLIP_DIRECTION_FRONT), false, FLIGHT_STATE_FLYING},
+				{"rotate", accept(DRONE_ACTION_ROTATE, 90), false, FLIGHT_STATE_FLYING},
+			},
+		},
+		{
+			name: "reconnection",
+			steps: []step{
+				{"flying", flightData(flying), false, FLIGHT_STATE_FLYING},
+				{"disconnect", disconnect, false, FLIGHT_STATE_DISCONNECTED},
+				{"flight data after reconnecting", flightData(flying), false, FLIGHT_STATE_FLYING},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewFlightStateMachine(NewApplicationStates())
+
+			for _, s := range tt.steps {
+				err := s.apply(m)
+				if (err != nil) != s.wantErr {
+					t.Fatalf("%v: err = %v, wantErr %v", s.name, err, s.wantErr)
+				}
+				if state := m.State(); state != s.want {
+					t.Fatalf("%v: state = %v, want %v", s.name, state, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFlightStateMachineOnTransit(t *testing.T) {
+	m := NewFlightStateMachine(NewApplicationStates())
+	var transitions []FlightState
+	m.OnTransit(func(from FlightState, to FlightState) {
+		transitions = append(transitions, to)
+	})
+
+	m.OnFlightData(FlightData{OnGround: true, ImuOk: true}, false)
+	m.Accept(DroneCommand{CommandType: "takeoff"})
+	// Not a valid transition from taking off.
+	m.OnConnected()
+
+	expected := []FlightState{FLIGHT_STATE_CONNECTED, FLIGHT_STATE_LANDED, FLIGHT_STATE_TAKING_OFF}
+	if len(transitions) != len(expected) {
+		t.Fatalf("transitions = %v, want %v", transitions, expected)
+	}
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Fatalf("transitions = %v, want %v", transitions, expected)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/st-user/ojm-drone-local/env"
+	"github.com/st-user/ojm-drone-local/flightrecorder"
+)
+
+const (
+	defaultVideoFrameBufferSize = 8
+	// The duration of the first frame, which has no previous frame to derive it from. Tello streams at 30fps.
+	defaultVideoFrameDuration = time.Second / 30
+)
+
+// An access unit of the H.264 stream with the time its first bytes are received from the drone.
+type VideoFrame struct {
+	Data       []byte
+	ReceivedAt time.Time
+	IsKeyFrame bool
+}
+
+func NewVideoFrame(data []byte, receivedAt time.Time) VideoFrame {
+	return VideoFrame{
+		Data:       data,
+		ReceivedAt: receivedAt,
+		IsKeyFrame: flightrecorder.IsKeyFrame(data),
+	}
+}
+
+// The weight of the latest frame in the average latency.
+const frameLatencySmoothing = 0.1
+
+// The counts of the frames which pass through a FramePipeline and the latency from receiving a frame
+// from the drone to writing it to the peer. Published as 'videoFrames' in 'appInfo'.
+type FramePipelineStats struct {
+	Pushed           uint64  `json:"pushed"`
+	Dropped          uint64  `json:"dropped"`
+	DroppedKeyFrames uint64  `json:"droppedKeyFrames"`
+	Written          uint64  `json:"written"`
+	LatencyMs        float64 `json:"latencyMs"`
+	MaxLatencyMs     float64 `json:"maxLatencyMs"`
+}
+
+// FramePipeline passes the video frames from the drone to the WebRTC writer through a bounded ring buffer.
+// Push never blocks, so a slow writer does not stall receiving from the drone. Instead, when the buffer is full,
+// the oldest non-key frame and the rest of its GOP are dropped, because the following frames cannot be decoded without it.
+// The frames are dropped up to the next key frame, even if it has not been pushed yet.
+// A key frame is dropped only if all the buffered frames are key frames.
+type FramePipeline struct {
+	frames            []VideoFrame
+	head              int
+	count             int
+	isWaitingKeyFrame bool
+	notify            chan struct{}
+	stats             FramePipelineStats
+	mutex             sync.Mutex
+}
+
+func NewFramePipeline(size int) *FramePipeline {
+	if size <= 0 {
+		size = defaultVideoFrameBufferSize
+	}
+	return &FramePipeline{
+		frames: make([]VideoFrame, size),
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// Creates a FramePipeline with the size 'VIDEO_FRAME_BUFFER_SIZE'.
+func NewFramePipelineFromEnv() *FramePipeline {
+	return NewFramePipeline(env.GetInt("VIDEO_FRAME_BUFFER_SIZE"))
+}
+
+func (p *FramePipeline) Push(frame VideoFrame) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.stats.Pushed++
+	if p.isWaitingKeyFrame {
+		if !frame.IsKeyFrame {
+			p.stats.Dropped++
+			return
+		}
+		p.isWaitingKeyFrame = false
+	}
+	if p.count == len(p.frames) {
+		p.dropOne()
+	}
+	p.frames[(p.head+p.count)%len(p.frames)] = frame
+	p.count++
+
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Returns the oldest frame. Blocks until a frame is pushed. Returns false if ctx is done.
+func (p *FramePipeline) Pop(ctx context.Context) (VideoFrame, bool) {
+	for {
+		if frame, ok := p.tryPop(); ok {
+			return frame, true
+		}
+		select {
+		case <-p.notify:
+		case <-ctx.Done():
+			return VideoFrame{}, false
+		}
+	}
+}
+
+// Drops all the buffered frames, for example, those left by the previous run.
+func (p *FramePipeline) Clear() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for i := range p.frames {
+		p.frames[i] = VideoFrame{}
+	}
+	p.head = 0
+	p.count = 0
+	p.isWaitingKeyFrame = false
+}
+
+// Records the latency of a frame the writer has written.
+func (p *FramePipeline) RecordWritten(frame VideoFrame) {
+	latencyMs := float64(time.Since(frame.ReceivedAt)) / float64(time.Millisecond)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.stats.Written == 0 {
+		p.stats.LatencyMs = latencyMs
+	} else {
+		p.stats.LatencyMs += (latencyMs - p.stats.LatencyMs) * frameLatencySmoothing
+	}
+	if p.stats.MaxLatencyMs < latencyMs {
+		p.stats.MaxLatencyMs = latencyMs
+	}
+	p.stats.Written++
+}
+
+func (p *FramePipeline) Stats() FramePipelineStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.stats
+}
+
+func (p *FramePipeline) tryPop() (VideoFrame, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.count == 0 {
+		return VideoFrame{}, false
+	}
+	frame := p.frames[p.head]
+	p.frames[p.head] = VideoFrame{}
+	p.head = (p.head + 1) % len(p.frames)
+	p.count--
+	return frame, true
+}
+
+// Drops the oldest non-key frame and the non-key frames following it, or the oldest frame if all of them are key frames.
+// The order of the others is kept.
+func (p *FramePipeline) dropOne() {
+	first := 0
+	for first < p.count && p.frames[p.index(first)].IsKeyFrame {
+		first++
+	}
+
+	if first == p.count {
+		p.stats.DroppedKeyFrames++
+		p.stats.Dropped++
+		p.frames[p.head] = VideoFrame{}
+		p.head = (p.head + 1) % len(p.frames)
+		p.count--
+		return
+	}
+
+	next := first
+	for next < p.count && !p.frames[p.index(next)].IsKeyFrame {
+		p.stats.Dropped++
+		next++
+	}
+	if next == p.count {
+		p.isWaitingKeyFrame = true
+	}
+
+	kept := first
+	for i := next; i < p.count; i++ {
+		p.frames[p.index(kept)] = p.frames[p.index(i)]
+		kept++
+	}
+	for i := kept; i < p.count; i++ {
+		p.frames[p.index(i)] = VideoFrame{}
+	}
+	p.count = kept
+}
+
+func (p *FramePipeline) index(i int) int {
+	return (p.head + i) % len(p.frames)
+}
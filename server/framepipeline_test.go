@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func keyFrame(id byte) VideoFrame {
+	return NewVideoFrame([]byte{0, 0, 0, 1, 0x67, id}, time.Now())
+}
+
+func nonKeyFrame(id byte) VideoFrame {
+	return NewVideoFrame([]byte{0, 0, 0, 1, 0x41, id}, time.Now())
+}
+
+func popAll(p *FramePipeline) []byte {
+	ids := []byte{}
+	for {
+		frame, ok := p.tryPop()
+		if !ok {
+			return ids
+		}
+		ids = append(ids, frame.Data[5])
+	}
+}
+
+func TestFramePipelineDropsRestOfGOP(t *testing.T) {
+	p := NewFramePipeline(4)
+
+	p.Push(keyFrame(1))
+	p.Push(nonKeyFrame(2))
+	p.Push(nonKeyFrame(3))
+	p.Push(keyFrame(4))
+	// Drops 2 and 3, which the frames before 4 depend on.
+	p.Push(nonKeyFrame(5))
+
+	if ids := popAll(p); string(ids) != string([]byte{1, 4, 5}) {
+		t.Errorf("frames = %v, want [1 4 5]", ids)
+	}
+	if stats := p.Stats(); stats.Dropped != 2 || stats.DroppedKeyFrames != 0 {
+		t.Errorf("stats = %+v, want 2 dropped frames", stats)
+	}
+}
+
+func TestFramePipelineWaitsForKeyFrameAfterDrop(t *testing.T) {
+	p := NewFramePipeline(3)
+
+	p.Push(keyFrame(1))
+	p.Push(nonKeyFrame(2))
+	p.Push(nonKeyFrame(3))
+	// The GOP of 1 continues beyond the buffer, so the frames up to the next key frame are dropped.
+	p.Push(nonKeyFrame(4))
+	p.Push(nonKeyFrame(5))
+	p.Push(keyFrame(6))
+	p.Push(nonKeyFrame(7))
+
+	if ids := popAll(p); string(ids) != string([]byte{1, 6, 7}) {
+		t.Errorf("frames = %v, want [1 6 7]", ids)
+	}
+	if stats := p.Stats(); stats.Pushed != 7 || stats.Dropped != 4 {
+		t.Errorf("stats = %+v, want 7 pushed and 4 dropped frames", stats)
+	}
+}
+
+func TestFramePipelineDropsOldestKeyFrame(t *testing.T) {
+	p := NewFramePipeline(2)
+
+	p.Push(keyFrame(1))
+	p.Push(keyFrame(2))
+	p.Push(keyFrame(3))
+
+	if ids := popAll(p); string(ids) != string([]byte{2, 3}) {
+		t.Errorf("frames = %v, want [2 3]", ids)
+	}
+	if stats := p.Stats(); stats.Dropped != 1 || stats.DroppedKeyFrames != 1 {
+		t.Errorf("stats = %+v, want 1 dropped key frame", stats)
+	}
+}
+
+func TestFramePipelineRecordsLatency(t *testing.T) {
+	p := NewFramePipeline(2)
+
+	p.RecordWritten(NewVideoFrame([]byte{0, 0, 0, 1, 0x41}, time.Now().Add(-100*time.Millisecond)))
+	p.RecordWritten(NewVideoFrame([]byte{0, 0, 0, 1, 0x41}, time.Now().Add(-10*time.Millisecond)))
+
+	stats := p.Stats()
+	if stats.Written != 2 {
+		t.Errorf("written = %v, want 2", stats.Written)
+	}
+	if stats.MaxLatencyMs < 100 || stats.LatencyMs < 10 || 100 <= stats.LatencyMs {
+		t.Errorf("stats = %+v, want the max latency of 100ms or more and the average between", stats)
+	}
+}
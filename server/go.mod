@@ -4,6 +4,7 @@ go 1.16
 
 require (
 	github.com/danieljoos/wincred v1.1.0
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/google/uuid v1.2.0
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/websocket v1.4.2
@@ -11,7 +12,6 @@ require (
 	github.com/pion/rtcp v1.2.6
 	github.com/pion/webrtc/v3 v3.0.29
 	github.com/unrolled/secure v1.0.9
-	gobot.io/x/gobot v0.0.0-00010101000000-000000000000
+	gobot.io/x/gobot v1.15.0
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
 )
-
-replace gobot.io/x/gobot => ../../gobot
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/st-user/ojm-drone-local/applog"
+	"github.com/st-user/ojm-drone-local/appos"
+	"github.com/st-user/ojm-drone-local/env"
+)
+
+// The file 'start' writes the access key of the running application to so that 'stop' and 'status' can use its API.
+const headlessAccessKeyFileName = ".headless-access-key"
+
+const headlessUsage = `Usage: ojm-drone-local [command]
+
+Without a command, the application starts with the browser UI.
+
+Commands:
+  token set [token]          validates and saves the access token. It is read from stdin if omitted.
+  key generate               generates a start key with the saved access token.
+  start [--start-key key]    starts the application without the browser UI. A start key is generated if omitted.
+  stop                       stops the drones and the signaling connection of the running application.
+  status                     shows the states of the running application.
+  service unit [--user name] [--watchdog-sec sec]
+                             prints a systemd unit file which runs 'start' as a service.
+`
+
+// Runs the command of the headless mode, for example, when the application runs as a service with no display.
+// Returns the exit code.
+func runHeadless(args []string) int {
+	var err error
+	switch {
+	case len(args) >= 2 && args[0] == "token" && args[1] == "set":
+		err = headlessSetToken(args[2:])
+	case len(args) == 2 && args[0] == "key" && args[1] == "generate":
+		err = headlessGenerateKey()
+	case args[0] == "start":
+		err = headlessStart(args[1:])
+	case len(args) == 1 && args[0] == "stop":
+		err = headlessStop()
+	case len(args) == 1 && args[0] == "status":
+		err = headlessStatus()
+	case len(args) >= 2 && args[0] == "service" && args[1] == "unit":
+		err = headlessServiceUnit(args[2:])
+	default:
+		fmt.Print(headlessUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Printf("Fails to run '%v'. %v", strings.Join(args, " "), err)
+		fmt.Println()
+		return 1
+	}
+	return 0
+}
+
+func setUpKeyChainManager() error {
+	km, err := appos.NewKeyChainManager()
+	if err != nil {
+		return err
+	}
+	keyChainManager = km
+	return nil
+}
+
+func headlessSetToken(args []string) error {
+	if 1 < len(args) {
+		return fmt.Errorf("too many arguments")
+	}
+
+	var token string
+	if len(args) == 1 {
+		token = args[0]
+	} else {
+		// Reading from stdin keeps the token out of the shell history.
+		fmt.Println("Enter the access token:")
+		scanner := bufio.NewScanner(os.Stdin)
+		if !scanner.Scan() {
+			return fmt.Errorf("no access token is entered")
+		}
+		token = scanner.Text()
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return fmt.Errorf("access token is empty")
+	}
+
+	if err := setUpKeyChainManager(); err != nil {
+		return err
+	}
+	desc, err := validateAndUpdateAccessToken(token)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("The access token '%v' is saved.", desc)
+	fmt.Println()
+	return nil
+}
+
+func headlessGenerateKey() error {
+	if err := setUpKeyChainManager(); err != nil {
+		return err
+	}
+	startKey, err := requestStartKey()
+	if err != nil {
+		return err
+	}
+	fmt.Println(startKey)
+	return nil
+}
+
+// Starts the application and keeps it running until SIGINT or SIGTERM shuts it down gracefully.
+// The local API is served as usual so that 'stop' and 'status' can use it.
+func headlessStart(args []string) error {
+	flags := flag.NewFlagSet("start", flag.ContinueOnError)
+	startKey := flags.String("start-key", "", "the start key to connect to the signaling server with. generated if empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	StartSimulatorIfNeeded()
+	setUp()
+	go routes()
+
+	if *startKey == "" {
+		generated, err := requestStartKey()
+		if err != nil {
+			return err
+		}
+		*startKey = generated
+	}
+	if err := startAppOnce(*startKey); err != nil {
+		return err
+	}
+	fmt.Printf("Application is started with the start key '%v'.", *startKey)
+	fmt.Println()
+	startServiceNotifications()
+
+	accessKeyFile := headlessAccessKeyFile()
+	if err := ioutil.WriteFile(accessKeyFile, []byte(applicationStates.AccessKey), 0600); err != nil {
+		applog.Warn("Fails to write the access key file. 'stop' and 'status' are not available. %v", err)
+	}
+	addShutdownHook(func() {
+		os.Remove(accessKeyFile)
+	})
+
+	waitForSignalsAndShutDown()
+	return nil
+}
+
+func headlessStop() error {
+	if _, err := requestRunningApplication(http.MethodPost, "/cgi/stopApp"); err != nil {
+		return err
+	}
+	fmt.Println("Application is stopped.")
+	return nil
+}
+
+func headlessStatus() error {
+	states, err := requestRunningApplication(http.MethodGet, "/cgi/checkApplicationStates")
+	if err != nil {
+		return err
+	}
+	drones, err := requestRunningApplication(http.MethodGet, "/cgi/drones")
+	if err != nil {
+		return err
+	}
+
+	status := map[string]interface{}{}
+	for k, v := range states {
+		status[k] = v
+	}
+	for k, v := range drones {
+		status[k] = v
+	}
+	result, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(result))
+	return nil
+}
+
+// Calls the API of the application started by 'start'.
+// A new session is started with the access key, so a browser using the application has to reload.
+func requestRunningApplication(method string, path string) (map[string]interface{}, error) {
+	accessKey, err := ioutil.ReadFile(headlessAccessKeyFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no application started by 'start' is running")
+		}
+		return nil, err
+	}
+
+	baseUrl := "http://localhost:" + env.Get("PORT")
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	req, _ := http.NewRequest(http.MethodGet, baseUrl+"/dmz/startUsingApplication", nil)
+	req.Header.Set("x-ojm-drone-local-access-key", strings.TrimSpace(string(accessKey)))
+	var session map[string]string
+	if err := doJSONRequest(client, req, &session); err != nil {
+		return nil, err
+	}
+
+	req, _ = http.NewRequest(method, baseUrl+path, bytes.NewReader([]byte("{}")))
+	req.Header.Set(SESSION_KEY_HTTP_HEADER_KEY, session["sessionKey"])
+	var result map[string]interface{}
+	if err := doJSONRequest(client, req, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func doJSONRequest(client *http.Client, req *http.Request, result interface{}) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v responds with %v. %v", req.URL.Path, res.StatusCode, string(body))
+	}
+	return json.Unmarshal(body, result)
+}
+
+func headlessAccessKeyFile() string {
+	return filepath.Join(appos.BaseDir(), headlessAccessKeyFileName)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/st-user/ojm-drone-local/applog"
+	"github.com/st-user/ojm-drone-local/env"
+)
+
+const (
+	LINK_STATE_UNKNOWN   = 0
+	LINK_STATE_CONNECTED = 1
+	LINK_STATE_LOST      = 2
+	LINK_STATE_LANDED    = 3
+)
+
+const defaultLinkLossHoverTimeout = 10 * time.Second
+
+// LinkFailsafe keeps the drone safe when the link to the primary peer is lost.
+//
+//	CONNECTED -(ICE disconnected/failed, DataChannel closed)-> LOST: the drone hovers awaiting reconnection.
+//	LOST -(connected again)-> CONNECTED
+//	LOST -(timeout)-> LANDED: the drone lands automatically.
+//
+// It outlives the RTCHandler, which is recreated when the primary peer reconnects,
+// so that the drone lands even if the primary peer does not come back.
+// The hover and the landing are sent by a routine of the run of the drone, which ends with the run.
+type LinkFailsafe struct {
+	timeout            time.Duration
+	state              int
+	cancelLanding      context.CancelFunc
+	routineCoordinator *RoutineCoordinator
+	applicationStates  *ApplicationStates
+	mutex              sync.Mutex
+}
+
+func NewLinkFailsafe(routineCoordinator *RoutineCoordinator, applicationStates *ApplicationStates) *LinkFailsafe {
+	timeout := env.GetDuration("LINK_LOSS_HOVER_TIMEOUT")
+	if timeout <= 0 {
+		timeout = defaultLinkLossHoverTimeout
+	}
+	f := &LinkFailsafe{
+		timeout:            timeout,
+		routineCoordinator: routineCoordinator,
+		applicationStates:  applicationStates,
+	}
+	f.setState(LINK_STATE_UNKNOWN)
+	return f
+}
+
+// Forgets the link of the previous run of the drone.
+func (f *LinkFailsafe) Reset() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.cancelLanding != nil {
+		f.cancelLanding()
+		f.cancelLanding = nil
+	}
+	f.setState(LINK_STATE_UNKNOWN)
+}
+
+func (f *LinkFailsafe) OnConnected() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.cancelLanding != nil {
+		f.cancelLanding()
+		f.cancelLanding = nil
+	}
+	if f.state == LINK_STATE_LOST {
+		applog.Info("The link to the primary peer is recovered.")
+	}
+	f.setState(LINK_STATE_CONNECTED)
+}
+
+func (f *LinkFailsafe) OnDisconnected(reason string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.state != LINK_STATE_CONNECTED {
+		return
+	}
+
+	applog.Warn("The link to the primary peer is lost(%v). Hovers for %v awaiting reconnection.", reason, f.timeout)
+	f.setState(LINK_STATE_LOST)
+
+	landingCtx, cancelLanding := context.WithCancel(context.Background())
+	f.cancelLanding = cancelLanding
+
+	// Sent asynchronously because the command loop may be waiting for the caller.
+	f.routineCoordinator.Go("link loss failsafe", func(ctx context.Context) error {
+		f.routineCoordinator.SendDroneCommandChannel(DroneCommand{
+			CommandType: "vector",
+			Command:     MotionVector{},
+		})
+
+		timer := time.NewTimer(f.timeout)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			if f.expireHover(landingCtx) {
+				f.routineCoordinator.SendDroneCommandChannel(DroneCommand{
+					CommandType: "land",
+				})
+			}
+		case <-landingCtx.Done():
+		case <-ctx.Done():
+		}
+		return nil
+	})
+}
+
+// Returns true if the drone has to land, that is, the link has not been recovered since landingCtx was created.
+func (f *LinkFailsafe) expireHover(landingCtx context.Context) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.state != LINK_STATE_LOST || landingCtx.Err() != nil {
+		return false
+	}
+
+	applog.Warn("Lands automatically because the primary peer does not reconnect.")
+	f.setState(LINK_STATE_LANDED)
+	f.cancelLanding()
+	f.cancelLanding = nil
+	return true
+}
+
+func (f *LinkFailsafe) setState(state int) {
+	f.state = state
+	f.applicationStates.SetLinkState(state)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	MISSION_STEP_TAKE_OFF = "takeoff"
+	MISSION_STEP_MOVE     = "move"
+	MISSION_STEP_ROTATE   = "rotate"
+	MISSION_STEP_HOVER    = "hover"
+	MISSION_STEP_PHOTO    = "photo"
+	MISSION_STEP_LAND     = "land"
+	// A barrier only for choreographies. The drones wait until all of them reach the barrier with the same name.
+	MISSION_STEP_SYNC = "sync"
+)
+
+const (
+	MOVE_DIRECTION_FORWARD = "forward"
+	MOVE_DIRECTION_BACK    = "back"
+	MOVE_DIRECTION_LEFT    = "left"
+	MOVE_DIRECTION_RIGHT   = "right"
+	MOVE_DIRECTION_UP      = "up"
+	MOVE_DIRECTION_DOWN    = "down"
+)
+
+const (
+	maxMissionSteps         = 200
+	maxMissionHoverDuration = 60.0 // :s
+	// The height the drone climbs to by takeoff.
+	missionTakeOffHeight = 0.8 // :m
+)
+
+// A flight plan uploaded to '/cgi/missions'.
+type Mission struct {
+	Name  string        `json:"name"`
+	Steps []MissionStep `json:"steps"`
+}
+
+type MissionStep struct {
+	Type string `json:"type"`
+	// One of MOVE_DIRECTION_*. Only for 'move'.
+	Direction string `json:"direction,omitempty"`
+	// Only for 'move'.
+	Distance float64 `json:"distance,omitempty"` // :m
+	// Positive for clockwise, negative for counter-clockwise. Only for 'rotate'.
+	Degrees int `json:"degrees,omitempty"`
+	// Only for 'hover'.
+	Duration float64 `json:"duration,omitempty"` // :s
+	// Only for 'sync'.
+	Barrier string `json:"barrier,omitempty"`
+}
+
+// Returns the motion vector which moves the drone in the direction with the magnitude.
+func (s *MissionStep) toMotionVector(magnitude float32) MotionVector {
+	switch s.Direction {
+	case MOVE_DIRECTION_FORWARD:
+		return MotionVector{Y: magnitude}
+	case MOVE_DIRECTION_BACK:
+		return MotionVector{Y: -magnitude}
+	case MOVE_DIRECTION_RIGHT:
+		return MotionVector{X: magnitude}
+	case MOVE_DIRECTION_LEFT:
+		return MotionVector{X: -magnitude}
+	case MOVE_DIRECTION_UP:
+		return MotionVector{Z: magnitude}
+	case MOVE_DIRECTION_DOWN:
+		return MotionVector{Z: -magnitude}
+	}
+	return MotionVector{}
+}
+
+// Validates the mission by simulating its steps against the safety envelope.
+// A mission has to take off before moving and end with landing.
+func (m *Mission) Validate(config SafetyEnvelopeConfig) error {
+	return validateMissionSteps(m.Steps, config, false)
+}
+
+func validateMissionSteps(steps []MissionStep, config SafetyEnvelopeConfig, allowsSync bool) error {
+	if len(steps) == 0 {
+		return fmt.Errorf("mission has no steps")
+	}
+	if maxMissionSteps < len(steps) {
+		return fmt.Errorf("mission has more than %v steps", maxMissionSteps)
+	}
+
+	isAirborne := false
+	var north, east, height float64 // :m
+	heading := 0.0                  // :deg, clockwise from the heading at takeoff
+
+	for i, step := range steps {
+		stepError := func(format string, v ...interface{}) error {
+			return fmt.Errorf("step %v(%v): %v", i+1, step.Type, fmt.Sprintf(format, v...))
+		}
+
+		isGroundStep := step.Type == MISSION_STEP_TAKE_OFF || step.Type == MISSION_STEP_PHOTO || step.Type == MISSION_STEP_SYNC
+		if !isGroundStep && !isAirborne {
+			return stepError("the drone has not taken off")
+		}
+
+		switch step.Type {
+		case MISSION_STEP_TAKE_OFF:
+			if isAirborne {
+				return stepError("the drone has already taken off")
+			}
+			isAirborne = true
+			height = missionTakeOffHeight
+
+		case MISSION_STEP_MOVE:
+			if step.Distance <= 0 {
+				return stepError("distance must be positive")
+			}
+			rad := heading * math.Pi / 180
+			switch step.Direction {
+			case MOVE_DIRECTION_FORWARD:
+				north += step.Distance * math.Cos(rad)
+				east += step.Distance * math.Sin(rad)
+			case MOVE_DIRECTION_BACK:
+				north -= step.Distance * math.Cos(rad)
+				east -= step.Distance * math.Sin(rad)
+			case MOVE_DIRECTION_RIGHT:
+				north -= step.Distance * math.Sin(rad)
+				east += step.Distance * math.Cos(rad)
+			case MOVE_DIRECTION_LEFT:
+				north += step.Distance * math.Sin(rad)
+				east -= step.Distance * math.Cos(rad)
+			case MOVE_DIRECTION_UP:
+				height += step.Distance
+			case MOVE_DIRECTION_DOWN:
+				height -= step.Distance
+			default:
+				return stepError("invalid direction '%v'", step.Direction)
+			}
+
+			if config.MaxHeight < height {
+				return stepError("the height %.1fm exceeds %.1fm", height, config.MaxHeight)
+			}
+			if height < 0 {
+				return stepError("the drone goes under the ground")
+			}
+			if distance := math.Hypot(north, east); config.MaxDistance < distance {
+				return stepError("the distance from the takeoff point %.1fm exceeds %.1fm", distance, config.MaxDistance)
+			}
+
+		case MISSION_STEP_ROTATE:
+			if step.Degrees == 0 || 360 < step.Degrees || step.Degrees < -360 {
+				return stepError("invalid degrees %v", step.Degrees)
+			}
+			heading += float64(step.Degrees)
+
+		case MISSION_STEP_HOVER:
+			if step.Duration <= 0 || maxMissionHoverDuration < step.Duration {
+				return stepError("duration must be between 0 and %v seconds", maxMissionHoverDuration)
+			}
+
+		case MISSION_STEP_PHOTO:
+
+		case MISSION_STEP_SYNC:
+			if !allowsSync {
+				return stepError("only choreographies can sync")
+			}
+			if step.Barrier == "" {
+				return stepError("barrier is required")
+			}
+
+		case MISSION_STEP_LAND:
+			isAirborne = false
+			north, east, height = 0, 0, 0
+
+		default:
+			return stepError("unknown step type")
+		}
+	}
+
+	if isAirborne {
+		return fmt.Errorf("mission has to end with landing")
+	}
+	return nil
+}
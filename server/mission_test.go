@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateMissionSteps(t *testing.T) {
+	config := SafetyEnvelopeConfig{
+		MaxHeight:   2,
+		MaxDistance: 5,
+	}
+	takeOff := MissionStep{Type: MISSION_STEP_TAKE_OFF}
+	land := MissionStep{Type: MISSION_STEP_LAND}
+	move := func(direction string, distance float64) MissionStep {
+		return MissionStep{Type: MISSION_STEP_MOVE, Direction: direction, Distance: distance}
+	}
+	rotate := func(degrees int) MissionStep {
+		return MissionStep{Type: MISSION_STEP_ROTATE, Degrees: degrees}
+	}
+	hover := func(duration float64) MissionStep {
+		return MissionStep{Type: MISSION_STEP_HOVER, Duration: duration}
+	}
+	sync := func(barrier string) MissionStep {
+		return MissionStep{Type: MISSION_STEP_SYNC, Barrier: barrier}
+	}
+
+	tests := []struct {
+		name       string
+		steps      []MissionStep
+		allowsSync bool
+		// Empty if the steps are valid.
+		expectedError string
+	}{
+		{
+			name: "valid mission",
+			steps: []MissionStep{
+				takeOff, move(MOVE_DIRECTION_FORWARD, 3), rotate(90), move(MOVE_DIRECTION_FORWARD, 3),
+				{Type: MISSION_STEP_PHOTO}, hover(2), move(MOVE_DIRECTION_UP, 1), land,
+			},
+		},
+		{
+			name:          "no steps",
+			expectedError: "mission has no steps",
+		},
+		{
+			name:          "too many steps",
+			steps:         make([]MissionStep, maxMissionSteps+1),
+			expectedError: "more than",
+		},
+		{
+			name:          "moves before takeoff",
+			steps:         []MissionStep{move(MOVE_DIRECTION_FORWARD, 1), land},
+			expectedError: "step 1(move): the drone has not taken off",
+		},
+		{
+			name:          "takes off twice",
+			steps:         []MissionStep{takeOff, takeOff, land},
+			expectedError: "step 2(takeoff): the drone has already taken off",
+		},
+		{
+			name:          "non-positive distance",
+			steps:         []MissionStep{takeOff, move(MOVE_DIRECTION_FORWARD, 0), land},
+			expectedError: "distance must be positive",
+		},
+		{
+			name:          "invalid direction",
+			steps:         []MissionStep{takeOff, move("sideways", 1), land},
+			expectedError: "invalid direction",
+		},
+		{
+			name:          "above the max height",
+			steps:         []MissionStep{takeOff, move(MOVE_DIRECTION_UP, 1.5), land},
+			expectedError: "step 2(move): the height",
+		},
+		{
+			name:          "under the ground",
+			steps:         []MissionStep{takeOff, move(MOVE_DIRECTION_DOWN, 1), land},
+			expectedError: "under the ground",
+		},
+		{
+			name:          "too far from the takeoff point",
+			steps:         []MissionStep{takeOff, move(MOVE_DIRECTION_FORWARD, 3), rotate(90), move(MOVE_DIRECTION_FORWARD, 4.1), land},
+			expectedError: "step 4(move): the distance",
+		},
+		{
+			name:  "the heading turns the directions",
+			steps: []MissionStep{takeOff, move(MOVE_DIRECTION_FORWARD, 4), rotate(180), move(MOVE_DIRECTION_FORWARD, 4), move(MOVE_DIRECTION_FORWARD, 4), land},
+		},
+		{
+			name:  "landing resets the position",
+			steps: []MissionStep{takeOff, move(MOVE_DIRECTION_LEFT, 4), land, takeOff, move(MOVE_DIRECTION_LEFT, 4), land},
+		},
+		{
+			name:          "zero degrees",
+			steps:         []MissionStep{takeOff, rotate(0), land},
+			expectedError: "invalid degrees",
+		},
+		{
+			name:          "more than a turn",
+			steps:         []MissionStep{takeOff, rotate(-361), land},
+			expectedError: "invalid degrees",
+		},
+		{
+			name:          "too long hover",
+			steps:         []MissionStep{takeOff, hover(maxMissionHoverDuration + 1), land},
+			expectedError: "duration must be",
+		},
+		{
+			name:          "sync in a mission",
+			steps:         []MissionStep{takeOff, sync("a"), land},
+			expectedError: "only choreographies can sync",
+		},
+		{
+			name:          "sync without barrier",
+			steps:         []MissionStep{takeOff, sync(""), land},
+			allowsSync:    true,
+			expectedError: "barrier is required",
+		},
+		{
+			name:       "sync in a choreography",
+			steps:      []MissionStep{sync("start"), takeOff, sync("up"), land},
+			allowsSync: true,
+		},
+		{
+			name:          "unknown step type",
+			steps:         []MissionStep{takeOff, {Type: "dance"}, land},
+			expectedError: "unknown step type",
+		},
+		{
+			name:          "does not land",
+			steps:         []MissionStep{takeOff, hover(1)},
+			expectedError: "mission has to end with landing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMissionSteps(tt.steps, config, tt.allowsSync)
+			if tt.expectedError == "" {
+				if err != nil {
+					t.Fatalf("unexpected error. %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Fatalf("err = %v, want '%v'", err, tt.expectedError)
+			}
+		})
+	}
+}
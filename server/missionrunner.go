@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/st-user/ojm-drone-local/applog"
+)
+
+const (
+	MISSION_STATE_IDLE      = "idle"
+	MISSION_STATE_RUNNING   = "running"
+	MISSION_STATE_PAUSED    = "paused"
+	MISSION_STATE_COMPLETED = "completed"
+	MISSION_STATE_ABORTED   = "aborted"
+	MISSION_STATE_FAILED    = "failed"
+)
+
+const (
+	MISSION_CONTROL_PAUSE  = "pause"
+	MISSION_CONTROL_RESUME = "resume"
+	MISSION_CONTROL_ABORT  = "abort"
+)
+
+const (
+	missionTickInterval = 100 * time.Millisecond
+	missionStateTimeout = 15 * time.Second
+	missionSettleTime   = 1 * time.Second
+	// The number of the mission controls waiting to be handled. A control beyond it is rejected.
+	missionControlQueueSize = 4
+	// A move is done by sending this motion vector for the time estimated from the speed.
+	// There is no command to move by distance over the protocol gobot speaks.
+	missionVectorMagnitude = 0.5
+	missionEstimatedSpeed  = 0.5 // :m/s at missionVectorMagnitude
+)
+
+var errMissionAborted = errors.New("mission is aborted")
+
+type MissionStatus struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+	// 1-based index of the step being executed.
+	Step      int    `json:"step"`
+	StepCount int    `json:"stepCount"`
+	Error     string `json:"error,omitempty"`
+}
+
+// MissionRunner executes a mission step by step by sending DroneCommands like the operator does.
+// So the commands go through the safety envelope, the battery policy and the flight state machine.
+// Only one mission runs at a time. Pausing or aborting a mission makes the drone hover.
+type MissionRunner struct {
+	routineCoordinator *RoutineCoordinator
+	applicationStates  *ApplicationStates
+	isRunning          bool
+	mutex              sync.Mutex
+}
+
+// The state of a mission being executed.
+type missionExecution struct {
+	status           MissionStatus
+	isPaused         bool
+	isExpectedFlying bool
+	controlChannel   chan string
+	stopChannel      <-chan struct{}
+	// Closed to abort the execution. Nil if only the mission controls abort it.
+	abortChannel chan struct{}
+}
+
+func NewMissionRunner(routineCoordinator *RoutineCoordinator, applicationStates *ApplicationStates) *MissionRunner {
+	return &MissionRunner{
+		routineCoordinator: routineCoordinator,
+		applicationStates:  applicationStates,
+	}
+}
+
+func (r *MissionRunner) Start(mission Mission) error {
+	if err := r.acquire(); err != nil {
+		return err
+	}
+
+	e := &missionExecution{
+		status: MissionStatus{
+			Name:      mission.Name,
+			State:     MISSION_STATE_RUNNING,
+			StepCount: len(mission.Steps),
+		},
+		controlChannel: r.routineCoordinator.MissionControlChannel,
+	}
+	r.applicationStates.SetMissionStatus(e.status)
+
+	started := r.routineCoordinator.Go("mission", func(ctx context.Context) error {
+		e.stopChannel = ctx.Done()
+		r.run(mission, e)
+		return nil
+	})
+	if !started {
+		r.applicationStates.SetMissionStatus(MissionStatus{
+			State: MISSION_STATE_IDLE,
+		})
+		r.release()
+		return fmt.Errorf("the application is not started")
+	}
+	return nil
+}
+
+// Marks the runner running so that no other mission or choreography uses the drone.
+func (r *MissionRunner) acquire() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.isRunning {
+		return fmt.Errorf("another mission is running")
+	}
+	if r.routineCoordinator.IsStopped() {
+		return fmt.Errorf("the application is not started")
+	}
+	r.isRunning = true
+
+	// Controls sent to the previous mission after it ended are discarded.
+	for {
+		select {
+		case <-r.routineCoordinator.MissionControlChannel:
+		default:
+			return nil
+		}
+	}
+}
+
+func (r *MissionRunner) release() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.isRunning = false
+}
+
+func (r *MissionRunner) IsRunning() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.isRunning
+}
+
+func (r *MissionRunner) Control(control string) error {
+	switch control {
+	case MISSION_CONTROL_PAUSE, MISSION_CONTROL_RESUME, MISSION_CONTROL_ABORT:
+	default:
+		return fmt.Errorf("unknown mission control '%v'", control)
+	}
+
+	if !r.IsRunning() {
+		return fmt.Errorf("no mission is running")
+	}
+	// Does not block because it is called from the handler of the DataChannel messages.
+	if !r.routineCoordinator.SendMissionControl(control) {
+		return fmt.Errorf("mission controls are waiting to be handled")
+	}
+	return nil
+}
+
+func (r *MissionRunner) run(mission Mission, e *missionExecution) {
+	defer r.release()
+
+	applog.Info("Mission '%v' starts.", mission.Name)
+	config := NewSafetyEnvelopeConfig()
+
+	for i, step := range mission.Steps {
+		e.status.Step = i + 1
+		r.applicationStates.SetMissionStatus(e.status)
+		applog.Info("Mission '%v' executes step %v(%v).", mission.Name, i+1, step.Type)
+
+		if err := r.executeStep(step, config, e); err != nil {
+			r.hover()
+			if err == errMissionAborted {
+				e.status.State = MISSION_STATE_ABORTED
+				applog.Warn("Mission '%v' is aborted at step %v.", mission.Name, i+1)
+			} else {
+				e.status.State = MISSION_STATE_FAILED
+				e.status.Error = err.Error()
+				applog.Warn("Mission '%v' fails at step %v. %v", mission.Name, i+1, err)
+			}
+			r.applicationStates.SetMissionStatus(e.status)
+			return
+		}
+	}
+
+	e.status.State = MISSION_STATE_COMPLETED
+	r.applicationStates.SetMissionStatus(e.status)
+	applog.Info("Mission '%v' is completed.", mission.Name)
+}
+
+func (r *MissionRunner) executeStep(step MissionStep, config SafetyEnvelopeConfig, e *missionExecution) error {
+	isFlying := func() bool {
+		return r.applicationStates.GetFlightState() == FLIGHT_STATE_FLYING
+	}
+	isLanded := func() bool {
+		return r.applicationStates.GetFlightState() == FLIGHT_STATE_LANDED
+	}
+
+	switch step.Type {
+	case MISSION_STEP_TAKE_OFF:
+		r.send(DroneCommand{CommandType: "takeoff"})
+		completed, err := r.wait(e, missionStateTimeout, isFlying)
+		if err != nil {
+			return err
+		}
+		if !completed {
+			return fmt.Errorf("the drone does not take off")
+		}
+		e.isExpectedFlying = true
+
+	case MISSION_STEP_MOVE:
+		mVec := step.toMotionVector(missionVectorMagnitude)
+		speed := missionEstimatedSpeed * float64(config.MaxSpeedScale)
+		duration := time.Duration(step.Distance / speed * float64(time.Second))
+		_, err := r.wait(e, duration, func() bool {
+			r.send(DroneCommand{CommandType: "vector", Command: mVec})
+			return false
+		})
+		r.hover()
+		return err
+
+	case MISSION_STEP_ROTATE:
+		r.send(DroneCommand{CommandType: DRONE_ACTION_ROTATE, Command: step.Degrees})
+		duration := time.Duration(math.Abs(float64(step.Degrees))/telloEstimatedYawRate*float64(time.Second)) + missionSettleTime
+		_, err := r.wait(e, duration, nil)
+		return err
+
+	case MISSION_STEP_HOVER:
+		r.hover()
+		_, err := r.wait(e, time.Duration(step.Duration*float64(time.Second)), nil)
+		return err
+
+	case MISSION_STEP_PHOTO:
+		r.send(DroneCommand{CommandType: "takePicture"})
+		_, err := r.wait(e, missionSettleTime, nil)
+		return err
+
+	case MISSION_STEP_LAND:
+		e.isExpectedFlying = false
+		r.send(DroneCommand{CommandType: "land"})
+		completed, err := r.wait(e, missionStateTimeout, isLanded)
+		if err != nil {
+			return err
+		}
+		if !completed {
+			return fmt.Errorf("the drone does not land")
+		}
+	}
+	return nil
+}
+
+// Waits for the duration while handling mission controls. The time paused is not counted.
+// 'onTick' is called periodically unless paused. If it returns true, the wait completes early and true is returned.
+func (r *MissionRunner) wait(e *missionExecution, duration time.Duration, onTick func() bool) (bool, error) {
+	ticker := time.NewTicker(missionTickInterval)
+	defer ticker.Stop()
+
+	remaining := duration
+	last := time.Now()
+	for {
+		now := time.Now()
+		if !e.isPaused {
+			remaining -= now.Sub(last)
+		}
+		last = now
+		if remaining <= 0 && !e.isPaused {
+			return false, nil
+		}
+
+		select {
+		case control := <-e.controlChannel:
+			switch control {
+			case MISSION_CONTROL_PAUSE:
+				if !e.isPaused {
+					e.isPaused = true
+					r.hover()
+					e.status.State = MISSION_STATE_PAUSED
+					r.applicationStates.SetMissionStatus(e.status)
+					applog.Info("Mission '%v' is paused.", e.status.Name)
+				}
+			case MISSION_CONTROL_RESUME:
+				if e.isPaused {
+					e.isPaused = false
+					e.status.State = MISSION_STATE_RUNNING
+					r.applicationStates.SetMissionStatus(e.status)
+					applog.Info("Mission '%v' is resumed.", e.status.Name)
+				}
+			case MISSION_CONTROL_ABORT:
+				return false, errMissionAborted
+			}
+		case <-e.stopChannel:
+			return false, errMissionAborted
+		case <-e.abortChannel:
+			return false, errMissionAborted
+		case <-ticker.C:
+			if e.isExpectedFlying {
+				switch state := r.applicationStates.GetFlightState(); state {
+				case FLIGHT_STATE_FLYING, FLIGHT_STATE_TAKING_OFF:
+				default:
+					return false, fmt.Errorf("the flight state changes to %v", state)
+				}
+			}
+			if !e.isPaused && onTick != nil && onTick() {
+				return true, nil
+			}
+		}
+	}
+}
+
+func (r *MissionRunner) hover() {
+	r.send(DroneCommand{CommandType: "vector", Command: MotionVector{}})
+}
+
+func (r *MissionRunner) send(command DroneCommand) {
+	r.routineCoordinator.SendDroneCommandChannel(command)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/st-user/ojm-drone-local/appos"
+	"github.com/st-user/ojm-drone-local/env"
+)
+
+const (
+	photoFileExtension         = ".jpg"
+	photoMetadataFileExtension = ".json"
+)
+
+var photoIdPattern = regexp.MustCompile(`^[0-9]+$`)
+
+type PhotoMetadata struct {
+	Id      string `json:"id"`
+	DroneId string `json:"droneId"`
+	// Unix time in milliseconds when the photo is received.
+	Timestamp int64 `json:"timestamp"`
+	Size      int   `json:"size"`
+	// The telemetry when the photo is received.
+	Telemetry Telemetry `json:"telemetry"`
+}
+
+// PhotoStore saves the photos taken by the drone with their metadata to 'PHOTO_DIR'.
+// A photo is saved as '<id>.jpg' and its metadata as '<id>.json'. The id is the timestamp.
+type PhotoStore struct {
+	dir   string
+	mutex *sync.Mutex
+}
+
+// Serializes choosing photo ids among the drones.
+var photoStoreMutex sync.Mutex
+
+func NewPhotoStore() PhotoStore {
+	dir := env.Get("PHOTO_DIR")
+	if len(dir) == 0 {
+		dir = filepath.Join(appos.BaseDir(), "photos")
+	}
+	return PhotoStore{
+		dir:   dir,
+		mutex: &photoStoreMutex,
+	}
+}
+
+// The photos of all the drones are saved to the same directory. The id is shifted if another drone has taken a photo at the same time.
+func (s *PhotoStore) Save(droneId string, data []byte, telemetry Telemetry) (PhotoMetadata, error) {
+	if err := os.MkdirAll(s.dir, 0744); err != nil {
+		return PhotoMetadata{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	timestamp := time.Now().UnixNano() / int64(time.Millisecond)
+	id := timestamp
+	for {
+		if _, err := os.Stat(s.photoPath(strconv.FormatInt(id, 10))); os.IsNotExist(err) {
+			break
+		}
+		id++
+	}
+	metadata := PhotoMetadata{
+		Id:        strconv.FormatInt(id, 10),
+		DroneId:   droneId,
+		Timestamp: timestamp,
+		Size:      len(data),
+		Telemetry: telemetry,
+	}
+
+	if err := ioutil.WriteFile(s.photoPath(metadata.Id), data, 0644); err != nil {
+		return PhotoMetadata{}, err
+	}
+	metadataBytes, err := json.Marshal(metadata)
+	if err != nil {
+		return PhotoMetadata{}, err
+	}
+	if err := ioutil.WriteFile(s.metadataPath(metadata.Id), metadataBytes, 0644); err != nil {
+		return PhotoMetadata{}, err
+	}
+	return metadata, nil
+}
+
+// Returns the metadata of the saved photos from the oldest.
+func (s *PhotoStore) List() ([]PhotoMetadata, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []PhotoMetadata{}, nil
+		}
+		return nil, err
+	}
+
+	list := []PhotoMetadata{}
+	for _, f := range files {
+		id := strings.TrimSuffix(f.Name(), photoMetadataFileExtension)
+		if !strings.HasSuffix(f.Name(), photoMetadataFileExtension) || !photoIdPattern.MatchString(id) {
+			continue
+		}
+		metadata, err := s.Metadata(id)
+		if err != nil {
+			continue
+		}
+		list = append(list, metadata)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Timestamp < list[j].Timestamp
+	})
+	return list, nil
+}
+
+func (s *PhotoStore) Metadata(id string) (PhotoMetadata, error) {
+	if !photoIdPattern.MatchString(id) {
+		return PhotoMetadata{}, fmt.Errorf("invalid photo id '%v'", id)
+	}
+
+	body, err := ioutil.ReadFile(s.metadataPath(id))
+	if err != nil {
+		return PhotoMetadata{}, err
+	}
+	var metadata PhotoMetadata
+	if err := json.Unmarshal(body, &metadata); err != nil {
+		return PhotoMetadata{}, err
+	}
+	return metadata, nil
+}
+
+func (s *PhotoStore) Read(id string) ([]byte, error) {
+	if !photoIdPattern.MatchString(id) {
+		return nil, fmt.Errorf("invalid photo id '%v'", id)
+	}
+	return ioutil.ReadFile(s.photoPath(id))
+}
+
+func (s *PhotoStore) photoPath(id string) string {
+	return filepath.Join(s.dir, id+photoFileExtension)
+}
+
+func (s *PhotoStore) metadataPath(id string) string {
+	return filepath.Join(s.dir, id+photoMetadataFileExtension)
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"sync"
@@ -11,6 +13,8 @@ import (
 	"github.com/pion/webrtc/v3"
 	"github.com/pion/webrtc/v3/pkg/media"
 	"github.com/st-user/ojm-drone-local/applog"
+	"github.com/st-user/ojm-drone-local/env"
+	"github.com/st-user/ojm-drone-local/flightrecorder"
 )
 
 const (
@@ -19,106 +23,102 @@ const (
 	PEER_STATE_EMPTY = "EMPTY"
 )
 
-type RTCMessageData struct {
-	MessageType string
-	data        map[string]interface{}
-}
+// The size of the photo data in a 'photoChunk' message before base64 encoding.
+const photoChunkSize = 12 * 1024
 
-type ICEServerInfo struct {
-	ICEServers []webrtc.ICEServer
-}
+// Offers are rejected until the signaling server sends 'iceServerInfo'.
+var errNoICEConfig = errors.New("no ICE server configuration has been received")
 
-type PeerType struct {
-	PeerConnectionId string
-	IsPrimary        bool
+// RTCHandler handles the primary peer and the audiences of a drone.
+// Its routines are owned by the run of the drone and also end when the RTCHandler stops,
+// so that it can be recreated for a reconnecting primary peer while the drone keeps flying.
+type RTCHandler struct {
+	rtcPeerConnection         *webrtc.PeerConnection
+	config                    *webrtc.Configuration
+	peerConnectionId          string
+	audiencePeerConnections   map[string]AudiencePeerInfo
+	videoTrack                *webrtc.TrackLocalStaticSample
+	mutex                     sync.Mutex
+	isConnected               atomic.Value
+	linkFailsafe              *LinkFailsafe
+	missionRunner             *MissionRunner
+	emergencyStopConfirmation *EmergencyStopConfirmation
+	ctx                       context.Context
+	cancel                    context.CancelFunc
+	routines                  sync.WaitGroup
+	routinesMutex             sync.Mutex
 }
 
-func NewRTCMessageData(message *[]byte) (RTCMessageData, error) {
-	messageJson := make(map[string]interface{})
-	err := json.Unmarshal(*message, &messageJson)
-	if err != nil {
-		return RTCMessageData{}, err
-	}
-	messageType := messageJson["messageType"].(string)
-
-	return RTCMessageData{
-		MessageType: messageType,
-		data:        messageJson,
-	}, nil
+// A message the primary peer sends over the DataChannel.
+// 'videoRecording' is 'start' or 'stop'. 'emergency' requests the emergency stop. 'action' is one of DRONE_ACTION_TYPES.
+// 'mission' is one of MISSION_CONTROL_*. 'takePicture' requests a photo.
+// Otherwise, the message is a motion vector command.
+type DataChannelMessage struct {
+	Command        MotionVector `json:"command"`
+	VideoRecording string       `json:"videoRecording"`
+	Emergency      bool         `json:"emergency"`
+	Action         *DroneAction `json:"action"`
+	Mission        string       `json:"mission"`
+	TakePicture    bool         `json:"takePicture"`
 }
 
-func (d *RTCMessageData) ToConfiguration() (*webrtc.Configuration, error) {
-	_iceServerInfo, exists := d.data["iceServerInfo"]
-	config := webrtc.Configuration{}
-	if exists {
-		iceServerInfoMap := _iceServerInfo.(map[string]interface{})
-		iceServerInfoMapBytes, err := json.Marshal(iceServerInfoMap)
-		if err != nil {
-			return &config, err
-		}
-
-		var iceServerInfo ICEServerInfo
-		err = json.Unmarshal(iceServerInfoMapBytes, &iceServerInfo)
-		if err != nil {
-			return &config, err
-		}
-
-		config = webrtc.Configuration{
-			ICEServers: iceServerInfo.ICEServers,
-		}
-	}
-	return &config, nil
+type AudiencePeerInfo struct {
+	rtcPeerConnection      *webrtc.PeerConnection
+	audienceRTCStopChannel chan struct{}
 }
 
-func (d *RTCMessageData) ToPeerType() PeerType {
-	return PeerType{
-		PeerConnectionId: d.data["peerConnectionId"].(string),
-		IsPrimary:        d.data["isPrimary"].(bool),
-	}
-}
+// Each drone has its own RTCHandler. The arguments are the ones of the drone.
+// The RTCHandler stops when runCtx, the context of the run of the drone, is done.
+func NewRTCHandler(
+	runCtx context.Context,
+	linkFailsafe *LinkFailsafe,
+	missionRunner *MissionRunner,
+	emergencyStopConfirmation *EmergencyStopConfirmation) *RTCHandler {
 
-func (d *RTCMessageData) ToPeerConnectionId() string {
-	return d.data["peerConnectionId"].(string)
-}
-
-func (d *RTCMessageData) ToSessionDescription() (*webrtc.SessionDescription, error) {
-	sdp := webrtc.SessionDescription{}
-	offerBytes, err := json.Marshal(d.data["offer"])
-	if err != nil {
-		return &sdp, err
+	applog.Debug("RTCHandler is initialized.")
+	r := &RTCHandler{
+		peerConnectionId:          "",
+		audiencePeerConnections:   make(map[string]AudiencePeerInfo),
+		linkFailsafe:              linkFailsafe,
+		missionRunner:             missionRunner,
+		emergencyStopConfirmation: emergencyStopConfirmation,
 	}
+	r.ctx, r.cancel = context.WithCancel(runCtx)
+	r.isConnected.Store(false)
+	return r
+}
 
-	err = json.Unmarshal(offerBytes, &sdp)
-	if err != nil {
-		return &sdp, err
-	}
+// Closes the peers and waits until the routines of the RTCHandler return.
+func (handler *RTCHandler) Stop() {
+	handler.routinesMutex.Lock()
+	handler.cancel()
+	handler.routinesMutex.Unlock()
 
-	return &sdp, nil
+	handler.routines.Wait()
 }
 
-type RTCHandler struct {
-	rtcPeerConnection       *webrtc.PeerConnection
-	config                  *webrtc.Configuration
-	peerConnectionId        string
-	audiencePeerConnections map[string]AudiencePeerInfo
-	videoTrack              *webrtc.TrackLocalStaticSample
-	mutex                   sync.Mutex
-	isConnected             atomic.Value
+// Whether the RTCHandler is not stopping. The peers closed by stopping it are not regarded as lost.
+func (handler *RTCHandler) isLive() bool {
+	return handler.ctx.Err() == nil
 }
 
-type AudiencePeerInfo struct {
-	rtcPeerConnection      *webrtc.PeerConnection
-	audienceRTCStopChannel chan struct{}
-}
+// Starts a routine of the run of the drone which receives the context of the RTCHandler.
+func (handler *RTCHandler) goRoutine(routineCoordinator *RoutineCoordinator, name string, routine func(ctx context.Context) error) bool {
+	handler.routinesMutex.Lock()
+	defer handler.routinesMutex.Unlock()
 
-func NewRTCHandler() *RTCHandler {
-	applog.Debug("RTCHandler is initialized.")
-	r := &RTCHandler{
-		peerConnectionId:        "",
-		audiencePeerConnections: make(map[string]AudiencePeerInfo),
+	if !handler.isLive() {
+		return false
 	}
-	r.isConnected.Store(false)
-	return r
+	handler.routines.Add(1)
+	started := routineCoordinator.Go(name, func(_ context.Context) error {
+		defer handler.routines.Done()
+		return routine(handler.ctx)
+	})
+	if !started {
+		handler.routines.Done()
+	}
+	return started
 }
 
 func (handler *RTCHandler) SetConfig(config *webrtc.Configuration) error {
@@ -172,11 +172,16 @@ func (handler *RTCHandler) IsPrimary(peerConnectionId string) bool {
 func (handler *RTCHandler) StartPrimaryConnection(
 	remoteSdp *webrtc.SessionDescription,
 	routineCoordinator *RoutineCoordinator,
-	applicationStates *ApplicationStates) (*webrtc.SessionDescription, error) {
+	applicationStates *ApplicationStates,
+	recorder *flightrecorder.Recorder) (*webrtc.SessionDescription, error) {
 
 	handler.mutex.Lock()
 	defer handler.mutex.Unlock()
 
+	if handler.rtcPeerConnection == nil {
+		return nil, errNoICEConfig
+	}
+
 	cap := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000}
 	videoTrack, err := webrtc.NewTrackLocalStaticSample(cap, "video", "pion")
 	if err != nil {
@@ -191,13 +196,24 @@ func (handler *RTCHandler) StartPrimaryConnection(
 		return &webrtc.SessionDescription{}, err
 	}
 
-	go func() {
+	// Started before the negotiation so that the RTCP reader, which blocks until the peer connection is closed,
+	// returns even if the negotiation fails.
+	rtcPeerConnection := handler.rtcPeerConnection
+	handler.goRoutine(routineCoordinator, "primary peer closer", func(ctx context.Context) error {
+
+		<-ctx.Done()
+
+		rtcPeerConnection.Close()
+		return nil
+	})
+
+	handler.goRoutine(routineCoordinator, "primary peer RTCP reader", func(ctx context.Context) error {
 		rtcpBuf := make([]byte, 1500)
 		for {
 			select {
-			case <-routineCoordinator.StopSignalChannel:
+			case <-ctx.Done():
 				applog.Info("Stops WebRTC event loop.")
-				return
+				return nil
 			default:
 
 				n, _, rtcpErr := rtpSender.Read(rtcpBuf)
@@ -217,18 +233,26 @@ func (handler *RTCHandler) StartPrimaryConnection(
 				}
 			}
 		}
-	}()
+	})
 
 	handler.rtcPeerConnection.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
 		applog.Info("Connection State has changed %s \n", connectionState.String())
+		recorder.RecordConnection("primaryPeer", connectionState.String())
 
 		switch connectionState {
 		case webrtc.ICEConnectionStateConnected:
 			handler.isConnected.Store(true)
-		default:
-			//handler.isConnected.Store(false)
+			handler.linkFailsafe.OnConnected()
+		case webrtc.ICEConnectionStateDisconnected, webrtc.ICEConnectionStateFailed:
+			handler.isConnected.Store(false)
+			if handler.isLive() {
+				handler.linkFailsafe.OnDisconnected("ICE " + connectionState.String())
+			}
+		case webrtc.ICEConnectionStateClosed:
+			// Closed only by stopping the RTCHandler, which is not a loss of the link.
+			handler.isConnected.Store(false)
 		}
-		applicationStates.SetDroneStateFromConnectionState(handler.IsPeerConnected())
+		applicationStates.SetPeerConnected(handler.IsPeerConnected())
 	})
 
 	handler.rtcPeerConnection.OnDataChannel(func(dataChannel *webrtc.DataChannel) {
@@ -236,34 +260,74 @@ func (handler *RTCHandler) StartPrimaryConnection(
 		dataChannel.OnOpen(func() {
 			applog.Info("DataChannel opened.")
 
-			defer dataChannel.Close()
-			for {
-				select {
-				case message := <-routineCoordinator.DataChannelMessageChannel:
-					messageJson := map[string]interface{}{
-						"messageType": message,
-					}
-					data, err := json.Marshal(messageJson)
-					if err != nil {
-						applog.Info("%v", err)
-						continue
-					}
-					dataChannel.SendText(string(data))
-				case <-routineCoordinator.StopSignalChannel:
-					applog.Info("Stop handling dataChannel.")
-					return
-				}
+			handler.goRoutine(routineCoordinator, "DataChannel writer", func(ctx context.Context) error {
+				return writeDataChannel(ctx, dataChannel, routineCoordinator, applicationStates)
+			})
+		})
 
+		dataChannel.OnClose(func() {
+			applog.Info("DataChannel closed.")
+			if handler.isLive() {
+				handler.linkFailsafe.OnDisconnected("DataChannel closed")
 			}
 		})
 
 		dataChannel.OnMessage(func(msg webrtc.DataChannelMessage) {
-			messageJson := make(map[string]MotionVector)
+			var messageJson DataChannelMessage
 			err := json.Unmarshal(msg.Data, &messageJson)
 			if err != nil {
 				return
 			}
-			message := messageJson["command"]
+
+			if messageJson.Emergency {
+				if !env.GetBool("EMERGENCY_STOP_FROM_PRIMARY_PEER") {
+					applog.Warn("Rejects an emergency stop from the primary peer. EMERGENCY_STOP_FROM_PRIMARY_PEER is not enabled.")
+					return
+				}
+				if handler.emergencyStopConfirmation.Request(EMERGENCY_STOP_SOURCE_PRIMARY_PEER) {
+					RequestEmergencyStop(routineCoordinator, EMERGENCY_STOP_SOURCE_PRIMARY_PEER)
+				}
+				return
+			}
+
+			if messageJson.TakePicture {
+				routineCoordinator.SendDroneCommandChannel(DroneCommand{
+					CommandType: "takePicture",
+				})
+				return
+			}
+
+			if messageJson.Mission != "" {
+				if err := handler.missionRunner.Control(messageJson.Mission); err != nil {
+					applog.Warn("Rejects a mission control from the primary peer. %v", err)
+				}
+				return
+			}
+
+			if messageJson.Action != nil {
+				command, err := messageJson.Action.ToDroneCommand()
+				if err != nil {
+					applog.Warn("Rejects an action from the primary peer. %v", err)
+					return
+				}
+				routineCoordinator.SendDroneCommandChannel(command)
+				return
+			}
+
+			switch messageJson.VideoRecording {
+			case "start":
+				routineCoordinator.SendDroneCommandChannel(DroneCommand{
+					CommandType: "startVideoRecording",
+				})
+				return
+			case "stop":
+				routineCoordinator.SendDroneCommandChannel(DroneCommand{
+					CommandType: "stopVideoRecording",
+				})
+				return
+			}
+
+			message := messageJson.Command
 			applog.Debug("%v", message)
 			routineCoordinator.SendDroneCommandChannel(DroneCommand{
 				CommandType: "vector",
@@ -292,34 +356,137 @@ func (handler *RTCHandler) StartPrimaryConnection(
 
 	<-gatherComplete
 
-	go func() {
+	handler.goRoutine(routineCoordinator, "video writer", func(ctx context.Context) error {
 
-		<-routineCoordinator.StopSignalChannel
+		// The duration of a sample is the interval between the frames received from the drone,
+		// so that the delay of this writer does not distort the timing of the stream.
+		var previousReceivedAt time.Time
+
+		for {
+			frame, ok := routineCoordinator.DroneFrames.Pop(ctx)
+			if !ok {
+				applog.Info("Stop sending video stream.")
+				return nil
+			}
 
-		handler.rtcPeerConnection.Close()
-	}()
+			duration := defaultVideoFrameDuration
+			if !previousReceivedAt.IsZero() && previousReceivedAt.Before(frame.ReceivedAt) {
+				duration = frame.ReceivedAt.Sub(previousReceivedAt)
+			}
+			previousReceivedAt = frame.ReceivedAt
 
-	go func() {
+			videoTrack.WriteSample(media.Sample{
+				Data: frame.Data, Duration: duration,
+			})
+			routineCoordinator.DroneFrames.RecordWritten(frame)
+		}
+	})
 
-		latest := time.Now()
+	return handler.rtcPeerConnection.LocalDescription(), nil
+}
 
-		for {
+// Sends the messages, the telemetry and the photos to the primary peer until the run is cancelled.
+func writeDataChannel(ctx context.Context, dataChannel *webrtc.DataChannel, routineCoordinator *RoutineCoordinator, applicationStates *ApplicationStates) error {
+	defer dataChannel.Close()
 
-			select {
-			case frame := <-routineCoordinator.DroneFrameChannel:
-				videoTrack.WriteSample(media.Sample{
-					Data: frame, Duration: time.Since(latest),
+	telemetryTicker := time.NewTicker(TelemetryPublishInterval())
+	defer telemetryTicker.Stop()
+
+	sentBatteryWarning := BATTERY_WARNING_NONE
+
+	messages := routineCoordinator.OpenMessageQueue()
+	defer routineCoordinator.CloseMessageQueue(messages)
+	photos := routineCoordinator.OpenPhotoQueue()
+	defer routineCoordinator.ClosePhotoQueue(photos)
+
+	for {
+		select {
+		case message := <-messages:
+			messageJson := map[string]interface{}{
+				"messageType": message,
+			}
+			data, err := json.Marshal(messageJson)
+			if err != nil {
+				applog.Info("%v", err)
+				continue
+			}
+			dataChannel.SendText(string(data))
+		case <-telemetryTicker.C:
+			if warningLevel := applicationStates.GetBatteryWarning(); warningLevel != sentBatteryWarning {
+				data, err := json.Marshal(map[string]interface{}{
+					"messageType": "batteryWarning",
+					"level":       warningLevel,
 				})
-				latest = time.Now()
-			case <-routineCoordinator.StopSignalChannel:
-				applog.Info("Stop sending video stream.")
-				return
+				if err != nil {
+					applog.Info("%v", err)
+					continue
+				}
+				dataChannel.SendText(string(data))
+				sentBatteryWarning = warningLevel
 			}
 
+			telemetry := applicationStates.GetTelemetry()
+			if telemetry.IsEmpty() {
+				continue
+			}
+			data, err := json.Marshal(telemetry.ToMessage())
+			if err != nil {
+				applog.Info("%v", err)
+				continue
+			}
+			dataChannel.SendText(string(data))
+		case metadata := <-photos:
+			if err := sendPhoto(dataChannel, metadata); err != nil {
+				applog.Warn("Fails to transfer a photo. %v", err)
+			}
+		case <-ctx.Done():
+			applog.Info("Stop handling dataChannel.")
+			return nil
 		}
-	}()
 
-	return handler.rtcPeerConnection.LocalDescription(), nil
+	}
+}
+
+// Sends the photo as a 'photo' message with its metadata followed by 'photoChunk' messages with base64 encoded data.
+func sendPhoto(dataChannel *webrtc.DataChannel, metadata PhotoMetadata) error {
+	photoStore := NewPhotoStore()
+	data, err := photoStore.Read(metadata.Id)
+	if err != nil {
+		return err
+	}
+
+	chunkCount := (len(data) + photoChunkSize - 1) / photoChunkSize
+	header, err := json.Marshal(map[string]interface{}{
+		"messageType": "photo",
+		"photo":       metadata,
+		"chunkCount":  chunkCount,
+	})
+	if err != nil {
+		return err
+	}
+	if err := dataChannel.SendText(string(header)); err != nil {
+		return err
+	}
+
+	for i := 0; i < chunkCount; i++ {
+		end := (i + 1) * photoChunkSize
+		if len(data) < end {
+			end = len(data)
+		}
+		chunk, err := json.Marshal(map[string]interface{}{
+			"messageType": "photoChunk",
+			"id":          metadata.Id,
+			"index":       i,
+			"data":        base64.StdEncoding.EncodeToString(data[i*photoChunkSize : end]),
+		})
+		if err != nil {
+			return err
+		}
+		if err := dataChannel.SendText(string(chunk)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (handler *RTCHandler) StartAudienceConnection(
@@ -330,6 +497,9 @@ func (handler *RTCHandler) StartAudienceConnection(
 	handler.mutex.Lock()
 	defer handler.mutex.Unlock()
 
+	if handler.config == nil {
+		return nil, errNoICEConfig
+	}
 	if handler.videoTrack == nil {
 		return nil, errors.New("videoTrack is nil")
 	}
@@ -345,6 +515,10 @@ func (handler *RTCHandler) StartAudienceConnection(
 		rtcPeerConnection:      peerConnection,
 		audienceRTCStopChannel: stopChan,
 	}
+	// The previous connection of the same audience, if any, is stopped because it is replaced.
+	if previous, ok := handler.removeAudience(peerConnectionId); ok && previous.audienceRTCStopChannel != nil {
+		close(previous.audienceRTCStopChannel)
+	}
 	handler.audiencePeerConnections[peerConnectionId] = peerInfo
 
 	rtpSender, err := peerConnection.AddTrack(handler.videoTrack)
@@ -355,21 +529,28 @@ func (handler *RTCHandler) StartAudienceConnection(
 
 	terminate := func() {
 		peerConnection.Close()
-		delete(handler.audiencePeerConnections, peerConnectionId)
+
+		handler.mutex.Lock()
+		defer handler.mutex.Unlock()
+
+		// Removes the entry only if it has not been replaced by a new connection of the same audience.
+		if current, ok := handler.audiencePeerConnections[peerConnectionId]; ok && current.audienceRTCStopChannel == stopChan {
+			delete(handler.audiencePeerConnections, peerConnectionId)
+		}
 	}
 
-	go func() {
+	handler.goRoutine(routineCoordinator, "audience peer closer", func(ctx context.Context) error {
 
 		select {
 		case <-peerInfo.audienceRTCStopChannel:
 			terminate()
-		case <-routineCoordinator.StopSignalChannel:
+		case <-ctx.Done():
 			terminate()
 		}
+		return nil
+	})
 
-	}()
-
-	go func() {
+	handler.goRoutine(routineCoordinator, "audience peer RTCP reader", func(ctx context.Context) error {
 		rtcpBuf := make([]byte, 1500)
 
 		for {
@@ -377,10 +558,10 @@ func (handler *RTCHandler) StartAudienceConnection(
 			select {
 			case <-peerInfo.audienceRTCStopChannel:
 				applog.Info("Stops an audience WebRTC event loop. %v", peerConnectionId)
-				return
-			case <-routineCoordinator.StopSignalChannel:
+				return nil
+			case <-ctx.Done():
 				applog.Info("Stop audiences WebRTC event loop.")
-				return
+				return nil
 			default:
 
 				n, _, rtcpErr := rtpSender.Read(rtcpBuf)
@@ -403,7 +584,7 @@ func (handler *RTCHandler) StartAudienceConnection(
 				}
 			}
 		}
-	}()
+	})
 
 	err = peerConnection.SetRemoteDescription(*remoteSdp)
 	if err != nil {
@@ -430,13 +611,14 @@ func (handler *RTCHandler) StartAudienceConnection(
 	return peerConnection.LocalDescription(), nil
 }
 
+// Stops the connection of the audience. The routines of the connection close it.
 func (handler *RTCHandler) SendAudienceRTCStopChannel(peerConnectionId string) {
 	handler.mutex.Lock()
 	defer handler.mutex.Unlock()
 
-	con, ok := handler.audiencePeerConnections[peerConnectionId]
-	if ok && con.audienceRTCStopChannel != nil {
-		close(con.audienceRTCStopChannel)
+	audienceInfo, ok := handler.removeAudience(peerConnectionId)
+	if ok && audienceInfo.audienceRTCStopChannel != nil {
+		close(audienceInfo.audienceRTCStopChannel)
 	}
 }
 
@@ -444,9 +626,8 @@ func (handler *RTCHandler) DeleteAudience(peerConnectionId string) {
 	handler.mutex.Lock()
 	defer handler.mutex.Unlock()
 
-	audienceInfo, ok := handler.audiencePeerConnections[peerConnectionId]
+	audienceInfo, ok := handler.removeAudience(peerConnectionId)
 	if ok {
-		delete(handler.audiencePeerConnections, peerConnectionId)
 		if audienceInfo.audienceRTCStopChannel != nil {
 			close(audienceInfo.audienceRTCStopChannel)
 		}
@@ -454,7 +635,16 @@ func (handler *RTCHandler) DeleteAudience(peerConnectionId string) {
 			audienceInfo.rtcPeerConnection.Close()
 		}
 	}
+}
 
+// Removes the entry of the audience. Its stop channel is closed only by the caller which has removed it,
+// so that the channel is closed once. Has to be called with the mutex locked.
+func (handler *RTCHandler) removeAudience(peerConnectionId string) (AudiencePeerInfo, bool) {
+	audienceInfo, ok := handler.audiencePeerConnections[peerConnectionId]
+	if ok {
+		delete(handler.audiencePeerConnections, peerConnectionId)
+	}
+	return audienceInfo, ok
 }
 
 func (handler *RTCHandler) IsPeerConnected() bool {
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestRTCHandlerRejectsOffersWithoutConfig(t *testing.T) {
+	r := NewRoutineCoordinator()
+	r.Start()
+	defer r.Wait()
+	defer r.StopApp()
+
+	handler := NewRTCHandler(r.Context(), nil, nil, nil)
+	defer handler.Stop()
+	offer := &webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: "v=0"}
+
+	if _, err := handler.StartPrimaryConnection(offer, r, NewApplicationStates(), nil); err != errNoICEConfig {
+		t.Errorf("StartPrimaryConnection returns %v, want %v", err, errNoICEConfig)
+	}
+	if _, err := handler.StartAudienceConnection("p1", offer, r); err != errNoICEConfig {
+		t.Errorf("StartAudienceConnection returns %v, want %v", err, errNoICEConfig)
+	}
+}
+
+func TestRTCHandlerStopsAfterFailedNegotiation(t *testing.T) {
+	r := NewRoutineCoordinator()
+	r.Start()
+	// Does not wait for the routines, which never return if the RTCHandler does not stop.
+	defer r.StopApp()
+
+	handler := NewRTCHandler(r.Context(), nil, nil, nil)
+	if err := handler.SetConfig(&webrtc.Configuration{}); err != nil {
+		t.Fatal(err)
+	}
+
+	offer := &webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: "garbage"}
+	if _, err := handler.StartPrimaryConnection(offer, r, NewApplicationStates(), nil); err == nil {
+		t.Fatal("a malformed offer is answered")
+	}
+	// Lets the RTCP reader block on reading from the peer connection.
+	time.Sleep(100 * time.Millisecond)
+	returnsWithin(t, 5*time.Second, "Stop", handler.Stop)
+}
+
+func TestRTCHandlerStopsAudienceOnce(t *testing.T) {
+	r := NewRoutineCoordinator()
+	handler := NewRTCHandler(r.Context(), nil, nil, nil)
+
+	stopChan := make(chan struct{})
+	handler.audiencePeerConnections["audience"] = AudiencePeerInfo{
+		audienceRTCStopChannel: stopChan,
+	}
+
+	// A second 'canOffer' or 'close' can arrive before the connection is terminated.
+	var requests sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		requests.Add(2)
+		go func() {
+			defer requests.Done()
+			handler.SendAudienceRTCStopChannel("audience")
+		}()
+		go func() {
+			defer requests.Done()
+			handler.DeleteAudience("audience")
+		}()
+	}
+	requests.Wait()
+
+	select {
+	case <-stopChan:
+	default:
+		t.Fatal("the stop channel is not closed")
+	}
+	if _, ok := handler.audiencePeerConnections["audience"]; ok {
+		t.Fatal("the audience is left")
+	}
+}
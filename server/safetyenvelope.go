@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/st-user/ojm-drone-local/applog"
+	"github.com/st-user/ojm-drone-local/env"
+)
+
+const (
+	SAFETY_ACTION_NONE  = 0
+	SAFETY_ACTION_HOVER = 1
+	SAFETY_ACTION_LAND  = 2
+)
+
+const (
+	defaultSafetyMaxHeight            = 5.0  // :m
+	defaultSafetyMaxDistance          = 30.0 // :m
+	defaultSafetyMaxSpeedScale        = 1.0
+	defaultSafetyMinBatteryPercentage = 15
+)
+
+type SafetyEnvelopeConfig struct {
+	MaxHeight   float64 // :m
+	MaxDistance float64 // :m
+	// Each component of a motion vector is multiplied by this value. (0.0 - 1.0)
+	MaxSpeedScale float32
+	// Takeoff is rejected below this level. Landing on a low battery is handled by BatteryPolicy.
+	MinBatteryPercentage int
+}
+
+func NewSafetyEnvelopeConfig() SafetyEnvelopeConfig {
+	config := SafetyEnvelopeConfig{
+		MaxHeight:            env.GetFloat("SAFETY_MAX_HEIGHT"),
+		MaxDistance:          env.GetFloat("SAFETY_MAX_DISTANCE"),
+		MaxSpeedScale:        float32(env.GetFloat("SAFETY_MAX_SPEED_SCALE")),
+		MinBatteryPercentage: env.GetInt("SAFETY_MIN_BATTERY_PERCENTAGE"),
+	}
+	if config.MaxHeight <= 0 {
+		config.MaxHeight = defaultSafetyMaxHeight
+	}
+	if config.MaxDistance <= 0 {
+		config.MaxDistance = defaultSafetyMaxDistance
+	}
+	if config.MaxSpeedScale <= 0 || 1 < config.MaxSpeedScale {
+		config.MaxSpeedScale = defaultSafetyMaxSpeedScale
+	}
+	if config.MinBatteryPercentage <= 0 {
+		config.MinBatteryPercentage = defaultSafetyMinBatteryPercentage
+	}
+	return config
+}
+
+// SafetyEnvelope limits what the remote operator can do with the drone.
+// Motion vectors are clamped by the speed scale and the max height, and
+// the drone is landed automatically when it goes too far from the takeoff point.
+//
+// The distance from the takeoff point is estimated by integrating the north/east speeds in the flight data.
+type SafetyEnvelope struct {
+	config             SafetyEnvelopeConfig
+	flightData         FlightData
+	hasFlightData      bool
+	positionNorth      float64 // :m
+	positionEast       float64 // :m
+	lastUpdated        time.Time
+	isHovering         bool
+	isLandingTriggered bool
+	mutex              sync.Mutex
+}
+
+func NewSafetyEnvelope(config SafetyEnvelopeConfig) *SafetyEnvelope {
+	return &SafetyEnvelope{
+		config: config,
+	}
+}
+
+// Updates the state with the latest flight data and returns the action the drone has to take.
+func (e *SafetyEnvelope) UpdateFlightData(fd FlightData) int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := time.Now()
+	if e.hasFlightData && fd.Flying {
+		elapsed := now.Sub(e.lastUpdated).Seconds()
+		e.positionNorth += fd.NorthSpeed * elapsed
+		e.positionEast += fd.EastSpeed * elapsed
+	}
+	if fd.OnGround {
+		e.positionNorth = 0
+		e.positionEast = 0
+	}
+	e.flightData = fd
+	e.hasFlightData = true
+	e.lastUpdated = now
+
+	if !fd.Flying || e.isLandingTriggered {
+		return SAFETY_ACTION_NONE
+	}
+
+	if distance := e.distance(); e.config.MaxDistance < distance {
+		applog.Warn("Lands automatically because the drone is %.1fm away from the takeoff point.", distance)
+		e.isLandingTriggered = true
+		return SAFETY_ACTION_LAND
+	}
+
+	if e.config.MaxHeight < fd.Height {
+		if !e.isHovering {
+			applog.Warn("Hovers because the height(%.1fm) exceeds %.1fm.", fd.Height, e.config.MaxHeight)
+			e.isHovering = true
+			return SAFETY_ACTION_HOVER
+		}
+	} else {
+		e.isHovering = false
+	}
+
+	return SAFETY_ACTION_NONE
+}
+
+func (e *SafetyEnvelope) CanTakeOff() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if !e.hasFlightData {
+		return fmt.Errorf("no flight data has been received")
+	}
+	if e.flightData.BatteryPercentage < e.config.MinBatteryPercentage {
+		return fmt.Errorf("the battery level(%v%%) is below %v%%", e.flightData.BatteryPercentage, e.config.MinBatteryPercentage)
+	}
+	return nil
+}
+
+// Regards the current position as the takeoff point.
+func (e *SafetyEnvelope) ResetOrigin() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.positionNorth = 0
+	e.positionEast = 0
+	e.isHovering = false
+	e.isLandingTriggered = false
+}
+
+// Returns the motion vector the drone is actually allowed to follow.
+func (e *SafetyEnvelope) Filter(mVec MotionVector) MotionVector {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.isLandingTriggered {
+		return MotionVector{}
+	}
+
+	scale := func(v float32) float32 {
+		return float32(math.Max(-1, math.Min(1, float64(v)))) * e.config.MaxSpeedScale
+	}
+	filtered := MotionVector{
+		X: scale(mVec.X),
+		Y: scale(mVec.Y),
+		Z: scale(mVec.Z),
+		R: scale(mVec.R),
+	}
+
+	if e.config.MaxHeight <= e.flightData.Height && 0 < filtered.Z {
+		filtered.Z = 0
+	}
+	return filtered
+}
+
+func (e *SafetyEnvelope) distance() float64 {
+	return math.Hypot(e.positionNorth, e.positionEast)
+}
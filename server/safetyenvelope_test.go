@@ -0,0 +1,154 @@
+package main
+
+import "testing"
+
+func newTestSafetyEnvelope() *SafetyEnvelope {
+	return NewSafetyEnvelope(SafetyEnvelopeConfig{
+		MaxHeight:            5,
+		MaxDistance:          30,
+		MaxSpeedScale:        0.5,
+		MinBatteryPercentage: 15,
+	})
+}
+
+func TestSafetyEnvelopeFilter(t *testing.T) {
+	tests := []struct {
+		name             string
+		height           float64
+		landingTriggered bool
+		input            MotionVector
+		expected         MotionVector
+	}{
+		{
+			name:     "scaled by the max speed scale",
+			height:   1,
+			input:    MotionVector{X: 1, Y: -1, Z: 0.5, R: -0.4},
+			expected: MotionVector{X: 0.5, Y: -0.5, Z: 0.25, R: -0.2},
+		},
+		{
+			name:     "clamped to [-1, 1] before scaling",
+			height:   1,
+			input:    MotionVector{X: 3, Y: -2, Z: 1.5, R: -10},
+			expected: MotionVector{X: 0.5, Y: -0.5, Z: 0.5, R: -0.5},
+		},
+		{
+			name:     "no climbing at the max height",
+			height:   5,
+			input:    MotionVector{X: 1, Z: 1},
+			expected: MotionVector{X: 0.5},
+		},
+		{
+			name:     "descending at the max height",
+			height:   6,
+			input:    MotionVector{Z: -1},
+			expected: MotionVector{Z: -0.5},
+		},
+		{
+			name:             "stopped after the landing is triggered",
+			height:           1,
+			landingTriggered: true,
+			input:            MotionVector{X: 1, Y: 1, Z: -1, R: 1},
+			expected:         MotionVector{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestSafetyEnvelope()
+			e.flightData = FlightData{Height: tt.height, Flying: true}
+			e.isLandingTriggered = tt.landingTriggered
+
+			if actual := e.Filter(tt.input); actual != tt.expected {
+				t.Errorf("Filter(%+v) = %+v, want %+v", tt.input, actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSafetyEnvelopeUpdateFlightData(t *testing.T) {
+	tests := []struct {
+		name string
+		// Distance from the takeoff point to the north before the update.
+		positionNorth float64
+		flightData    []FlightData
+		expected      []int
+	}{
+		{
+			name:       "within the envelope",
+			flightData: []FlightData{{Flying: true, Height: 2}},
+			expected:   []int{SAFETY_ACTION_NONE},
+		},
+		{
+			name:       "hovers once above the max height",
+			flightData: []FlightData{{Flying: true, Height: 6}, {Flying: true, Height: 6}},
+			expected:   []int{SAFETY_ACTION_HOVER, SAFETY_ACTION_NONE},
+		},
+		{
+			name: "hovers again after going back below the max height",
+			flightData: []FlightData{
+				{Flying: true, Height: 6},
+				{Flying: true, Height: 4},
+				{Flying: true, Height: 6},
+			},
+			expected: []int{SAFETY_ACTION_HOVER, SAFETY_ACTION_NONE, SAFETY_ACTION_HOVER},
+		},
+		{
+			name:          "lands once too far from the takeoff point",
+			positionNorth: 31,
+			flightData:    []FlightData{{Flying: true, Height: 6}, {Flying: true, Height: 6}},
+			expected:      []int{SAFETY_ACTION_LAND, SAFETY_ACTION_NONE},
+		},
+		{
+			name:          "ignores the breaches on the ground",
+			positionNorth: 31,
+			flightData:    []FlightData{{OnGround: true, Height: 6}},
+			expected:      []int{SAFETY_ACTION_NONE},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestSafetyEnvelope()
+			e.positionNorth = tt.positionNorth
+
+			for i, fd := range tt.flightData {
+				if actual := e.UpdateFlightData(fd); actual != tt.expected[i] {
+					t.Errorf("update %v = %v, want %v", i, actual, tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSafetyEnvelopeResetsOnLanding(t *testing.T) {
+	e := newTestSafetyEnvelope()
+	e.positionNorth = 31
+	if action := e.UpdateFlightData(FlightData{Flying: true}); action != SAFETY_ACTION_LAND {
+		t.Fatalf("action = %v, want %v", action, SAFETY_ACTION_LAND)
+	}
+
+	e.UpdateFlightData(FlightData{OnGround: true})
+	if distance := e.distance(); distance != 0 {
+		t.Fatalf("distance on the ground = %v, want 0", distance)
+	}
+
+	e.ResetOrigin()
+	if actual := e.Filter(MotionVector{X: 1}); actual.X != 0.5 {
+		t.Fatalf("motion vector after the next takeoff = %+v", actual)
+	}
+}
+
+func TestSafetyEnvelopeCanTakeOff(t *testing.T) {
+	e := newTestSafetyEnvelope()
+	if e.CanTakeOff() == nil {
+		t.Fatal("takeoff is accepted before the flight data arrives")
+	}
+	e.UpdateFlightData(FlightData{OnGround: true, BatteryPercentage: 14})
+	if e.CanTakeOff() == nil {
+		t.Fatal("takeoff is accepted below the min battery percentage")
+	}
+	e.UpdateFlightData(FlightData{OnGround: true, BatteryPercentage: 15})
+	if err := e.CanTakeOff(); err != nil {
+		t.Fatalf("takeoff is rejected. %v", err)
+	}
+}
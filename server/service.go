@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/st-user/ojm-drone-local/applog"
+	"github.com/st-user/ojm-drone-local/appos"
+	"github.com/st-user/ojm-drone-local/env"
+)
+
+const (
+	defaultShutdownTimeout        = 30 * time.Second
+	defaultShutdownLandingTimeout = 15 * time.Second
+	shutdownLandingCheckInterval  = 200 * time.Millisecond
+	defaultServiceWatchdogSec     = 30
+)
+
+var shutdownOnce sync.Once
+var shutdownHooks []func()
+var shutdownHooksMutex sync.Mutex
+
+// Registers a function called at the end of shutting down, for example, to remove a file the application has written.
+func addShutdownHook(hook func()) {
+	shutdownHooksMutex.Lock()
+	defer shutdownHooksMutex.Unlock()
+
+	shutdownHooks = append(shutdownHooks, hook)
+}
+
+// Waits for SIGINT or SIGTERM and shuts down the application gracefully.
+// Another signal while shutting down makes the application exit immediately.
+func waitForSignalsAndShutDown() {
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+
+	sig := <-signalChan
+	go shutDown(fmt.Sprintf("Received %v.", sig))
+
+	<-signalChan
+	fmt.Println("Application exits without waiting for the drones to land.")
+	applog.Flush()
+	os.Exit(2)
+}
+
+// Lands the drones in the air, closes the peers and the signaling connection, flushes the logs and exits.
+// If it does not complete within 'SHUTDOWN_TIMEOUT', the application exits with 1.
+func shutDown(reason string) {
+	shutdownOnce.Do(func() {
+		applog.Warn("Application shuts down. %v", reason)
+		notifyService("STOPPING=1")
+
+		timeout := env.GetDuration("SHUTDOWN_TIMEOUT")
+		if timeout <= 0 {
+			timeout = defaultShutdownTimeout
+		}
+		go func() {
+			time.Sleep(timeout)
+			applog.Warn("Fails to shut down within %v. Application exits forcibly.", timeout)
+			applog.Flush()
+			os.Exit(1)
+		}()
+
+		landAirborneDrones("the application shuts down")
+
+		stopAppAndDrones()
+		routineCoordinator.Wait()
+		for _, unit := range droneFleet.Units() {
+			unit.routineCoordinator.Wait()
+		}
+		swarmRunner.Wait()
+
+		shutdownHooksMutex.Lock()
+		for _, hook := range shutdownHooks {
+			hook()
+		}
+		shutdownHooksMutex.Unlock()
+
+		applog.Info("Application has shut down.")
+		applog.Flush()
+		os.Exit(0)
+	})
+}
+
+// Requests all the drones in the air to land and waits until they land or 'SHUTDOWN_LANDING_TIMEOUT' elapses.
+// It has to be called before stopping the drones because stopping one disconnects it even if it is flying.
+func landAirborneDrones(reason string) {
+	timeout := env.GetDuration("SHUTDOWN_LANDING_TIMEOUT")
+	if timeout <= 0 {
+		timeout = defaultShutdownLandingTimeout
+	}
+
+	for _, unit := range droneFleet.Units() {
+		switch unit.applicationStates.GetFlightState() {
+		case FLIGHT_STATE_TAKING_OFF, FLIGHT_STATE_FLYING, FLIGHT_STATE_FAILSAFE:
+			applog.Warn("Drone '%v' lands because %v.", unit.Id, reason)
+			r := unit.routineCoordinator
+			r.Go("shutdown landing request", func(ctx context.Context) error {
+				RequestLanding(r)
+				return nil
+			})
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		airborne := []string{}
+		for _, unit := range droneFleet.Units() {
+			if unit.applicationStates.GetFlightState().isAirborne() {
+				airborne = append(airborne, unit.Id)
+			}
+		}
+		if len(airborne) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			applog.Warn("Drones %v do not land within %v. Application shuts down anyway.", airborne, timeout)
+			return
+		}
+		time.Sleep(shutdownLandingCheckInterval)
+	}
+}
+
+// Tells systemd that the application is ready and keeps notifying its watchdog if it is enabled.
+// It has to be called after the application has started.
+// The watchdog is notified only while the application is alive, so that systemd restarts it when it hangs.
+func startServiceNotifications() {
+	if !notifyService("READY=1") {
+		return
+	}
+
+	interval := appos.ServiceWatchdogInterval()
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+
+		client := &http.Client{Timeout: interval / 4}
+		for range ticker.C {
+			if err := checkLiveness(client); err != nil {
+				applog.Warn("Does not notify the watchdog. %v", err)
+				continue
+			}
+			notifyService("WATCHDOG=1")
+		}
+	}()
+}
+
+// Returns an error if the HTTP server does not respond or the loops of a running drone are stuck.
+func checkLiveness(client *http.Client) error {
+	res, err := client.Get("http://localhost:" + env.Get("PORT") + "/dmz/health")
+	if err != nil {
+		return fmt.Errorf("the HTTP server does not respond. %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("the HTTP server responds with %v", res.StatusCode)
+	}
+
+	for _, unit := range droneFleet.Units() {
+		if !unit.IsAlive() {
+			return fmt.Errorf("the loops of drone '%v' are stuck", unit.Id)
+		}
+	}
+	return nil
+}
+
+func notifyService(state string) bool {
+	notified, err := appos.NotifyService(state)
+	if err != nil {
+		applog.Warn("Fails to notify systemd of '%v'. %v", state, err)
+	}
+	return notified
+}
+
+// Prints a systemd unit file which runs the application with 'start'.
+func headlessServiceUnit(args []string) error {
+	flags := flag.NewFlagSet("service unit", flag.ContinueOnError)
+	user := flags.String("user", "", "the user the service runs as. the user running this command if empty")
+	watchdogSec := flags.Int("watchdog-sec", defaultServiceWatchdogSec, "the watchdog timeout in seconds. disabled if 0")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	exe, err = filepath.Abs(exe)
+	if err != nil {
+		return err
+	}
+	if *user == "" {
+		*user = os.Getenv("USER")
+	}
+
+	// systemd has to wait longer than the application so that the drones can land.
+	shutdownTimeout := env.GetDuration("SHUTDOWN_TIMEOUT")
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = defaultShutdownTimeout
+	}
+
+	lines := []string{
+		"[Unit]",
+		"Description=ojm-drone-local",
+		"Wants=network-online.target",
+		"After=network-online.target",
+		"",
+		"[Service]",
+		"Type=notify",
+		"NotifyAccess=main",
+		fmt.Sprintf("ExecStart=%v start", exe),
+		fmt.Sprintf("WorkingDirectory=%v", appos.BaseDir()),
+		"Restart=on-failure",
+		"RestartSec=5",
+		fmt.Sprintf("TimeoutStopSec=%v", int((shutdownTimeout + 5*time.Second).Seconds())),
+	}
+	if 0 < *watchdogSec {
+		lines = append(lines, fmt.Sprintf("WatchdogSec=%v", *watchdogSec))
+	}
+	if *user != "" {
+		lines = append(lines, fmt.Sprintf("User=%v", *user))
+	}
+	lines = append(lines,
+		"",
+		"[Install]",
+		"WantedBy=multi-user.target",
+	)
+
+	fmt.Println(strings.Join(lines, "\n"))
+	return nil
+}
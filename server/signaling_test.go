@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/st-user/ojm-drone-local/fakesignaling"
+)
+
+// Prepares the default drone without connecting to a physical drone.
+func startSignalingTestFleet(t *testing.T) *DroneUnit {
+	t.Helper()
+
+	routineCoordinator = NewRoutineCoordinator()
+	routineCoordinator.Start()
+	droneFleet = NewDroneFleet([]DroneConfig{{Id: DEFAULT_DRONE_ID}})
+
+	unit := droneFleet.Default()
+	unit.routineCoordinator.Start()
+	unit.rtcHandler = unit.newRTCHandler(nil)
+	unit.drone = NewDrone(unit.Id, NewFlightControllerFactory(unit.config), unit.snapshot)
+
+	t.Cleanup(func() {
+		routineCoordinator.StopApp()
+		routineCoordinator.Wait()
+		unit.RTCHandler().Stop()
+		unit.routineCoordinator.StopApp()
+		unit.routineCoordinator.Wait()
+	})
+	return unit
+}
+
+// Creates the offer of a browser which receives the video and opens the DataChannel.
+func newRemotePeer(t *testing.T) (*webrtc.PeerConnection, fakesignaling.SessionDescription) {
+	t.Helper()
+
+	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peerConnection.Close() })
+
+	if _, err := peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionRecvonly,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peerConnection.CreateDataChannel("data", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gatheringComplete := webrtc.GatheringCompletePromise(peerConnection)
+	if err := peerConnection.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gatheringComplete
+
+	return peerConnection, fakesignaling.SessionDescription{
+		Type: peerConnection.LocalDescription().Type.String(),
+		SDP:  peerConnection.LocalDescription().SDP,
+	}
+}
+
+// Connects the application to a fake signaling server. Returns the server, its endpoint and the session.
+func connectFakeSignaling(t *testing.T) (*fakesignaling.Server, string, *fakesignaling.Session) {
+	t.Helper()
+
+	fake := fakesignaling.NewServer(fakesignaling.Options{})
+	ts := httptest.NewServer(fake)
+	t.Cleanup(ts.Close)
+
+	startKey := fake.IssueStartKey()
+	startKeyJsonBytes, _ := json.Marshal(map[string]string{
+		"startKey": startKey,
+	})
+	if err := negotiateSignalingConnection(ts.URL+"/", startKeyJsonBytes); err != nil {
+		t.Fatal(err)
+	}
+	session, err := fake.WaitForSession(startKey, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(session.Disconnect)
+	return fake, ts.URL + "/", session
+}
+
+// Negotiates the peer connection of a browser and applies the answer.
+func offerFromRemotePeer(t *testing.T, session *fakesignaling.Session, droneId string, peerConnectionId string) *webrtc.PeerConnection {
+	t.Helper()
+
+	peerConnection, offer := newRemotePeer(t)
+	answer, err := session.OfferTo(droneId, peerConnectionId, offer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer.Type != webrtc.SDPTypeAnswer.String() || answer.SDP == "" {
+		t.Fatalf("answer to %v = %+v", peerConnectionId, answer)
+	}
+	if err := peerConnection.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeAnswer,
+		SDP:  answer.SDP,
+	}); err != nil {
+		t.Fatalf("the answer to %v is not applicable. %v", peerConnectionId, err)
+	}
+	return peerConnection
+}
+
+// Waits until the condition holds.
+func waitFor(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if deadline.Before(time.Now()) {
+			t.Fatal(description)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func hasAudience(handler *RTCHandler, peerConnectionId string) bool {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+
+	_, ok := handler.audiencePeerConnections[peerConnectionId]
+	return ok
+}
+
+func TestNegotiateSignalingConnection(t *testing.T) {
+	unit := startSignalingTestFleet(t)
+	_, _, session := connectFakeSignaling(t)
+
+	if state, err := session.CanOffer("primary", true); err != nil || state != PEER_STATE_EMPTY {
+		t.Fatalf("canOffer of the primary peer = %v, %v, want %v", state, err, PEER_STATE_EMPTY)
+	}
+	if state, err := session.CanOffer("another", true); err != nil || state != PEER_STATE_EXIST {
+		t.Fatalf("canOffer of another primary peer = %v, %v, want %v", state, err, PEER_STATE_EXIST)
+	}
+
+	offerFromRemotePeer(t, session, "", "primary")
+
+	// Closing the primary peer replaces the RTCHandler, so that the next primary peer can connect.
+	previous := unit.RTCHandler()
+	if err := session.Close("primary", true); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the RTCHandler is not restarted after the primary peer is closed", func() bool {
+		return unit.RTCHandler() != previous
+	})
+	if state, err := session.CanOffer("next", true); err != nil || state != PEER_STATE_EMPTY {
+		t.Fatalf("canOffer of the next primary peer = %v, %v, want %v", state, err, PEER_STATE_EMPTY)
+	}
+}
+
+func TestNegotiateAudienceConnection(t *testing.T) {
+	unit := startSignalingTestFleet(t)
+	_, _, session := connectFakeSignaling(t)
+
+	if state, err := session.CanOfferTo("unknown", "audience", false); err != nil || state != PEER_STATE_EXIST {
+		t.Fatalf("canOffer to an unknown drone = %v, %v, want %v", state, err, PEER_STATE_EXIST)
+	}
+
+	// The audiences receive the video track the primary connection creates.
+	if state, err := session.CanOfferTo(DEFAULT_DRONE_ID, "primary", true); err != nil || state != PEER_STATE_EMPTY {
+		t.Fatalf("canOffer of the primary peer = %v, %v, want %v", state, err, PEER_STATE_EMPTY)
+	}
+	offerFromRemotePeer(t, session, DEFAULT_DRONE_ID, "primary")
+
+	if state, err := session.CanOfferTo(DEFAULT_DRONE_ID, "audience", false); err != nil || state != PEER_STATE_EMPTY {
+		t.Fatalf("canOffer of the audience = %v, %v, want %v", state, err, PEER_STATE_EMPTY)
+	}
+	offerFromRemotePeer(t, session, DEFAULT_DRONE_ID, "audience")
+
+	// The audience reconnecting replaces its connection.
+	if state, err := session.CanOfferTo(DEFAULT_DRONE_ID, "audience", false); err != nil || state != PEER_STATE_SAME {
+		t.Fatalf("canOffer of the reconnecting audience = %v, %v, want %v", state, err, PEER_STATE_SAME)
+	}
+	if hasAudience(unit.RTCHandler(), "audience") {
+		t.Fatal("the previous connection of the audience is not stopped")
+	}
+	if state, err := session.CanOfferTo(DEFAULT_DRONE_ID, "audience", false); err != nil || state != PEER_STATE_EMPTY {
+		t.Fatalf("canOffer of the audience after stopping = %v, %v, want %v", state, err, PEER_STATE_EMPTY)
+	}
+	offerFromRemotePeer(t, session, DEFAULT_DRONE_ID, "audience")
+
+	previous := unit.RTCHandler()
+	if err := session.CloseTo(DEFAULT_DRONE_ID, "audience", false); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the audience is not removed after it is closed", func() bool {
+		return !hasAudience(unit.RTCHandler(), "audience")
+	})
+	if unit.RTCHandler() != previous {
+		t.Fatal("closing the audience restarts the RTCHandler")
+	}
+}
+
+func TestReconnectPrimaryPeer(t *testing.T) {
+	unit := startSignalingTestFleet(t)
+	_, _, session := connectFakeSignaling(t)
+
+	if state, err := session.CanOffer("primary", true); err != nil || state != PEER_STATE_EMPTY {
+		t.Fatalf("canOffer of the primary peer = %v, %v, want %v", state, err, PEER_STATE_EMPTY)
+	}
+	offerFromRemotePeer(t, session, "", "primary")
+
+	// The same primary peer asking again restarts only the peer connection.
+	previousHandler := unit.RTCHandler()
+	previousDrone := unit.Drone()
+	if state, err := session.CanOffer("primary", true); err != nil || state != PEER_STATE_SAME {
+		t.Fatalf("canOffer of the reconnecting primary peer = %v, %v, want %v", state, err, PEER_STATE_SAME)
+	}
+	if unit.RTCHandler() == previousHandler {
+		t.Fatal("the RTCHandler is not restarted")
+	}
+	if unit.Drone() != previousDrone {
+		t.Fatal("the drone is restarted")
+	}
+
+	if state, err := session.CanOffer("primary", true); err != nil || state != PEER_STATE_EMPTY {
+		t.Fatalf("canOffer after the restart = %v, %v, want %v", state, err, PEER_STATE_EMPTY)
+	}
+	offerFromRemotePeer(t, session, "", "primary")
+}
+
+func TestRestartApp(t *testing.T) {
+	startSignalingTestFleet(t)
+	fake, baseUrl, session := connectFakeSignaling(t)
+
+	applicationStates.SetStartKey(session.StartKey)
+	defer applicationStates.SetStartKey("")
+
+	restartApp(baseUrl)
+
+	select {
+	case <-session.Closed():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the previous signaling connection is not closed")
+	}
+	next, err := fake.WaitForSession(session.StartKey, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer next.Disconnect()
+
+	// The restarted drone accepts a new primary peer.
+	if state, err := next.CanOffer("primary", true); err != nil || state != PEER_STATE_EMPTY {
+		t.Fatalf("canOffer after the restart = %v, %v, want %v", state, err, PEER_STATE_EMPTY)
+	}
+	offerFromRemotePeer(t, next, "", "primary")
+}
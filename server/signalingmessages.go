@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// The version of the signaling message schema this application speaks.
+// Messages without 'version' are treated as version 1 for compatibility with servers which do not send it.
+const SIGNALING_PROTOCOL_VERSION = 1
+
+const (
+	SIGNALING_MESSAGE_PING            = "ping"
+	SIGNALING_MESSAGE_PONG            = "pong"
+	SIGNALING_MESSAGE_ICE_SERVER_INFO = "iceServerInfo"
+	SIGNALING_MESSAGE_CAN_OFFER       = "canOffer"
+	SIGNALING_MESSAGE_CLOSE           = "close"
+	SIGNALING_MESSAGE_OFFER           = "offer"
+	SIGNALING_MESSAGE_ANSWER          = "answer"
+)
+
+type SignalingMessage interface {
+	Type() string
+	validate() error
+}
+
+type SignalingMessageHeader struct {
+	MessageType string `json:"messageType"`
+	Version     int    `json:"version,omitempty"`
+}
+
+func (h *SignalingMessageHeader) Type() string {
+	return h.MessageType
+}
+
+func newSignalingMessageHeader(messageType string) SignalingMessageHeader {
+	return SignalingMessageHeader{
+		MessageType: messageType,
+		Version:     SIGNALING_PROTOCOL_VERSION,
+	}
+}
+
+type PingMessage struct {
+	SignalingMessageHeader
+}
+
+type PongMessage struct {
+	SignalingMessageHeader
+}
+
+type ICEServerInfo struct {
+	ICEServers []webrtc.ICEServer `json:"iceServers"`
+}
+
+type ICEServerInfoMessage struct {
+	SignalingMessageHeader
+	ICEServerInfo *ICEServerInfo `json:"iceServerInfo"`
+}
+
+type CanOfferMessage struct {
+	SignalingMessageHeader
+	PeerConnectionId string `json:"peerConnectionId"`
+	IsPrimary        *bool  `json:"isPrimary"`
+	DroneId          string `json:"droneId,omitempty"`
+}
+
+type CanOfferReplyMessage struct {
+	SignalingMessageHeader
+	PeerConnectionId string `json:"peerConnectionId"`
+	State            string `json:"state"`
+	DroneId          string `json:"droneId,omitempty"`
+}
+
+type CloseMessage struct {
+	SignalingMessageHeader
+	PeerConnectionId string `json:"peerConnectionId"`
+	IsPrimary        *bool  `json:"isPrimary"`
+	DroneId          string `json:"droneId,omitempty"`
+}
+
+type OfferMessage struct {
+	SignalingMessageHeader
+	PeerConnectionId string                     `json:"peerConnectionId"`
+	Offer            *webrtc.SessionDescription `json:"offer"`
+	DroneId          string                     `json:"droneId,omitempty"`
+}
+
+type AnswerDescription struct {
+	SDP  string `json:"sdp"`
+	Type string `json:"type"`
+}
+
+type AnswerMessage struct {
+	SignalingMessageHeader
+	PeerConnectionId string             `json:"peerConnectionId"`
+	Err              bool               `json:"err"`
+	Answer           *AnswerDescription `json:"answer,omitempty"`
+	DroneId          string             `json:"droneId,omitempty"`
+}
+
+// 'droneId' in 'canOffer', 'close' and 'offer' selects the drone the peer connects to.
+// It can be omitted when the application flies a single drone, in which case the default drone is selected.
+// The replies echo it back.
+type PeerType struct {
+	PeerConnectionId string
+	IsPrimary        bool
+	DroneId          string
+}
+
+// Decodes a message from the signaling server into the struct corresponding to its 'messageType'.
+// Unknown message types, unsupported versions and missing required fields are rejected.
+// Unknown fields are ignored so that the signaling server can add fields without breaking this application.
+func DecodeSignalingMessage(raw []byte) (SignalingMessage, error) {
+	var header SignalingMessageHeader
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, fmt.Errorf("signaling message is not a valid JSON object. %v", err)
+	}
+
+	if header.Version > SIGNALING_PROTOCOL_VERSION {
+		return nil, fmt.Errorf("signaling message version %v is not supported. The supported version is %v", header.Version, SIGNALING_PROTOCOL_VERSION)
+	}
+
+	var message SignalingMessage
+	switch header.MessageType {
+	case SIGNALING_MESSAGE_PING:
+		message = &PingMessage{}
+	case SIGNALING_MESSAGE_ICE_SERVER_INFO:
+		message = &ICEServerInfoMessage{}
+	case SIGNALING_MESSAGE_CAN_OFFER:
+		message = &CanOfferMessage{}
+	case SIGNALING_MESSAGE_CLOSE:
+		message = &CloseMessage{}
+	case SIGNALING_MESSAGE_OFFER:
+		message = &OfferMessage{}
+	case "":
+		return nil, fmt.Errorf("signaling message has no messageType")
+	default:
+		return nil, fmt.Errorf("unknown signaling messageType '%v'", header.MessageType)
+	}
+
+	if err := json.Unmarshal(raw, message); err != nil {
+		return nil, fmt.Errorf("malformed '%v' message. %v", header.MessageType, err)
+	}
+
+	if err := message.validate(); err != nil {
+		return nil, fmt.Errorf("invalid '%v' message. %v", header.MessageType, err)
+	}
+
+	return message, nil
+}
+
+func (m *PingMessage) validate() error {
+	return nil
+}
+
+func (m *ICEServerInfoMessage) validate() error {
+	return nil
+}
+
+func (m *CanOfferMessage) validate() error {
+	return validatePeer(m.PeerConnectionId, m.IsPrimary)
+}
+
+func (m *CloseMessage) validate() error {
+	return validatePeer(m.PeerConnectionId, m.IsPrimary)
+}
+
+func (m *OfferMessage) validate() error {
+	if m.PeerConnectionId == "" {
+		return fmt.Errorf("peerConnectionId is required")
+	}
+	if m.Offer == nil || m.Offer.SDP == "" {
+		return fmt.Errorf("offer is required")
+	}
+	return nil
+}
+
+func validatePeer(peerConnectionId string, isPrimary *bool) error {
+	if peerConnectionId == "" {
+		return fmt.Errorf("peerConnectionId is required")
+	}
+	if isPrimary == nil {
+		return fmt.Errorf("isPrimary is required")
+	}
+	return nil
+}
+
+func (m *ICEServerInfoMessage) ToConfiguration() *webrtc.Configuration {
+	config := webrtc.Configuration{}
+	if m.ICEServerInfo != nil {
+		config.ICEServers = m.ICEServerInfo.ICEServers
+	}
+	return &config
+}
+
+func (m *CanOfferMessage) ToPeerType() PeerType {
+	return PeerType{
+		PeerConnectionId: m.PeerConnectionId,
+		IsPrimary:        *m.IsPrimary,
+		DroneId:          m.DroneId,
+	}
+}
+
+func (m *CloseMessage) ToPeerType() PeerType {
+	return PeerType{
+		PeerConnectionId: m.PeerConnectionId,
+		IsPrimary:        *m.IsPrimary,
+		DroneId:          m.DroneId,
+	}
+}
+
+func NewPongMessage() PongMessage {
+	return PongMessage{
+		SignalingMessageHeader: newSignalingMessageHeader(SIGNALING_MESSAGE_PONG),
+	}
+}
+
+func NewCanOfferReplyMessage(droneId string, peerConnectionId string, state string) CanOfferReplyMessage {
+	return CanOfferReplyMessage{
+		SignalingMessageHeader: newSignalingMessageHeader(SIGNALING_MESSAGE_CAN_OFFER),
+		PeerConnectionId:       peerConnectionId,
+		State:                  state,
+		DroneId:                droneId,
+	}
+}
+
+func NewAnswerMessage(droneId string, peerConnectionId string, localDescription *webrtc.SessionDescription) AnswerMessage {
+	return AnswerMessage{
+		SignalingMessageHeader: newSignalingMessageHeader(SIGNALING_MESSAGE_ANSWER),
+		PeerConnectionId:       peerConnectionId,
+		DroneId:                droneId,
+		Err:                    false,
+		Answer: &AnswerDescription{
+			SDP:  localDescription.SDP,
+			Type: localDescription.Type.String(),
+		},
+	}
+}
+
+func NewErrorAnswerMessage(droneId string, peerConnectionId string) AnswerMessage {
+	return AnswerMessage{
+		SignalingMessageHeader: newSignalingMessageHeader(SIGNALING_MESSAGE_ANSWER),
+		PeerConnectionId:       peerConnectionId,
+		DroneId:                droneId,
+		Err:                    true,
+	}
+}
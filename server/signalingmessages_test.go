@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDecodeSignalingMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		isValid bool
+	}{
+		{"ping", `{"messageType":"ping"}`, true},
+		{"unknown fields", `{"messageType":"canOffer","peerConnectionId":"p1","isPrimary":true,"roomId":"r1","extra":{"a":1}}`, true},
+		{"version 1", `{"messageType":"close","version":1,"peerConnectionId":"p1","isPrimary":false}`, true},
+		{"offer", `{"messageType":"offer","peerConnectionId":"p1","offer":{"type":"offer","sdp":"v=0"},"turn":"x"}`, true},
+		{"not JSON", `messageType`, false},
+		{"no messageType", `{"peerConnectionId":"p1"}`, false},
+		{"unknown messageType", `{"messageType":"hello"}`, false},
+		{"unsupported version", `{"messageType":"ping","version":2}`, false},
+		{"no peerConnectionId", `{"messageType":"canOffer","isPrimary":true}`, false},
+		{"no isPrimary", `{"messageType":"close","peerConnectionId":"p1"}`, false},
+		{"no offer", `{"messageType":"offer","peerConnectionId":"p1"}`, false},
+		{"wrong type", `{"messageType":"canOffer","peerConnectionId":1,"isPrimary":true}`, false},
+	}
+
+	for _, test := range tests {
+		message, err := DecodeSignalingMessage([]byte(test.raw))
+		if test.isValid && err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		}
+		if !test.isValid && err == nil {
+			t.Errorf("%v: %T is decoded from an invalid message", test.name, message)
+		}
+	}
+}
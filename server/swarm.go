@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/st-user/ojm-drone-local/applog"
+)
+
+const (
+	// A drone which waits at a barrier longer than this fails the choreography.
+	swarmBarrierTimeout      = 60 * time.Second
+	swarmHealthCheckInterval = 1 * time.Second
+)
+
+var swarmRunner *SwarmRunner
+
+// A timed flight plan for several drones uploaded to '/cgi/swarm'.
+// Each drone has its own steps, which are the same as the ones of a mission plus 'sync'.
+// The timing is given by the durations of the steps and 'sync' barriers.
+type Choreography struct {
+	Name   string                   `json:"name"`
+	Drones map[string][]MissionStep `json:"drones"`
+}
+
+// Validates the steps of each drone against the safety envelope.
+// The drones have to pass the same barriers in the same order. Otherwise, they wait for each other forever.
+func (c *Choreography) Validate(fleet *DroneFleet, config SafetyEnvelopeConfig) error {
+	if len(c.Drones) == 0 {
+		return fmt.Errorf("choreography has no drones")
+	}
+
+	var expectedBarriers []string
+	expectedBarriersOf := ""
+	for _, droneId := range c.DroneIds() {
+		if droneId == "" {
+			return fmt.Errorf("drone id is required")
+		}
+		if _, err := fleet.Get(droneId); err != nil {
+			return err
+		}
+
+		steps := c.Drones[droneId]
+		if err := validateMissionSteps(steps, config, true); err != nil {
+			return fmt.Errorf("drone '%v': %v", droneId, err)
+		}
+
+		barriers := []string{}
+		passed := make(map[string]bool)
+		for _, step := range steps {
+			if step.Type != MISSION_STEP_SYNC {
+				continue
+			}
+			if passed[step.Barrier] {
+				return fmt.Errorf("drone '%v': barrier '%v' is duplicated", droneId, step.Barrier)
+			}
+			passed[step.Barrier] = true
+			barriers = append(barriers, step.Barrier)
+		}
+
+		if expectedBarriers == nil {
+			expectedBarriers = barriers
+			expectedBarriersOf = droneId
+			continue
+		}
+		if fmt.Sprint(barriers) != fmt.Sprint(expectedBarriers) {
+			return fmt.Errorf("drone '%v' passes barriers %v but drone '%v' passes %v", droneId, barriers, expectedBarriersOf, expectedBarriers)
+		}
+	}
+	return nil
+}
+
+func (c *Choreography) DroneIds() []string {
+	ids := []string{}
+	for id := range c.Drones {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// The aggregate status of a choreography published as 'swarm' in 'appInfo'.
+type SwarmStatus struct {
+	Name string `json:"name"`
+	// One of MISSION_STATE_*. 'paused' is not used.
+	State  string             `json:"state"`
+	Error  string             `json:"error,omitempty"`
+	Drones []SwarmDroneStatus `json:"drones"`
+}
+
+type SwarmDroneStatus struct {
+	DroneId string `json:"droneId"`
+	MissionStatus
+	// The number of steps the drone has completed.
+	Acknowledged int `json:"acknowledged"`
+}
+
+// SwarmRunner flies a choreography. Each drone executes its steps in parallel with its MissionRunner,
+// which acknowledges a step when the drone reports that it is completed.
+// If any drone fails, becomes unhealthy or is aborted, all the drones hover and then land.
+// A choreography is a run of its RoutineCoordinator.
+type SwarmRunner struct {
+	fleet              *DroneFleet
+	applicationStates  *ApplicationStates
+	routineCoordinator *RoutineCoordinator
+	status             SwarmStatus
+	isRunning          bool
+	abortChannel       chan struct{}
+	abortOnce          *sync.Once
+	mutex              sync.Mutex
+}
+
+func NewSwarmRunner(fleet *DroneFleet, applicationStates *ApplicationStates) *SwarmRunner {
+	s := &SwarmRunner{
+		fleet:              fleet,
+		applicationStates:  applicationStates,
+		routineCoordinator: NewRoutineCoordinator(),
+		status: SwarmStatus{
+			State:  MISSION_STATE_IDLE,
+			Drones: []SwarmDroneStatus{},
+		},
+	}
+	s.applicationStates.SetSwarmStatus(s.status)
+	return s
+}
+
+func (s *SwarmRunner) Start(choreography Choreography) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.isRunning {
+		return fmt.Errorf("another choreography is running")
+	}
+
+	units := []*DroneUnit{}
+	for _, droneId := range choreography.DroneIds() {
+		unit, err := s.fleet.Get(droneId)
+		if err != nil {
+			return err
+		}
+		if health := unit.applicationStates.GetDroneHealth().DroneHealth; health != DRONE_HEALTH_OK {
+			return fmt.Errorf("drone '%v' is not healthy", droneId)
+		}
+		units = append(units, unit)
+	}
+
+	for i, unit := range units {
+		if err := unit.missionRunner.acquire(); err != nil {
+			for _, acquired := range units[:i] {
+				acquired.missionRunner.release()
+			}
+			return fmt.Errorf("drone '%v': %v", unit.Id, err)
+		}
+	}
+
+	s.isRunning = true
+	s.abortChannel = make(chan struct{})
+	s.abortOnce = &sync.Once{}
+	s.status = SwarmStatus{
+		Name:   choreography.Name,
+		State:  MISSION_STATE_RUNNING,
+		Drones: []SwarmDroneStatus{},
+	}
+	for _, unit := range units {
+		s.status.Drones = append(s.status.Drones, SwarmDroneStatus{
+			DroneId: unit.Id,
+			MissionStatus: MissionStatus{
+				Name:      choreography.Name,
+				State:     MISSION_STATE_RUNNING,
+				StepCount: len(choreography.Drones[unit.Id]),
+			},
+		})
+	}
+	s.applicationStates.SetSwarmStatus(s.status)
+
+	s.routineCoordinator.Start()
+	s.routineCoordinator.Go("choreography", func(ctx context.Context) error {
+		s.run(ctx, choreography, units)
+		return nil
+	})
+	return nil
+}
+
+// Waits until the routines of the choreography return.
+func (s *SwarmRunner) Wait() {
+	s.routineCoordinator.Wait()
+}
+
+// Aborts the running choreography. All the drones hover and then land.
+func (s *SwarmRunner) Abort() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.isRunning {
+		return fmt.Errorf("no choreography is running")
+	}
+	s.abortLocked("aborted by the operator")
+	return nil
+}
+
+func (s *SwarmRunner) run(ctx context.Context, choreography Choreography, units []*DroneUnit) {
+	applog.Info("Choreography '%v' starts with %v drones.", choreography.Name, len(units))
+
+	barrier := newSwarmBarrier(len(units))
+	healthCtx, stopHealthCheck := context.WithCancel(ctx)
+	s.routineCoordinator.Go("choreography health check", func(_ context.Context) error {
+		s.checkHealth(healthCtx, units)
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for i, unit := range units {
+		i, unit := i, unit
+		wg.Add(1)
+		started := s.routineCoordinator.Go("choreography drone '"+unit.Id+"'", func(_ context.Context) error {
+			defer wg.Done()
+			if !s.runDrone(i, unit, choreography.Drones[unit.Id], barrier) {
+				s.land(unit)
+			}
+			// Released as soon as its part ends so that it can fly another mission.
+			unit.missionRunner.release()
+			return nil
+		})
+		if !started {
+			wg.Done()
+		}
+	}
+	wg.Wait()
+	stopHealthCheck()
+
+	s.mutex.Lock()
+	isAborted := s.status.State != MISSION_STATE_RUNNING
+	if !isAborted {
+		s.status.State = MISSION_STATE_COMPLETED
+	}
+	s.applicationStates.SetSwarmStatus(s.status)
+	s.mutex.Unlock()
+
+	if isAborted {
+		// A drone which had finished its steps before another one failed is still in the air.
+		for _, unit := range units {
+			s.land(unit)
+		}
+	} else {
+		applog.Info("Choreography '%v' is completed.", choreography.Name)
+	}
+
+	// Stopped before the next choreography can start so that it does not cancel the next one.
+	s.routineCoordinator.StopApp()
+	s.mutex.Lock()
+	s.isRunning = false
+	s.mutex.Unlock()
+}
+
+// Returns false if the drone fails or is aborted.
+func (s *SwarmRunner) runDrone(index int, unit *DroneUnit, steps []MissionStep, barrier *swarmBarrier) bool {
+	runner := unit.missionRunner
+	config := NewSafetyEnvelopeConfig()
+	e := &missionExecution{
+		status:         s.droneStatus(index).MissionStatus,
+		controlChannel: unit.routineCoordinator.MissionControlChannel,
+		stopChannel:    unit.routineCoordinator.Context().Done(),
+		abortChannel:   s.abortChannel,
+	}
+
+	for i, step := range steps {
+		e.status.Step = i + 1
+		s.updateDroneStatus(index, unit, e.status, i)
+
+		var err error
+		if step.Type == MISSION_STEP_SYNC {
+			err = s.waitBarrier(runner, e, barrier, step.Barrier)
+		} else {
+			err = runner.executeStep(step, config, e)
+		}
+
+		if err != nil {
+			runner.hover()
+			if err == errMissionAborted {
+				e.status.State = MISSION_STATE_ABORTED
+				s.abort(fmt.Sprintf("drone '%v' is aborted at step %v", unit.Id, i+1))
+			} else {
+				e.status.State = MISSION_STATE_FAILED
+				e.status.Error = err.Error()
+				s.abort(fmt.Sprintf("drone '%v' fails at step %v. %v", unit.Id, i+1, err))
+			}
+			s.updateDroneStatus(index, unit, e.status, i)
+			return false
+		}
+		s.updateDroneStatus(index, unit, e.status, i+1)
+	}
+
+	e.status.State = MISSION_STATE_COMPLETED
+	s.updateDroneStatus(index, unit, e.status, len(steps))
+	return true
+}
+
+// Lands the drone of the aborted choreography if it is in the air.
+func (s *SwarmRunner) land(unit *DroneUnit) {
+	switch unit.applicationStates.GetFlightState() {
+	case FLIGHT_STATE_TAKING_OFF, FLIGHT_STATE_FLYING, FLIGHT_STATE_FAILSAFE:
+		applog.Warn("Drone '%v' lands because the choreography is aborted.", unit.Id)
+		RequestLanding(unit.routineCoordinator)
+	}
+}
+
+// Waits until all the drones reach the barrier while watching the flight state and aborts as a step does.
+func (s *SwarmRunner) waitBarrier(runner *MissionRunner, e *missionExecution, barrier *swarmBarrier, name string) error {
+	released := barrier.arrive(name)
+	completed, err := runner.wait(e, swarmBarrierTimeout, func() bool {
+		select {
+		case <-released:
+			return true
+		default:
+			return false
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if !completed {
+		return fmt.Errorf("the other drones do not reach barrier '%v'", name)
+	}
+	return nil
+}
+
+// Aborts the choreography if any drone becomes unhealthy, using the result of the health check of each Drone.
+func (s *SwarmRunner) checkHealth(ctx context.Context, units []*DroneUnit) {
+	ticker := time.NewTicker(swarmHealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, unit := range units {
+				if unit.applicationStates.GetDroneHealth().DroneHealth == DRONE_HEALTH_NG {
+					s.abort(fmt.Sprintf("drone '%v' is unhealthy", unit.Id))
+				}
+			}
+		}
+	}
+}
+
+func (s *SwarmRunner) abort(reason string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.abortLocked(reason)
+}
+
+func (s *SwarmRunner) abortLocked(reason string) {
+	s.abortOnce.Do(func() {
+		applog.Warn("Choreography '%v' is aborted. All the drones hover and land. %v", s.status.Name, reason)
+		s.status.State = MISSION_STATE_ABORTED
+		s.status.Error = reason
+		s.applicationStates.SetSwarmStatus(s.status)
+		close(s.abortChannel)
+	})
+}
+
+func (s *SwarmRunner) droneStatus(index int) SwarmDroneStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.status.Drones[index]
+}
+
+func (s *SwarmRunner) updateDroneStatus(index int, unit *DroneUnit, status MissionStatus, acknowledged int) {
+	unit.applicationStates.SetMissionStatus(status)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	drones := append([]SwarmDroneStatus(nil), s.status.Drones...)
+	drones[index].MissionStatus = status
+	drones[index].Acknowledged = acknowledged
+	s.status.Drones = drones
+	s.applicationStates.SetSwarmStatus(s.status)
+}
+
+// swarmBarrier releases the drones waiting at a barrier when all of them reach it.
+type swarmBarrier struct {
+	count    int
+	arrived  map[string]int
+	released map[string]chan struct{}
+	mutex    sync.Mutex
+}
+
+func newSwarmBarrier(count int) *swarmBarrier {
+	return &swarmBarrier{
+		count:    count,
+		arrived:  make(map[string]int),
+		released: make(map[string]chan struct{}),
+	}
+}
+
+// Returns the channel closed when all the drones reach the barrier.
+func (b *swarmBarrier) arrive(name string) chan struct{} {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	released, ok := b.released[name]
+	if !ok {
+		released = make(chan struct{})
+		b.released[name] = released
+	}
+	b.arrived[name]++
+	if b.arrived[name] == b.count {
+		close(released)
+	}
+	return released
+}
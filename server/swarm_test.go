@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestSwarmBarrier(t *testing.T) {
+	isReleased := func(released chan struct{}) bool {
+		select {
+		case <-released:
+			return true
+		default:
+			return false
+		}
+	}
+
+	tests := []struct {
+		name  string
+		count int
+		// The barriers the drones arrive at in order.
+		arrivals []string
+		// Whether each barrier is released after all the arrivals.
+		expected map[string]bool
+	}{
+		{
+			name:     "a single drone passes immediately",
+			count:    1,
+			arrivals: []string{"a"},
+			expected: map[string]bool{"a": true},
+		},
+		{
+			name:     "waits for all the drones",
+			count:    3,
+			arrivals: []string{"a", "a"},
+			expected: map[string]bool{"a": false},
+		},
+		{
+			name:     "released when the last drone arrives",
+			count:    3,
+			arrivals: []string{"a", "a", "a"},
+			expected: map[string]bool{"a": true},
+		},
+		{
+			name:     "barriers are independent",
+			count:    2,
+			arrivals: []string{"a", "b", "a"},
+			expected: map[string]bool{"a": true, "b": false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newSwarmBarrier(tt.count)
+			released := map[string]chan struct{}{}
+			for _, name := range tt.arrivals {
+				ch := b.arrive(name)
+				if previous, ok := released[name]; ok && previous != ch {
+					t.Fatalf("the drones arriving at '%v' wait for different channels", name)
+				}
+				released[name] = ch
+			}
+			for name, expected := range tt.expected {
+				if actual := isReleased(released[name]); actual != expected {
+					t.Errorf("barrier '%v' released = %v, want %v", name, actual, expected)
+				}
+			}
+		})
+	}
+}
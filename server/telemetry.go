@@ -0,0 +1,43 @@
+package main
+
+import (
+	"time"
+
+	"github.com/st-user/ojm-drone-local/env"
+)
+
+const defaultTelemetryPublishInterval = 500 * time.Millisecond
+
+// The latest flight data published to the local UI and the primary peer.
+type Telemetry struct {
+	FlightData
+	// Unix time in milliseconds when the flight data is received. Zero if no flight data has been received.
+	Timestamp int64 `json:"timestamp"`
+}
+
+func NewTelemetry(flightData FlightData) Telemetry {
+	return Telemetry{
+		FlightData: flightData,
+		Timestamp:  time.Now().UnixNano() / int64(time.Millisecond),
+	}
+}
+
+func (t *Telemetry) IsEmpty() bool {
+	return t.Timestamp == 0
+}
+
+// Creates the message published over the application-state WebSocket and the DataChannel.
+func (t *Telemetry) ToMessage() map[string]interface{} {
+	return map[string]interface{}{
+		"messageType": "telemetry",
+		"telemetry":   t,
+	}
+}
+
+func TelemetryPublishInterval() time.Duration {
+	interval := env.GetDuration("TELEMETRY_PUBLISH_INTERVAL")
+	if interval <= 0 {
+		return defaultTelemetryPublishInterval
+	}
+	return interval
+}
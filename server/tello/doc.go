@@ -0,0 +1,8 @@
+// Package tello is a fork of gobot's DJI Tello driver (gobot.io/x/gobot/platforms/dji/tello v1.15.0,
+// licensed under the Apache License 2.0, see LICENSE.txt) with the changes the application depends on:
+//
+//   - NewDriverWithIP initializes the channel Halt signals, without which Halt blocks forever.
+//   - Halt stops all the loops of the driver including the one sending stick commands.
+//   - Emergency stops the motors.
+//   - TakePicture takes a picture, which is published with PictureEvent after it has been transferred.
+package tello
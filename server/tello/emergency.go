@@ -0,0 +1,13 @@
+package tello
+
+// Emergency stops the motors immediately. The drone falls if it is in the air.
+// The binary protocol has no command for this, so the drone is switched to the SDK mode which accepts 'emergency'.
+func (d *Driver) Emergency() (err error) {
+	d.cmdMutex.Lock()
+	defer d.cmdMutex.Unlock()
+
+	if err = d.SendCommand("command"); err != nil {
+		return
+	}
+	return d.SendCommand("emergency")
+}
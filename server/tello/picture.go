@@ -0,0 +1,154 @@
+package tello
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// PictureEvent event. Published with the JPEG bytes when a picture has been transferred.
+const PictureEvent = "picture"
+
+// the messages of the file transfer which follows a take picture command
+const (
+	takePictureCommand  = 0x0030 // 48
+	fileSizeMessage     = 0x0062 // 98
+	fileDataMessage     = 0x0063 // 99
+	fileCompleteCommand = 0x0064 // 100
+)
+
+// The Tello sends a file in chunks and expects an acknowledgement for each piece of this number of chunks.
+const fileChunksPerPiece = 8
+
+// a file the Tello is sending
+type fileTransfer struct {
+	size     int
+	received int
+	chunks   map[uint32][]byte
+}
+
+// TakePicture tells the drone to take a picture. The picture is published with PictureEvent after it has been transferred.
+func (d *Driver) TakePicture() (err error) {
+	d.cmdMutex.Lock()
+	defer d.cmdMutex.Unlock()
+
+	buf, _ := d.createPacket(takePictureCommand, 0x68, 0)
+	d.seq++
+	binary.Write(buf, binary.LittleEndian, d.seq)
+	binary.Write(buf, binary.LittleEndian, CalculateCRC16(buf.Bytes()))
+
+	_, err = d.cmdConn.Write(buf.Bytes())
+	return
+}
+
+// the Tello announces a file with its type, size and id
+func (d *Driver) handleFileSize(packet []byte) {
+	payload := packetPayload(packet)
+	if len(payload) < 7 {
+		return
+	}
+	size := binary.LittleEndian.Uint32(payload[1:5])
+	id := binary.LittleEndian.Uint16(payload[5:7])
+
+	d.files[id] = &fileTransfer{
+		size:   int(size),
+		chunks: make(map[uint32][]byte),
+	}
+	d.sendFilePacket(fileSizeMessage, 0x50, []byte{0})
+}
+
+// the Tello sends a chunk of a file with the id of the file, the piece and the chunk
+func (d *Driver) handleFileData(packet []byte) {
+	payload := packetPayload(packet)
+	if len(payload) < 12 {
+		return
+	}
+	id := binary.LittleEndian.Uint16(payload[0:2])
+	piece := binary.LittleEndian.Uint32(payload[2:6])
+	chunk := binary.LittleEndian.Uint32(payload[6:10])
+	length := int(binary.LittleEndian.Uint16(payload[10:12]))
+	if len(payload) < 12+length {
+		return
+	}
+
+	file, ok := d.files[id]
+	if !ok {
+		return
+	}
+	if _, ok := file.chunks[chunk]; !ok {
+		file.chunks[chunk] = append([]byte(nil), payload[12:12+length]...)
+		file.received += length
+	}
+
+	if file.received < file.size {
+		// acknowledged again if the chunk is resent because the acknowledgement is lost
+		if file.isPieceReceived(piece) {
+			d.sendFileDataAck(id, piece, false)
+		}
+		return
+	}
+
+	delete(d.files, id)
+	d.sendFileDataAck(id, piece, true)
+	complete := make([]byte, 6)
+	binary.LittleEndian.PutUint16(complete[0:2], id)
+	binary.LittleEndian.PutUint32(complete[2:6], uint32(file.size))
+	d.sendFilePacket(fileCompleteCommand, 0x48, complete)
+
+	d.Publish(d.Event(PictureEvent), file.data())
+}
+
+func (d *Driver) sendFileDataAck(id uint16, piece uint32, done bool) {
+	ack := make([]byte, 7)
+	if done {
+		ack[0] = 1
+	}
+	binary.LittleEndian.PutUint16(ack[1:3], id)
+	binary.LittleEndian.PutUint32(ack[3:7], piece)
+	d.sendFilePacket(fileDataMessage, 0x50, ack)
+}
+
+func (d *Driver) sendFilePacket(cmd int16, pktType byte, payload []byte) (err error) {
+	d.cmdMutex.Lock()
+	defer d.cmdMutex.Unlock()
+
+	buf, _ := d.createPacket(cmd, pktType, int16(len(payload)))
+	d.seq++
+	binary.Write(buf, binary.LittleEndian, d.seq)
+	buf.Write(payload)
+	binary.Write(buf, binary.LittleEndian, CalculateCRC16(buf.Bytes()))
+
+	_, err = d.cmdConn.Write(buf.Bytes())
+	return
+}
+
+// the bytes between the header and the CRC
+func packetPayload(packet []byte) []byte {
+	if len(packet) < 11 {
+		return nil
+	}
+	return packet[9 : len(packet)-2]
+}
+
+func (f *fileTransfer) isPieceReceived(piece uint32) bool {
+	for chunk := piece * fileChunksPerPiece; chunk < (piece+1)*fileChunksPerPiece; chunk++ {
+		if _, ok := f.chunks[chunk]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// the chunks in order
+func (f *fileTransfer) data() []byte {
+	indexes := make([]int, 0, len(f.chunks))
+	for chunk := range f.chunks {
+		indexes = append(indexes, int(chunk))
+	}
+	sort.Ints(indexes)
+
+	data := make([]byte, 0, f.size)
+	for _, chunk := range indexes {
+		data = append(data, f.chunks[uint32(chunk)]...)
+	}
+	return data
+}
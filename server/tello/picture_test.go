@@ -0,0 +1,132 @@
+package tello
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+)
+
+// records the packets the driver sends
+type recordingConn struct {
+	packets [][]byte
+	mutex   sync.Mutex
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.packets = append(c.packets, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func (c *recordingConn) Close() error { return nil }
+
+func (c *recordingConn) commands() []int16 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	commands := []int16{}
+	for _, p := range c.packets {
+		commands = append(commands, int16(binary.LittleEndian.Uint16(p[5:7])))
+	}
+	return commands
+}
+
+func telloPacket(d *Driver, cmd int16, payload []byte) []byte {
+	buf, _ := d.createPacket(cmd, 0x50, int16(len(payload)))
+	binary.Write(buf, binary.LittleEndian, int16(0))
+	buf.Write(payload)
+	binary.Write(buf, binary.LittleEndian, CalculateCRC16(buf.Bytes()))
+	return buf.Bytes()
+}
+
+func fileSizePayload(id uint16, size uint32) []byte {
+	payload := make([]byte, 7)
+	binary.LittleEndian.PutUint32(payload[1:5], size)
+	binary.LittleEndian.PutUint16(payload[5:7], id)
+	return payload
+}
+
+func fileDataPayload(id uint16, piece uint32, chunk uint32, data []byte) []byte {
+	payload := make([]byte, 12)
+	binary.LittleEndian.PutUint16(payload[0:2], id)
+	binary.LittleEndian.PutUint32(payload[2:6], piece)
+	binary.LittleEndian.PutUint32(payload[6:10], chunk)
+	binary.LittleEndian.PutUint16(payload[10:12], uint16(len(data)))
+	return append(payload, data...)
+}
+
+func TestPictureTransfer(t *testing.T) {
+	d := NewDriver("8888")
+	conn := &recordingConn{}
+	d.cmdConn = conn
+
+	pictures := make(chan []byte, 1)
+	d.On(PictureEvent, func(data interface{}) {
+		pictures <- data.([]byte)
+	})
+
+	// 9 chunks, i.e. 2 pieces, the last of which has only one chunk
+	chunks := [][]byte{}
+	expected := []byte{}
+	for i := 0; i < 9; i++ {
+		chunk := bytes.Repeat([]byte{byte(i)}, 10+i)
+		chunks = append(chunks, chunk)
+		expected = append(expected, chunk...)
+	}
+
+	receive := func(cmd int16, payload []byte) {
+		if err := d.handleResponse(bytes.NewReader(telloPacket(d, cmd, payload))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	receive(fileSizeMessage, fileSizePayload(3, uint32(len(expected))))
+	// out of order and with a resent chunk
+	for _, i := range []int{1, 0, 2, 3, 4, 5, 6, 7, 7} {
+		receive(fileDataMessage, fileDataPayload(3, 0, uint32(i), chunks[i]))
+	}
+	receive(fileDataMessage, fileDataPayload(3, 1, 8, chunks[8]))
+
+	select {
+	case picture := <-pictures:
+		if !bytes.Equal(picture, expected) {
+			t.Errorf("picture = %v, want %v", picture, expected)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("picture is not published")
+	}
+
+	// the size ack, the first piece acked twice, the last piece acked with done and the completion
+	want := []int16{fileSizeMessage, fileDataMessage, fileDataMessage, fileDataMessage, fileCompleteCommand}
+	got := conn.commands()
+	if len(got) != len(want) {
+		t.Fatalf("sent %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sent %v, want %v", got, want)
+		}
+	}
+	if done := conn.packets[3][9]; done != 1 {
+		t.Errorf("the last ack has done %v, want 1", done)
+	}
+	if _, ok := d.files[3]; ok {
+		t.Error("the transfer is left after it is completed")
+	}
+}
+
+func TestFileDataOfUnknownFileIsIgnored(t *testing.T) {
+	d := NewDriver("8888")
+	conn := &recordingConn{}
+	d.cmdConn = conn
+
+	packet := telloPacket(d, fileDataMessage, fileDataPayload(5, 0, 0, []byte{1, 2, 3}))
+	if err := d.handleResponse(bytes.NewReader(packet)); err != nil {
+		t.Fatal(err)
+	}
+	if commands := conn.commands(); len(commands) != 0 {
+		t.Errorf("sent %v for an unknown file", commands)
+	}
+}
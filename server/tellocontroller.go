@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/st-user/ojm-drone-local/applog"
+	"github.com/st-user/ojm-drone-local/tello"
+	"gobot.io/x/gobot"
+)
+
+type TelloFlightController struct {
+	driver *tello.Driver
+	robot  *gobot.Robot
+	// The Tello sends these separately from the flight data.
+	wifiStrength  int32
+	lightStrength int32
+	// Accessed by the methods called from the command loop.
+	rotationTimer      *time.Timer
+	rotationEndsAt     time.Time
+	isRotating         bool
+	rotationEndHandler func()
+}
+
+// The protocol gobot speaks has no command to rotate by degrees.
+// The Tello rotates at 'telloRotationSpeed' for the time estimated from its yaw rate at that speed.
+const (
+	telloRotationSpeed    = 50   // :%
+	telloEstimatedYawRate = 90.0 // :deg/s
+)
+
+// Returns the factory of the FlightController for the Tello at the address.
+func NewTelloFlightControllerFactory(config DroneConfig) FlightControllerFactory {
+	return func() FlightController {
+		return NewTelloFlightControllerWithDriver(tello.NewDriverWithIP(config.Host, config.LocalPort))
+	}
+}
+
+func NewTelloFlightControllerWithDriver(driver *tello.Driver) FlightController {
+	c := &TelloFlightController{
+		driver: driver,
+	}
+
+	var once sync.Once
+	driver.On(tello.ConnectedEvent, func(data interface{}) {
+		once.Do(func() {
+			applog.Info("Starts receiving video frames from your drone.")
+			driver.StartVideo()
+			driver.SetVideoEncoderRate(tello.VideoBitRate1M)
+			gobot.Every(10*time.Second, func() {
+				driver.StartVideo()
+			})
+		})
+	})
+
+	return c
+}
+
+func (c *TelloFlightController) Connect() error {
+	c.robot = gobot.NewRobot(
+		[]gobot.Connection{},
+		[]gobot.Device{c.driver},
+	)
+	c.robot.AutoRun = false
+	return c.robot.Start()
+}
+
+func (c *TelloFlightController) Disconnect() error {
+	c.stopRotationTimer()
+	if c.robot == nil {
+		return nil
+	}
+	return c.robot.Stop()
+}
+
+func (c *TelloFlightController) TakeOff() error {
+	return c.driver.TakeOff()
+}
+
+func (c *TelloFlightController) Land() error {
+	return c.driver.Land()
+}
+
+func (c *TelloFlightController) Emergency() error {
+	return c.driver.Emergency()
+}
+
+func (c *TelloFlightController) ThrowTakeOff() error {
+	return c.driver.ThrowTakeOff()
+}
+
+func (c *TelloFlightController) PalmLand() error {
+	return c.driver.PalmLand()
+}
+
+func (c *TelloFlightController) Flip(direction string) error {
+	switch direction {
+	case FLIP_DIRECTION_FRONT:
+		return c.driver.Flip(tello.FlipFront)
+	case FLIP_DIRECTION_BACK:
+		return c.driver.Flip(tello.FlipBack)
+	case FLIP_DIRECTION_LEFT:
+		return c.driver.Flip(tello.FlipLeft)
+	case FLIP_DIRECTION_RIGHT:
+		return c.driver.Flip(tello.FlipRight)
+	}
+	return fmt.Errorf("invalid flip direction '%v'", direction)
+}
+
+func (c *TelloFlightController) Bounce() error {
+	return c.driver.Bounce()
+}
+
+func (c *TelloFlightController) Hover() error {
+	c.stopRotationTimer()
+	c.driver.Hover()
+	return nil
+}
+
+func (c *TelloFlightController) Rotate(degrees int) error {
+	c.stopRotationTimer()
+
+	var err error
+	if 0 <= degrees {
+		err = c.driver.Clockwise(telloRotationSpeed)
+	} else {
+		err = c.driver.CounterClockwise(telloRotationSpeed)
+	}
+	if err != nil {
+		return err
+	}
+
+	duration := time.Duration(math.Abs(float64(degrees)) / telloEstimatedYawRate * float64(time.Second))
+	c.isRotating = true
+	c.rotationEndsAt = time.Now().Add(duration)
+	// The handler asks the command loop to call CeaseRotation, so that it does not race with the other commands.
+	if handler := c.rotationEndHandler; handler != nil {
+		c.rotationTimer = time.AfterFunc(duration, handler)
+	}
+	return nil
+}
+
+// A notification of the rotation replaced before it arrives is ignored because the new one has not ended yet.
+func (c *TelloFlightController) CeaseRotation() error {
+	if !c.isRotating || time.Now().Before(c.rotationEndsAt) {
+		return nil
+	}
+	c.isRotating = false
+	c.driver.CeaseRotation()
+	return nil
+}
+
+// Cancels the rotation. The caller replaces the yaw the rotation has set.
+func (c *TelloFlightController) stopRotationTimer() {
+	if c.rotationTimer != nil {
+		c.rotationTimer.Stop()
+	}
+	c.isRotating = false
+}
+
+func (c *TelloFlightController) OnRotationEnd(handler func()) {
+	c.rotationEndHandler = handler
+}
+
+func (c *TelloFlightController) SetFastMode(isFast bool) error {
+	if isFast {
+		return c.driver.SetFastMode()
+	}
+	return c.driver.SetSlowMode()
+}
+
+func (c *TelloFlightController) TakePicture() error {
+	return c.driver.TakePicture()
+}
+
+// The driver publishes the JPEG when the Tello has transferred it, a few seconds after TakePicture.
+func (c *TelloFlightController) OnPicture(handler func(data []byte)) {
+	c.driver.On(tello.PictureEvent, func(data interface{}) {
+		handler(data.([]byte))
+	})
+}
+
+func (c *TelloFlightController) SetVector(mVec MotionVector) error {
+	c.stopRotationTimer()
+	return c.driver.SetVector(mVec.Y, mVec.X, mVec.Z, mVec.R)
+}
+
+func (c *TelloFlightController) StartVideo() error {
+	return c.driver.StartVideo()
+}
+
+func (c *TelloFlightController) SetVideoBitRate(bitrateMB float64) (float64, error) {
+	var rate tello.VideoBitRate
+	var changeTo float64
+
+	switch {
+	case bitrateMB >= 4.0:
+		rate = tello.VideoBitRate4M
+		changeTo = 4.0
+	case bitrateMB >= 3.0:
+		rate = tello.VideoBitRate3M
+		changeTo = 3.0
+	case bitrateMB >= 2.0:
+		rate = tello.VideoBitRate2M
+		changeTo = 2.0
+	case bitrateMB >= 1.5:
+		rate = tello.VideoBitRate15M
+		changeTo = 1.5
+	default:
+		rate = tello.VideoBitRate1M
+		changeTo = 1
+	}
+	return changeTo, c.driver.SetVideoEncoderRate(rate)
+}
+
+func (c *TelloFlightController) OnFlightData(handler func(flightData FlightData)) {
+	c.driver.On(tello.WifiDataEvent, func(data interface{}) {
+		wd := data.(*tello.WifiData)
+		atomic.StoreInt32(&c.wifiStrength, int32(wd.Strength))
+	})
+
+	c.driver.On(tello.LightStrengthEvent, func(data interface{}) {
+		switch strength := data.(type) {
+		case byte:
+			atomic.StoreInt32(&c.lightStrength, int32(strength))
+		case int8:
+			atomic.StoreInt32(&c.lightStrength, int32(strength))
+		}
+	})
+
+	c.driver.On(tello.FlightDataEvent, func(data interface{}) {
+		fd := data.(*tello.FlightData)
+
+		// The Tello reports heights and speeds in decimeters, fly time in 0.1 seconds
+		// and the battery voltage in millivolts.
+		handler(FlightData{
+			BatteryPercentage: int(fd.BatteryPercentage),
+			BatteryVoltage:    float64(fd.DroneBatteryLeft) / 1000.0,
+			BatteryLow:        fd.BatteryLow || fd.BatteryLower,
+			Height:            float64(fd.Height) / 10.0,
+			GroundSpeed:       fd.GroundSpeed() / 10.0,
+			VerticalSpeed:     float64(fd.VerticalSpeed) / 10.0,
+			NorthSpeed:        float64(fd.NorthSpeed) / 10.0,
+			EastSpeed:         float64(fd.EastSpeed) / 10.0,
+			FlyMode:           int(fd.FlyMode),
+			Flying:            fd.Flying,
+			OnGround:          fd.OnGround,
+			WifiStrength:      int(atomic.LoadInt32(&c.wifiStrength)),
+			TemperatureHigh:   fd.TemperatureHigh,
+			ImuOk:             fd.ImuState,
+			WindWarning:       fd.WindState,
+			LightWarning:      atomic.LoadInt32(&c.lightStrength) != 0,
+			FlightTime:        float64(fd.FlyTime) / 10.0,
+		})
+	})
+}
+
+func (c *TelloFlightController) OnVideoFrame(handler func(data []byte)) {
+	c.driver.On(tello.VideoFrameEvent, func(data interface{}) {
+		handler(data.([]byte))
+	})
+}
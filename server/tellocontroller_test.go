@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/st-user/ojm-drone-local/tello"
+)
+
+func TestTelloFlightControllerCeasesTheLatestRotation(t *testing.T) {
+	c := NewTelloFlightControllerWithDriver(tello.NewDriverWithIP("127.0.0.1", "0")).(*TelloFlightController)
+	ended := make(chan struct{}, 4)
+	c.OnRotationEnd(func() {
+		ended <- struct{}{}
+	})
+	waitForRotationEnd := func() {
+		t.Helper()
+		select {
+		case <-ended:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the end of the rotation is not notified")
+		}
+	}
+
+	// 9 degrees take 100ms.
+	c.Rotate(9)
+	waitForRotationEnd()
+	c.Rotate(-9)
+	c.CeaseRotation()
+	if !c.isRotating {
+		t.Fatal("the notification of the previous rotation ceases the current one")
+	}
+	waitForRotationEnd()
+	c.CeaseRotation()
+	if c.isRotating {
+		t.Fatal("the rotation does not cease")
+	}
+
+	// A motion vector replaces the rotation.
+	c.Rotate(9)
+	c.SetVector(MotionVector{R: 0.5})
+	select {
+	case <-ended:
+		t.Fatal("the end of the replaced rotation is notified")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	c.Rotate(9)
+	c.Disconnect()
+	select {
+	case <-ended:
+		t.Fatal("the end of the rotation is notified after disconnecting")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/st-user/ojm-drone-local/applog"
+)
+
+// The ports of the Tello SDK 2.0 text protocol.
+// Every drone sends its state and video to the same local ports, so they are received by listeners
+// shared among the drones and dispatched by the address of the drone.
+const (
+	telloSDKCommandPort = 8889
+	telloSDKStatePort   = 8890
+	telloSDKVideoPort   = 11111
+)
+
+const (
+	telloSDKResponseTimeout = 5 * time.Second
+	// The Tello lands automatically if it receives no command for 15 seconds.
+	telloSDKKeepAliveInterval = 5 * time.Second
+	telloSDKFastRCScale       = 100
+	telloSDKSlowRCScale       = 50
+)
+
+// TelloSDKFlightController flies a Tello with the SDK 2.0 text commands instead of gobot's binary protocol.
+// Unlike gobot, it does not depend on the drone's own access point, so Tello EDUs joined to a shared router
+// in station mode can be flown.
+// The SDK has no commands for bounce, palm landing, throw takeoff, pictures and the video bit rate.
+type TelloSDKFlightController struct {
+	config            DroneConfig
+	conn              *net.UDPConn
+	droneAddr         *net.UDPAddr
+	responseChannel   chan string
+	stopChannel       chan struct{}
+	lastSentAt        int64
+	rcScale           int32
+	flightDataHandler func(flightData FlightData)
+	videoFrameHandler func(data []byte)
+	mutex             sync.Mutex
+}
+
+// Returns the factory of the FlightController which speaks the SDK text protocol to the drone at the address.
+func NewTelloSDKFlightControllerFactory(config DroneConfig) FlightControllerFactory {
+	return func() FlightController {
+		return &TelloSDKFlightController{
+			config:          config,
+			responseChannel: make(chan string, 1),
+			stopChannel:     make(chan struct{}),
+			rcScale:         telloSDKSlowRCScale,
+		}
+	}
+}
+
+func (c *TelloSDKFlightController) Connect() error {
+	droneAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(c.config.Host, strconv.Itoa(telloSDKCommandPort)))
+	if err != nil {
+		return err
+	}
+	localPort, err := strconv.Atoi(c.config.LocalPort)
+	if err != nil {
+		return fmt.Errorf("invalid local port '%v'", c.config.LocalPort)
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: localPort})
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.droneAddr = droneAddr
+	go c.readResponses()
+
+	host := droneAddr.IP.String()
+	if c.flightDataHandler != nil {
+		if err := registerTelloSDKHandler(telloSDKStatePort, host, c.handleState); err != nil {
+			return err
+		}
+	}
+	if c.videoFrameHandler != nil {
+		if err := registerTelloSDKHandler(telloSDKVideoPort, host, c.videoFrameHandler); err != nil {
+			return err
+		}
+	}
+
+	// Enters the SDK mode. The drone starts sending its state after this.
+	if err := c.sendAndWait("command"); err != nil {
+		return err
+	}
+	if err := c.StartVideo(); err != nil {
+		return err
+	}
+	go c.keepAlive()
+
+	return nil
+}
+
+func (c *TelloSDKFlightController) Disconnect() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	select {
+	case <-c.stopChannel:
+		return nil
+	default:
+	}
+	close(c.stopChannel)
+
+	if c.conn == nil {
+		return nil
+	}
+	host := c.droneAddr.IP.String()
+	unregisterTelloSDKHandler(telloSDKStatePort, host)
+	unregisterTelloSDKHandler(telloSDKVideoPort, host)
+	return c.conn.Close()
+}
+
+func (c *TelloSDKFlightController) TakeOff() error {
+	return c.send("takeoff")
+}
+
+func (c *TelloSDKFlightController) Land() error {
+	return c.send("land")
+}
+
+func (c *TelloSDKFlightController) Emergency() error {
+	return c.send("emergency")
+}
+
+func (c *TelloSDKFlightController) ThrowTakeOff() error {
+	return errTelloSDKUnsupported(DRONE_ACTION_THROW_TAKE_OFF)
+}
+
+func (c *TelloSDKFlightController) PalmLand() error {
+	return errTelloSDKUnsupported(DRONE_ACTION_PALM_LAND)
+}
+
+func (c *TelloSDKFlightController) Flip(direction string) error {
+	switch direction {
+	case FLIP_DIRECTION_FRONT:
+		return c.send("flip f")
+	case FLIP_DIRECTION_BACK:
+		return c.send("flip b")
+	case FLIP_DIRECTION_LEFT:
+		return c.send("flip l")
+	case FLIP_DIRECTION_RIGHT:
+		return c.send("flip r")
+	}
+	return fmt.Errorf("invalid flip direction '%v'", direction)
+}
+
+func (c *TelloSDKFlightController) Bounce() error {
+	return errTelloSDKUnsupported(DRONE_ACTION_BOUNCE)
+}
+
+func (c *TelloSDKFlightController) Hover() error {
+	return c.send("stop")
+}
+
+func (c *TelloSDKFlightController) Rotate(degrees int) error {
+	if 0 <= degrees {
+		return c.send(fmt.Sprintf("cw %d", degrees))
+	}
+	return c.send(fmt.Sprintf("ccw %d", -degrees))
+}
+
+// 'cw' and 'ccw' stop by themselves.
+func (c *TelloSDKFlightController) CeaseRotation() error {
+	return nil
+}
+
+func (c *TelloSDKFlightController) OnRotationEnd(handler func()) {
+}
+
+// The SDK's 'speed' only applies to the distance commands, so the mode scales the 'rc' commands instead.
+func (c *TelloSDKFlightController) SetFastMode(isFast bool) error {
+	if isFast {
+		atomic.StoreInt32(&c.rcScale, telloSDKFastRCScale)
+	} else {
+		atomic.StoreInt32(&c.rcScale, telloSDKSlowRCScale)
+	}
+	return nil
+}
+
+func (c *TelloSDKFlightController) TakePicture() error {
+	return errTelloSDKUnsupported("takePicture")
+}
+
+func (c *TelloSDKFlightController) OnPicture(handler func(data []byte)) {
+}
+
+func (c *TelloSDKFlightController) SetVector(mVec MotionVector) error {
+	scale := float64(atomic.LoadInt32(&c.rcScale))
+	toRC := func(v float32) int {
+		return int(math.Round(math.Max(-1, math.Min(1, float64(v))) * scale))
+	}
+	return c.send(fmt.Sprintf("rc %d %d %d %d", toRC(mVec.X), toRC(mVec.Y), toRC(mVec.Z), toRC(mVec.R)))
+}
+
+func (c *TelloSDKFlightController) StartVideo() error {
+	return c.send("streamon")
+}
+
+// The SDK 2.0 cannot change the video bit rate. The drone decides it.
+func (c *TelloSDKFlightController) SetVideoBitRate(bitrateMB float64) (float64, error) {
+	return 0, errTelloSDKUnsupported("setVideoBitRate")
+}
+
+func (c *TelloSDKFlightController) OnFlightData(handler func(flightData FlightData)) {
+	c.flightDataHandler = handler
+}
+
+// The Tello sends the H.264 stream in UDP packets, which Drone assembles into access units.
+func (c *TelloSDKFlightController) OnVideoFrame(handler func(data []byte)) {
+	c.videoFrameHandler = handler
+}
+
+func (c *TelloSDKFlightController) send(command string) error {
+	if c.conn == nil {
+		return fmt.Errorf("the drone is not connected")
+	}
+	atomic.StoreInt64(&c.lastSentAt, time.Now().UnixNano())
+	_, err := c.conn.WriteToUDP([]byte(command), c.droneAddr)
+	return err
+}
+
+// Sends the command and waits for 'ok'. The other commands are not waited for
+// because, for example, 'takeoff' responds after the drone climbs and would block the command loop.
+func (c *TelloSDKFlightController) sendAndWait(command string) error {
+	if err := c.send(command); err != nil {
+		return err
+	}
+
+	select {
+	case response := <-c.responseChannel:
+		if response != "ok" {
+			return fmt.Errorf("the drone responds '%v' to '%v'", response, command)
+		}
+		return nil
+	case <-time.After(telloSDKResponseTimeout):
+		return fmt.Errorf("the drone does not respond to '%v'", command)
+	}
+}
+
+func (c *TelloSDKFlightController) readResponses() {
+	buf := make([]byte, 1024)
+	for {
+		n, _, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		response := strings.TrimSpace(string(buf[:n]))
+		if strings.HasPrefix(response, "error") {
+			applog.Warn("Drone '%v' responds '%v'.", c.config.Id, response)
+		}
+
+		select {
+		case c.responseChannel <- response:
+		default:
+		}
+	}
+}
+
+func (c *TelloSDKFlightController) keepAlive() {
+	ticker := time.NewTicker(telloSDKKeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopChannel:
+			return
+		case <-ticker.C:
+			lastSentAt := time.Unix(0, atomic.LoadInt64(&c.lastSentAt))
+			if time.Since(lastSentAt) < telloSDKKeepAliveInterval {
+				continue
+			}
+			if err := c.send("command"); err != nil {
+				applog.Warn("Fails to keep the connection to drone '%v' alive. %v", c.config.Id, err)
+			}
+		}
+	}
+}
+
+func (c *TelloSDKFlightController) handleState(data []byte) {
+	fd, err := ParseTelloSDKState(string(data))
+	if err != nil {
+		applog.Debug("Ignores the state of drone '%v'. %v", c.config.Id, err)
+		return
+	}
+	c.flightDataHandler(fd)
+}
+
+// Parses the state string the Tello sends to port 8890, for example,
+// 'pitch:0;roll:0;yaw:0;vgx:0;vgy:0;vgz:0;templ:60;temph:62;tof:10;h:0;bat:87;baro:120.38;time:0;agx:0.00;agy:0.00;agz:-998.00;'.
+// Heights are reported in centimeters and speeds in decimeters per second.
+// The SDK has no IMU state, so the IMU is regarded as OK. The drone is regarded as flying when it is above the takeoff height.
+func ParseTelloSDKState(state string) (FlightData, error) {
+	values := make(map[string]string)
+	for _, field := range strings.Split(strings.TrimSpace(state), ";") {
+		keyAndValue := strings.SplitN(field, ":", 2)
+		if len(keyAndValue) == 2 {
+			values[keyAndValue[0]] = keyAndValue[1]
+		}
+	}
+
+	var parseErr error
+	number := func(key string) float64 {
+		value, ok := values[key]
+		if !ok {
+			parseErr = fmt.Errorf("'%v' is missing", key)
+			return 0
+		}
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			parseErr = fmt.Errorf("'%v' is not a number. %v", key, err)
+		}
+		return n
+	}
+
+	battery := number("bat")
+	height := number("h") / 100.0
+	northSpeed := number("vgx") / 10.0
+	eastSpeed := number("vgy") / 10.0
+	verticalSpeed := number("vgz") / 10.0
+	flightTime := number("time")
+	if parseErr != nil {
+		return FlightData{}, parseErr
+	}
+
+	isFlying := 0 < height
+	return FlightData{
+		BatteryPercentage: int(battery),
+		Height:            height,
+		GroundSpeed:       math.Hypot(northSpeed, eastSpeed),
+		VerticalSpeed:     verticalSpeed,
+		NorthSpeed:        northSpeed,
+		EastSpeed:         eastSpeed,
+		Flying:            isFlying,
+		OnGround:          !isFlying,
+		ImuOk:             true,
+		FlightTime:        flightTime,
+	}, nil
+}
+
+func errTelloSDKUnsupported(command string) error {
+	return fmt.Errorf("'%v' is not supported by the Tello SDK", command)
+}
+
+// A UDP listener shared among the drones. The packets are dispatched by the address of the drone.
+type telloSDKListener struct {
+	conn     *net.UDPConn
+	handlers map[string]func(data []byte)
+	mutex    sync.Mutex
+}
+
+var telloSDKListeners = make(map[int]*telloSDKListener)
+var telloSDKListenersMux sync.Mutex
+
+// Starts listening on the port if nobody does and dispatches the packets from the host to the handler.
+// The listener keeps listening after the handlers are unregistered so that a restarted drone can use it again.
+func registerTelloSDKHandler(port int, host string, handler func(data []byte)) error {
+	telloSDKListenersMux.Lock()
+	defer telloSDKListenersMux.Unlock()
+
+	l, ok := telloSDKListeners[port]
+	if !ok {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+		if err != nil {
+			return err
+		}
+		l = &telloSDKListener{
+			conn:     conn,
+			handlers: make(map[string]func(data []byte)),
+		}
+		telloSDKListeners[port] = l
+		go l.listen()
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.handlers[host] = handler
+	return nil
+}
+
+func unregisterTelloSDKHandler(port int, host string) {
+	telloSDKListenersMux.Lock()
+	l, ok := telloSDKListeners[port]
+	telloSDKListenersMux.Unlock()
+	if !ok {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.handlers, host)
+}
+
+func (l *telloSDKListener) listen() {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			applog.Warn("Stops listening to the drones. %v", err)
+
+			telloSDKListenersMux.Lock()
+			delete(telloSDKListeners, l.conn.LocalAddr().(*net.UDPAddr).Port)
+			telloSDKListenersMux.Unlock()
+			return
+		}
+
+		l.mutex.Lock()
+		handler := l.handlers[addr.IP.String()]
+		l.mutex.Unlock()
+
+		if handler != nil {
+			handler(append([]byte(nil), buf[:n]...))
+		}
+	}
+}
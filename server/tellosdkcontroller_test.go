@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseTelloSDKState(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    string
+		isValid  bool
+		expected FlightData
+	}{
+		{
+			name:    "Tello on the ground",
+			state:   "pitch:0;roll:0;yaw:0;vgx:0;vgy:0;vgz:0;templ:60;temph:62;tof:10;h:0;bat:87;baro:120.38;time:0;agx:0.00;agy:0.00;agz:-998.00;\r\n",
+			isValid: true,
+			expected: FlightData{
+				BatteryPercentage: 87,
+				OnGround:          true,
+				ImuOk:             true,
+			},
+		},
+		{
+			name:    "Tello EDU flying with the mission pad fields",
+			state:   "mid:-1;x:0;y:0;z:0;mpry:0,0,0;pitch:1;roll:-2;yaw:45;vgx:3;vgy:-4;vgz:-1;templ:83;temph:85;tof:120;h:110;bat:51;baro:194.47;time:23;agx:-6.00;agy:1.00;agz:-999.00;\r\n",
+			isValid: true,
+			expected: FlightData{
+				BatteryPercentage: 51,
+				Height:            1.1,
+				GroundSpeed:       0.5,
+				VerticalSpeed:     -0.1,
+				NorthSpeed:        0.3,
+				EastSpeed:         -0.4,
+				Flying:            true,
+				ImuOk:             true,
+				FlightTime:        23,
+			},
+		},
+		{
+			name:    "missing battery",
+			state:   "pitch:0;roll:0;yaw:0;vgx:0;vgy:0;vgz:0;templ:60;temph:62;tof:10;h:0;baro:120.38;time:0;agx:0.00;agy:0.00;agz:-998.00;\r\n",
+			isValid: false,
+		},
+		{
+			name:    "non-numeric height",
+			state:   "pitch:0;roll:0;yaw:0;vgx:0;vgy:0;vgz:0;templ:60;temph:62;tof:10;h:high;bat:87;baro:120.38;time:0;agx:0.00;agy:0.00;agz:-998.00;\r\n",
+			isValid: false,
+		},
+		{
+			name:    "a response to a command",
+			state:   "ok",
+			isValid: false,
+		},
+		{
+			name:    "empty",
+			state:   "",
+			isValid: false,
+		},
+	}
+
+	for _, test := range tests {
+		actual, err := ParseTelloSDKState(test.state)
+		if !test.isValid {
+			if err == nil {
+				t.Errorf("%v: %+v is parsed from an invalid state", test.name, actual)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+
+		e := test.expected
+		if actual.BatteryPercentage != e.BatteryPercentage || actual.Flying != e.Flying || actual.OnGround != e.OnGround ||
+			actual.ImuOk != e.ImuOk || actual.FlightTime != e.FlightTime {
+			t.Errorf("%v: %+v, want %+v", test.name, actual, e)
+		}
+		for _, pair := range [][2]float64{
+			{actual.Height, e.Height},
+			{actual.GroundSpeed, e.GroundSpeed},
+			{actual.VerticalSpeed, e.VerticalSpeed},
+			{actual.NorthSpeed, e.NorthSpeed},
+			{actual.EastSpeed, e.EastSpeed},
+		} {
+			if 1e-9 < math.Abs(pair[0]-pair[1]) {
+				t.Errorf("%v: %+v, want %+v", test.name, actual, e)
+				break
+			}
+		}
+	}
+}
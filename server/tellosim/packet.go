@@ -0,0 +1,130 @@
+package tellosim
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The binary protocol gobot's Tello driver speaks.
+// Reference: gobot.io/x/gobot/platforms/dji/tello driver.go and crc.go
+const (
+	messageStart = 0xcc
+
+	wifiMessage             = 26
+	videoEncoderRateCommand = 32
+	videoStartCommand       = 37
+	lightMessage            = 53
+	timeCommand             = 70
+	stickCommand            = 80
+	takeoffCommand          = 84
+	landCommand             = 85
+	flightMessage           = 86
+	flipCommand             = 92
+	throwtakeoffCommand     = 93
+	palmLandCommand         = 94
+	logMessage              = 4176
+	bounceCommand           = 4179
+
+	packetHeaderSize  = 9
+	packetTrailerSize = 2
+)
+
+const (
+	connectRequestPrefix     = "conn_req:"
+	connectAcknowledgePrefix = "conn_ack:"
+	// The driver sends the SDK text commands to stop the motors since the binary protocol has no command for it.
+	sdkModeCommand      = "command"
+	sdkEmergencyCommand = "emergency"
+)
+
+type packet struct {
+	packetType byte
+	command    uint16
+	sequence   uint16
+	payload    []byte
+}
+
+func (p *packet) marshal() []byte {
+	size := packetHeaderSize + len(p.payload) + packetTrailerSize
+
+	buf := make([]byte, size)
+	buf[0] = messageStart
+	binary.LittleEndian.PutUint16(buf[1:3], uint16(size<<3))
+	buf[3] = crc8(buf[0:3])
+	buf[4] = p.packetType
+	binary.LittleEndian.PutUint16(buf[5:7], p.command)
+	binary.LittleEndian.PutUint16(buf[7:9], p.sequence)
+	copy(buf[packetHeaderSize:], p.payload)
+	binary.LittleEndian.PutUint16(buf[size-packetTrailerSize:], crc16(buf[0:size-packetTrailerSize]))
+
+	return buf
+}
+
+func unmarshalPacket(buf []byte) (*packet, error) {
+	if len(buf) < packetHeaderSize+packetTrailerSize || buf[0] != messageStart {
+		return nil, errors.New("not a tello packet")
+	}
+
+	size := int(binary.LittleEndian.Uint16(buf[1:3]) >> 3)
+	if size < packetHeaderSize+packetTrailerSize || len(buf) < size {
+		return nil, errors.New("invalid tello packet size")
+	}
+
+	return &packet{
+		packetType: buf[4],
+		command:    binary.LittleEndian.Uint16(buf[5:7]),
+		sequence:   binary.LittleEndian.Uint16(buf[7:9]),
+		payload:    buf[packetHeaderSize : size-packetTrailerSize],
+	}, nil
+}
+
+// CRC-8 (reflected polynomial 0x8c, seed 0x77) used in the header.
+func crc8(b []byte) byte {
+	crc := byte(0x77)
+	for _, v := range b {
+		crc ^= v
+		for i := 0; i < 8; i++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ 0x8c
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// CRC-16 (reflected polynomial 0x8408, seed 0x3692) used in the trailer.
+func crc16(b []byte) uint16 {
+	crc := uint16(0x3692)
+	for _, v := range b {
+		crc ^= uint16(v)
+		for i := 0; i < 8; i++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ 0x8408
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Decodes the stick values gobot packs into 11 bits per axis.
+// Returns (lr, fb, ud, r) in the range of -1.0 to 1.0.
+func decodeSticks(payload []byte) (float32, float32, float32, float32, error) {
+	if len(payload) < 6 {
+		return 0, 0, 0, 0, errors.New("invalid stick command")
+	}
+
+	var packedAxis uint64
+	for i := 5; i >= 0; i-- {
+		packedAxis = packedAxis<<8 | uint64(payload[i])
+	}
+
+	axis := func(shift uint) float32 {
+		return (float32(packedAxis>>shift&0x7ff) - 1024) / 660
+	}
+
+	return axis(0), axis(11), axis(22), axis(33), nil
+}
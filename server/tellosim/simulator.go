@@ -0,0 +1,475 @@
+// Package tellosim provides a simulated Tello which answers gobot's Tello driver over UDP.
+// It enables developing and testing the application on a host without a physical drone.
+package tellosim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/st-user/ojm-drone-local/applog"
+)
+
+const (
+	defaultVideoWidth  = 192
+	defaultVideoHeight = 144
+
+	flightDataInterval = 100 * time.Millisecond
+	wifiDataInterval   = 1 * time.Second
+	videoFrameInterval = 66 * time.Millisecond
+	maxVideoPacketSize = 1460
+
+	// Units the Tello reports in flight data
+	takeoffHeight = 8  // :dm
+	maxSpeed      = 10 // :dm/s at full stick
+	maxYawRate    = 90 // :deg/s at full stick
+
+	// About 13 minutes of flight like the Tello.
+	defaultBatteryDrainPerSecond = 0.13
+)
+
+const (
+	flyModeOnGround = 1
+	flyModeHover    = 6
+	flyModeTakeoff  = 11
+	flyModeLanding  = 12
+)
+
+type Options struct {
+	// The address the simulator receives commands on. Defaults to '127.0.0.1:8889'.
+	CommandAddr string
+	// The host video packets are sent to. The port is given by the 'conn_req' the driver sends.
+	VideoHost string
+	// Optional path to an Annex-B H.264 elementary stream which is streamed instead of the generated one.
+	VideoFile string
+	// Battery percentage consumed per second while flying. Idling consumes a tenth of it.
+	BatteryDrainPerSecond float64
+}
+
+type Simulator struct {
+	options     Options
+	conn        *net.UDPConn
+	videoSource videoSource
+	state       simulatedState
+	mutex       sync.Mutex
+	stopChannel chan struct{}
+	waitGroup   sync.WaitGroup
+}
+
+// The simulated aircraft. Positions are relative to the point where the simulator starts.
+type simulatedState struct {
+	driverAddr   *net.UDPAddr
+	videoAddr    *net.UDPAddr
+	flying       bool
+	flyMode      int8
+	height       float64 // :dm
+	north        float64 // :dm
+	east         float64 // :dm
+	yaw          float64 // :deg
+	northSpeed   float64 // :dm/s
+	eastSpeed    float64 // :dm/s
+	verticalSpd  float64 // :dm/s
+	battery      float64 // :%
+	flyTime      float64 // :s
+	sticks       [4]float32
+	fastMode     bool
+	sequence     uint16
+	lastUpdateAt time.Time
+}
+
+func NewSimulator(options Options) *Simulator {
+	if options.CommandAddr == "" {
+		options.CommandAddr = "127.0.0.1:8889"
+	}
+	if options.VideoHost == "" {
+		options.VideoHost = "127.0.0.1"
+	}
+	if options.BatteryDrainPerSecond <= 0 {
+		options.BatteryDrainPerSecond = defaultBatteryDrainPerSecond
+	}
+
+	return &Simulator{
+		options: options,
+		state: simulatedState{
+			flyMode: flyModeOnGround,
+			battery: 100,
+		},
+	}
+}
+
+func (s *Simulator) Start() error {
+	addr, err := net.ResolveUDPAddr("udp", s.options.CommandAddr)
+	if err != nil {
+		return err
+	}
+
+	s.videoSource = newSyntheticVideoSource(defaultVideoWidth, defaultVideoHeight)
+	if s.options.VideoFile != "" {
+		fileSource, err := newFileVideoSource(s.options.VideoFile)
+		if err != nil {
+			return err
+		}
+		s.videoSource = fileSource
+	}
+
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.stopChannel = make(chan struct{})
+	s.state.lastUpdateAt = time.Now()
+
+	s.waitGroup.Add(3)
+	go s.receiveLoop()
+	go s.telemetryLoop()
+	go s.videoLoop()
+
+	applog.Info("Tello simulator listens on %v.", s.options.CommandAddr)
+	return nil
+}
+
+func (s *Simulator) Stop() {
+	if s.stopChannel == nil {
+		return
+	}
+	close(s.stopChannel)
+	s.conn.Close()
+	s.waitGroup.Wait()
+	s.stopChannel = nil
+	applog.Info("Tello simulator stopped.")
+}
+
+func (s *Simulator) receiveLoop() {
+	defer s.waitGroup.Done()
+
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-s.stopChannel:
+				return
+			default:
+				applog.Debug("Tello simulator fails to read. %v", err)
+				continue
+			}
+		}
+		s.handleMessage(buf[:n], from)
+	}
+}
+
+func (s *Simulator) handleMessage(message []byte, from *net.UDPAddr) {
+	if bytes.HasPrefix(message, []byte(connectRequestPrefix)) {
+		s.handleConnectRequest(message, from)
+		return
+	}
+	switch string(message) {
+	case sdkModeCommand:
+		return
+	case sdkEmergencyCommand:
+		s.handleEmergency()
+		return
+	}
+
+	p, err := unmarshalPacket(message)
+	if err != nil {
+		applog.Debug("Tello simulator ignores a message. %v", err)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch p.command {
+	case stickCommand:
+		lr, fb, ud, r, err := decodeSticks(p.payload)
+		if err != nil {
+			applog.Debug("Tello simulator ignores a stick command. %v", err)
+			return
+		}
+		s.state.sticks = [4]float32{lr, fb, ud, r}
+		// gobot packs the throttle(fast mode) at the 44th bit.
+		s.state.fastMode = p.payload[5]>>4&1 == 1
+	case takeoffCommand, throwtakeoffCommand:
+		if !s.state.flying && s.state.battery > 0 {
+			applog.Info("Tello simulator takes off.")
+			s.state.flying = true
+			s.state.flyMode = flyModeTakeoff
+		}
+		s.reply(p)
+	case landCommand, palmLandCommand:
+		if s.state.flying {
+			applog.Info("Tello simulator lands.")
+			s.state.flyMode = flyModeLanding
+		}
+		s.reply(p)
+	case flipCommand, bounceCommand:
+		s.reply(p)
+	case videoStartCommand, videoEncoderRateCommand, timeCommand:
+		// Every frame the simulator sends is a key frame and the bit rate is fixed.
+	default:
+		applog.Debug("Tello simulator ignores a command %v.", p.command)
+	}
+}
+
+func (s *Simulator) handleConnectRequest(message []byte, from *net.UDPAddr) {
+	portBytes := message[len(connectRequestPrefix):]
+	if len(portBytes) < 2 {
+		applog.Warn("Tello simulator receives an invalid connect request.")
+		return
+	}
+	videoPort := int(binary.LittleEndian.Uint16(portBytes))
+	videoAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(s.options.VideoHost, strconv.Itoa(videoPort)))
+	if err != nil {
+		applog.Warn("Tello simulator fails to resolve the video address. %v", err)
+		return
+	}
+
+	s.mutex.Lock()
+	s.state.driverAddr = from
+	s.state.videoAddr = videoAddr
+	s.mutex.Unlock()
+
+	applog.Info("Tello simulator is connected from %v. Video is sent to %v.", from, videoAddr)
+	ack := append([]byte(connectAcknowledgePrefix), portBytes[0:2]...)
+	if _, err := s.conn.WriteToUDP(ack, from); err != nil {
+		applog.Warn("Tello simulator fails to acknowledge. %v", err)
+	}
+}
+
+// Stops the motors. The aircraft drops to the ground.
+func (s *Simulator) handleEmergency() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	applog.Info("Tello simulator stops the motors.")
+	s.state.flying = false
+	s.state.flyMode = flyModeOnGround
+	s.state.height = 0
+	s.state.sticks = [4]float32{}
+}
+
+// Echoes a command back in the same way the Tello acknowledges it. Has to be called with the mutex locked.
+func (s *Simulator) reply(p *packet) {
+	if s.state.driverAddr == nil {
+		return
+	}
+	ack := packet{
+		packetType: 0x50,
+		command:    p.command,
+		sequence:   p.sequence,
+		payload:    []byte{0},
+	}
+	s.conn.WriteToUDP(ack.marshal(), s.state.driverAddr)
+}
+
+func (s *Simulator) telemetryLoop() {
+	defer s.waitGroup.Done()
+
+	flightDataTicker := time.NewTicker(flightDataInterval)
+	defer flightDataTicker.Stop()
+	wifiDataTicker := time.NewTicker(wifiDataInterval)
+	defer wifiDataTicker.Stop()
+
+	for {
+		select {
+		case <-s.stopChannel:
+			return
+		case <-flightDataTicker.C:
+			s.mutex.Lock()
+			s.updateState()
+			s.send(flightMessage, s.flightDataPayload())
+			s.mutex.Unlock()
+		case <-wifiDataTicker.C:
+			s.mutex.Lock()
+			s.send(wifiMessage, []byte{90, 0})
+			s.send(lightMessage, []byte{0})
+			s.mutex.Unlock()
+		}
+	}
+}
+
+// Advances the simulated aircraft. Has to be called with the mutex locked.
+func (s *Simulator) updateState() {
+	now := time.Now()
+	elapsed := now.Sub(s.state.lastUpdateAt).Seconds()
+	s.state.lastUpdateAt = now
+
+	st := &s.state
+
+	if st.flying {
+		st.battery -= s.options.BatteryDrainPerSecond * elapsed
+		st.flyTime += elapsed
+	} else {
+		st.battery -= s.options.BatteryDrainPerSecond / 10 * elapsed
+	}
+	if st.battery < 0 {
+		st.battery = 0
+	}
+
+	if st.flying && st.battery <= 0 && st.flyMode != flyModeLanding {
+		applog.Info("Tello simulator lands because its battery runs out.")
+		st.flyMode = flyModeLanding
+	}
+
+	st.northSpeed = 0
+	st.eastSpeed = 0
+	st.verticalSpd = 0
+
+	switch st.flyMode {
+	case flyModeTakeoff:
+		st.verticalSpd = maxSpeed / 2
+		if st.height >= takeoffHeight {
+			st.flyMode = flyModeHover
+		}
+	case flyModeLanding:
+		st.verticalSpd = -maxSpeed / 2
+		if st.height <= 0 {
+			st.flying = false
+			st.flyMode = flyModeOnGround
+			st.sticks = [4]float32{}
+		}
+	case flyModeHover:
+		lr, fb, ud, r := float64(st.sticks[0]), float64(st.sticks[1]), float64(st.sticks[2]), float64(st.sticks[3])
+		speed := float64(maxSpeed)
+		if st.fastMode {
+			speed = speed * 2
+		}
+
+		st.yaw = math.Mod(st.yaw+r*maxYawRate*elapsed+360, 360)
+		rad := st.yaw * math.Pi / 180
+		st.northSpeed = speed * (fb*math.Cos(rad) - lr*math.Sin(rad))
+		st.eastSpeed = speed * (fb*math.Sin(rad) + lr*math.Cos(rad))
+		st.verticalSpd = speed * ud
+	}
+
+	st.north += st.northSpeed * elapsed
+	st.east += st.eastSpeed * elapsed
+	st.height += st.verticalSpd * elapsed
+	if st.height < 0 {
+		st.height = 0
+	}
+}
+
+// Encodes the state in the layout 'tello.ParseFlightData' reads. Has to be called with the mutex locked.
+func (s *Simulator) flightDataPayload() []byte {
+	st := &s.state
+	buf := &bytes.Buffer{}
+
+	write := func(v interface{}) {
+		binary.Write(buf, binary.LittleEndian, v)
+	}
+	flag := func(b bool, shift uint) byte {
+		if b {
+			return 1 << shift
+		}
+		return 0
+	}
+
+	battery := int8(math.Ceil(st.battery))
+
+	write(int16(st.height))
+	write(int16(st.northSpeed))
+	write(int16(st.eastSpeed))
+	write(int16(st.verticalSpd))
+	write(int16(st.flyTime * 10))
+	// imu, pressure, down visual, power, battery and gravity states are all fine.
+	write(byte(0b00111111))
+	write(int8(0)) // imu calibration state
+	write(battery)
+	write(int16((100 - st.flyTime) * 10)) // fly time left
+	write(int16(3400 + int(battery)*8))   // battery left(mV)
+	write(flag(st.flying, 0) |
+		flag(!st.flying, 1) |
+		flag(st.flyMode == flyModeHover, 3) |
+		flag(battery < 20, 5) |
+		flag(battery < 10, 6))
+	write(st.flyMode)
+	write(int8(0)) // throw fly timer
+	write(int8(0)) // camera state
+	write(byte(0)) // electrical machinery state
+	write(byte(0)) // front in/out/lsc
+	write(byte(0)) // temperature high
+
+	return buf.Bytes()
+}
+
+// Has to be called with the mutex locked.
+func (s *Simulator) send(command uint16, payload []byte) {
+	if s.state.driverAddr == nil {
+		return
+	}
+	s.state.sequence++
+	p := packet{
+		packetType: 0x88,
+		command:    command,
+		sequence:   s.state.sequence,
+		payload:    payload,
+	}
+	if _, err := s.conn.WriteToUDP(p.marshal(), s.state.driverAddr); err != nil {
+		applog.Debug("Tello simulator fails to send. %v", err)
+	}
+}
+
+func (s *Simulator) videoLoop() {
+	defer s.waitGroup.Done()
+
+	ticker := time.NewTicker(videoFrameInterval)
+	defer ticker.Stop()
+
+	var videoConn *net.UDPConn
+	defer func() {
+		if videoConn != nil {
+			videoConn.Close()
+		}
+	}()
+
+	var packetSeq byte
+	for {
+		select {
+		case <-s.stopChannel:
+			return
+		case <-ticker.C:
+			s.mutex.Lock()
+			videoAddr := s.state.videoAddr
+			s.mutex.Unlock()
+
+			if videoAddr == nil {
+				continue
+			}
+			if videoConn == nil || videoConn.RemoteAddr().String() != videoAddr.String() {
+				if videoConn != nil {
+					videoConn.Close()
+				}
+				conn, err := net.DialUDP("udp", nil, videoAddr)
+				if err != nil {
+					applog.Warn("Tello simulator fails to open the video connection. %v", err)
+					continue
+				}
+				videoConn = conn
+			}
+
+			// Like the Tello, every NAL unit starts at the head of a packet
+			// and each packet has a 2 bytes header which gobot strips off.
+			for _, nalUnit := range splitNalUnits(s.videoSource.NextAccessUnit()) {
+				for i := 0; i < len(nalUnit); i += maxVideoPacketSize {
+					end := i + maxVideoPacketSize
+					if end > len(nalUnit) {
+						end = len(nalUnit)
+					}
+					pkt := append([]byte{packetSeq, 0}, nalUnit[i:end]...)
+					packetSeq++
+					if _, err := videoConn.Write(pkt); err != nil {
+						applog.Debug("Tello simulator fails to send a video packet. %v", err)
+						break
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package tellosim
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+)
+
+var nalUnitStartCode = []byte{0, 0, 0, 1}
+
+// Provides access units (SPS + PPS + IDR or a single slice) of an H.264 elementary stream in Annex-B format.
+type videoSource interface {
+	NextAccessUnit() []byte
+}
+
+// Replays a canned H.264 elementary stream (e.g. recorded by 'ffmpeg -i in.mp4 -c:v libx264 -bsf h264_mp4toannexb out.h264')
+// frame by frame and loops at the end of the file.
+type fileVideoSource struct {
+	accessUnits [][]byte
+	index       int
+}
+
+func newFileVideoSource(path string) (*fileVideoSource, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var accessUnits [][]byte
+	var current []byte
+	currentHasSlice := false
+	for _, nalUnit := range splitNalUnits(body) {
+		nalUnitType := nalUnit[len(nalUnitStartCode)] & 0b11111
+
+		// An SPS or a slice starts a new access unit unless the current one only has parameter sets.
+		if (nalUnitType == 7 || nalUnitType == 1) && currentHasSlice {
+			accessUnits = append(accessUnits, current)
+			current = nil
+			currentHasSlice = false
+		}
+		current = append(current, nalUnit...)
+		if nalUnitType == 1 || nalUnitType == 5 {
+			currentHasSlice = true
+		}
+	}
+	if currentHasSlice {
+		accessUnits = append(accessUnits, current)
+	}
+
+	if len(accessUnits) == 0 {
+		return nil, errors.New("no H.264 frame is found in " + path)
+	}
+
+	return &fileVideoSource{
+		accessUnits: accessUnits,
+	}, nil
+}
+
+func (s *fileVideoSource) NextAccessUnit() []byte {
+	au := s.accessUnits[s.index]
+	s.index = (s.index + 1) % len(s.accessUnits)
+	return au
+}
+
+func splitNalUnits(b []byte) [][]byte {
+	var nalUnits [][]byte
+	for {
+		start := bytes.Index(b, nalUnitStartCode)
+		if start < 0 {
+			return nalUnits
+		}
+		next := bytes.Index(b[start+len(nalUnitStartCode):], nalUnitStartCode)
+		if next < 0 {
+			if len(b) > start+len(nalUnitStartCode) {
+				nalUnits = append(nalUnits, b[start:])
+			}
+			return nalUnits
+		}
+		end := start + len(nalUnitStartCode) + next
+		if end > start+len(nalUnitStartCode) {
+			nalUnits = append(nalUnits, b[start:end])
+		}
+		b = b[end:]
+	}
+}
+
+// Generates a baseline profile stream without any external file. The size has to be a multiple of 16.
+// Every frame is an IDR picture consisting of I_PCM macroblocks (i.e. uncompressed samples),
+// which is trivial to encode and can be decoded by any H.264 decoder.
+type syntheticVideoSource struct {
+	widthInMbs  int
+	heightInMbs int
+	frameNum    int
+}
+
+func newSyntheticVideoSource(width int, height int) *syntheticVideoSource {
+	return &syntheticVideoSource{
+		widthInMbs:  width / 16,
+		heightInMbs: height / 16,
+	}
+}
+
+func (s *syntheticVideoSource) NextAccessUnit() []byte {
+	var au []byte
+	au = append(au, s.sps()...)
+	au = append(au, s.pps()...)
+	au = append(au, s.idrSlice()...)
+	s.frameNum++
+	return au
+}
+
+func (s *syntheticVideoSource) sps() []byte {
+	w := bitWriter{}
+	w.writeBits(66, 8)   // profile_idc: Baseline
+	w.writeBits(0xe0, 8) // constraint_set0,1,2_flag
+	w.writeBits(31, 8)   // level_idc: 3.1
+	w.writeUE(0)         // seq_parameter_set_id
+	w.writeUE(0)         // log2_max_frame_num_minus4
+	w.writeUE(2)         // pic_order_cnt_type
+	w.writeUE(0)         // max_num_ref_frames
+	w.writeBits(0, 1)    // gaps_in_frame_num_value_allowed_flag
+	w.writeUE(uint32(s.widthInMbs - 1))
+	w.writeUE(uint32(s.heightInMbs - 1))
+	w.writeBits(1, 1) // frame_mbs_only_flag
+	w.writeBits(1, 1) // direct_8x8_inference_flag
+	w.writeBits(0, 1) // frame_cropping_flag
+	w.writeBits(0, 1) // vui_parameters_present_flag
+	w.writeTrailingBits()
+	return toNalUnit(0x67, w.bytes())
+}
+
+func (s *syntheticVideoSource) pps() []byte {
+	w := bitWriter{}
+	w.writeUE(0)      // pic_parameter_set_id
+	w.writeUE(0)      // seq_parameter_set_id
+	w.writeBits(0, 1) // entropy_coding_mode_flag: CAVLC
+	w.writeBits(0, 1) // bottom_field_pic_order_in_frame_present_flag
+	w.writeUE(0)      // num_slice_groups_minus1
+	w.writeUE(0)      // num_ref_idx_l0_default_active_minus1
+	w.writeUE(0)      // num_ref_idx_l1_default_active_minus1
+	w.writeBits(0, 1) // weighted_pred_flag
+	w.writeBits(0, 2) // weighted_bipred_idc
+	w.writeSE(0)      // pic_init_qp_minus26
+	w.writeSE(0)      // pic_init_qs_minus26
+	w.writeSE(0)      // chroma_qp_index_offset
+	w.writeBits(1, 1) // deblocking_filter_control_present_flag
+	w.writeBits(0, 1) // constrained_intra_pred_flag
+	w.writeBits(0, 1) // redundant_pic_cnt_present_flag
+	w.writeTrailingBits()
+	return toNalUnit(0x68, w.bytes())
+}
+
+func (s *syntheticVideoSource) idrSlice() []byte {
+	w := bitWriter{}
+	w.writeUE(0)                      // first_mb_in_slice
+	w.writeUE(7)                      // slice_type: I (all slices)
+	w.writeUE(0)                      // pic_parameter_set_id
+	w.writeBits(0, 4)                 // frame_num
+	w.writeUE(uint32(s.frameNum % 2)) // idr_pic_id: has to differ between consecutive IDR pictures
+	w.writeBits(0, 1)                 // no_output_of_prior_pics_flag
+	w.writeBits(0, 1)                 // long_term_reference_flag
+	w.writeSE(0)                      // slice_qp_delta
+	w.writeUE(1)                      // disable_deblocking_filter_idc
+
+	// Moving diagonal stripes so that it is obvious whether the stream is alive.
+	offset := s.frameNum * 4
+	for mbY := 0; mbY < s.heightInMbs; mbY++ {
+		for mbX := 0; mbX < s.widthInMbs; mbX++ {
+			w.writeUE(25) // mb_type: I_PCM
+			w.alignWithZero()
+
+			for y := 0; y < 16; y++ {
+				for x := 0; x < 16; x++ {
+					px := mbX*16 + x
+					py := mbY*16 + y
+					luma := 16 + (px+py+offset)%64*3
+					w.writeBits(uint32(luma), 8)
+				}
+			}
+			cb := 64 + (mbX*8+offset)%128
+			cr := 64 + (mbY*8+offset)%128
+			for i := 0; i < 64; i++ {
+				w.writeBits(uint32(cb), 8)
+			}
+			for i := 0; i < 64; i++ {
+				w.writeBits(uint32(cr), 8)
+			}
+		}
+	}
+	w.writeTrailingBits()
+	return toNalUnit(0x65, w.bytes())
+}
+
+func toNalUnit(header byte, rbsp []byte) []byte {
+	nalUnit := append([]byte{}, nalUnitStartCode...)
+	nalUnit = append(nalUnit, header)
+
+	// emulation prevention
+	zeros := 0
+	for _, b := range rbsp {
+		if zeros >= 2 && b <= 3 {
+			nalUnit = append(nalUnit, 3)
+			zeros = 0
+		}
+		nalUnit = append(nalUnit, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return nalUnit
+}
+
+type bitWriter struct {
+	buf   []byte
+	cur   byte
+	nbits uint
+}
+
+func (w *bitWriter) writeBits(v uint32, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		w.cur = w.cur<<1 | byte(v>>uint(i)&1)
+		w.nbits++
+		if w.nbits == 8 {
+			w.buf = append(w.buf, w.cur)
+			w.cur = 0
+			w.nbits = 0
+		}
+	}
+}
+
+// Exp-Golomb coding
+func (w *bitWriter) writeUE(v uint32) {
+	v++
+	length := uint(0)
+	for t := v; t > 1; t >>= 1 {
+		length++
+	}
+	w.writeBits(0, length)
+	w.writeBits(v, length+1)
+}
+
+func (w *bitWriter) writeSE(v int32) {
+	if v <= 0 {
+		w.writeUE(uint32(-2 * v))
+	} else {
+		w.writeUE(uint32(2*v - 1))
+	}
+}
+
+func (w *bitWriter) alignWithZero() {
+	for w.nbits != 0 {
+		w.writeBits(0, 1)
+	}
+}
+
+func (w *bitWriter) writeTrailingBits() {
+	w.writeBits(1, 1)
+	w.alignWithZero()
+}
+
+func (w *bitWriter) bytes() []byte {
+	return w.buf
+}
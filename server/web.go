@@ -160,7 +160,8 @@ func NewApplicationStatesServer() ApplicationStatesServer {
 func (ws *ApplicationStatesServer) Start(
 	w http.ResponseWriter,
 	r *http.Request,
-	applicationStates *ApplicationStates) {
+	applicationStates *ApplicationStates,
+	droneFleet *DroneFleet) {
 
 	conn, err := ws.upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -186,16 +187,13 @@ func (ws *ApplicationStatesServer) Start(
 				applog.Info("Stop existing ApplicationStatesServer.")
 				return
 			default:
-				data := map[string]interface{}{
-					"messageType": "appInfo",
-					"sessionKey":  applicationStates.GetSessionKey(),
-					"state":       applicationStates.GetState(),
-					"droneState":  applicationStates.GetDroneState(),
-					"droneHealth": map[string]int{
-						"health":       applicationStates.GetDroneHealth().DroneHealth,
-						"batteryLevel": applicationStates.GetDroneHealth().BatteryLevel,
-					},
-				}
+				// The states of the default drone are also put at the top level for the UI flying a single drone.
+				data := droneFleet.Default().Status()
+				data["messageType"] = "appInfo"
+				data["sessionKey"] = applicationStates.GetSessionKey()
+				data["state"] = applicationStates.GetState()
+				data["drones"] = droneFleet.Statuses()
+				data["swarm"] = applicationStates.GetSwarmStatus()
 
 				connMux.Lock()
 
@@ -212,6 +210,37 @@ func (ws *ApplicationStatesServer) Start(
 
 	}()
 
+	go func() {
+		ticker := time.NewTicker(TelemetryPublishInterval())
+		defer ticker.Stop()
+
+		for {
+
+			select {
+			case <-stopChan:
+				applog.Info("Stop publishing telemetry to ApplicationStatesServer.")
+				return
+			case <-ticker.C:
+				for _, unit := range droneFleet.Units() {
+					telemetry := unit.applicationStates.GetTelemetry()
+					if telemetry.IsEmpty() {
+						continue
+					}
+					message := telemetry.ToMessage()
+					message["droneId"] = unit.Id
+
+					connMux.Lock()
+
+					if err := conn.WriteJSON(message); err != nil {
+						applog.Warn(err.Error())
+					}
+
+					connMux.Unlock()
+				}
+			}
+		}
+	}()
+
 	consectiveErrorRead := 0
 	go func() {
 		defer conn.Close()